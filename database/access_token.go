@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+)
+
+// RevokeAccessToken also drops the tokens that have expired since they were
+// revoked, as the signature check rejects those on its own.
+func (repo *sqlRepository) RevokeAccessToken(ctx context.Context, token *models.RevokedAccessToken) error {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	_, deleteErr := tx.ExecContext(
+		ctx,
+		"DELETE FROM revoked_access_tokens WHERE expires_at < $1",
+		token.RevokedAt.UTC(),
+	)
+
+	if deleteErr != nil {
+		return deleteErr
+	}
+
+	_, insertError := tx.ExecContext(
+		ctx,
+		"INSERT INTO revoked_access_tokens (id, user_id, expires_at, revoked_at) VALUES ($1, $2, $3, $4) ON CONFLICT (id) DO NOTHING",
+		token.Id,
+		token.UserId,
+		token.ExpiresAt.UTC(),
+		token.RevokedAt.UTC(),
+	)
+
+	if insertError != nil {
+		return insertError
+	}
+
+	return tx.Commit()
+}
+
+func (repo *sqlRepository) IsAccessTokenRevoked(ctx context.Context, id string) (bool, error) {
+	var revokedId string
+
+	getError := repo.db.QueryRowContext(
+		ctx,
+		"SELECT id FROM revoked_access_tokens WHERE id = $1",
+		id,
+	).Scan(&revokedId)
+
+	if errors.Is(getError, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	if getError != nil {
+		return false, getError
+	}
+
+	return true, nil
+}
+
+func (repo *sqlRepository) RevokeAllTokens(ctx context.Context, userId string, at time.Time) error {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	if err := revokeAllTokens(ctx, tx, userId, at); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// revokeAllTokens does RevokeAllTokens within tx.
+func revokeAllTokens(ctx context.Context, tx *sql.Tx, userId string, at time.Time) error {
+	result, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE users SET token_version = token_version + 1 WHERE id = $1",
+		userId,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	if err := expectRow(result); err != nil {
+		return err
+	}
+
+	_, revokeErr := tx.ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL",
+		at.UTC(),
+		userId,
+	)
+
+	if revokeErr != nil {
+		return revokeErr
+	}
+
+	_, sessionsErr := tx.ExecContext(
+		ctx,
+		"UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL",
+		at.UTC(),
+		userId,
+	)
+
+	return sessionsErr
+}
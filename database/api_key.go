@@ -0,0 +1,230 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+)
+
+const apiKeyColumns = "id, user_id, name, prefix, key_hash, scopes, token_version, created_at, expires_at, last_used_at, revoked_at"
+
+func (repo *sqlRepository) CreateApiKey(ctx context.Context, key *models.ApiKey, event *models.AuditEvent) error {
+	var expiresAt sql.NullTime
+
+	if key.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: key.ExpiresAt.UTC(), Valid: true}
+	}
+
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	_, insertError := tx.ExecContext(
+		ctx,
+		"INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, token_version, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		key.Id,
+		key.UserId,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		joinScopes(key.Scopes),
+		key.TokenVersion,
+		key.CreatedAt.UTC(),
+		expiresAt,
+	)
+
+	if insertError != nil {
+		return insertError
+	}
+
+	if auditErr := insertAuditEvent(ctx, tx, event); auditErr != nil {
+		return auditErr
+	}
+
+	return tx.Commit()
+}
+
+func (repo *sqlRepository) GetApiKeysByUserId(ctx context.Context, userId string) ([]*models.ApiKey, error) {
+	rows, queryErr := repo.db.QueryContext(
+		ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC, id"+repo.dialect.byteOrder+" DESC",
+		userId,
+	)
+
+	if queryErr != nil {
+		return nil, queryErr
+	}
+
+	defer rows.Close()
+
+	var keys = []*models.ApiKey{}
+
+	for rows.Next() {
+		key, scanErr := scanApiKey(rows)
+
+		if scanErr != nil {
+			return nil, scanErr
+		}
+
+		keys = append(keys, key)
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return nil, rowsErr
+	}
+
+	return keys, nil
+}
+
+func (repo *sqlRepository) GetApiKey(ctx context.Context, id string, userId string) (*models.ApiKey, error) {
+	key, getError := scanApiKey(repo.db.QueryRowContext(
+		ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		id,
+		userId,
+	))
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	return key, nil
+}
+
+func (repo *sqlRepository) RenameApiKey(ctx context.Context, id string, userId string, name string) (*models.ApiKey, error) {
+	result, updateErr := repo.db.ExecContext(
+		ctx,
+		"UPDATE api_keys SET name = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL",
+		name,
+		id,
+		userId,
+	)
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+
+	if rowErr := expectRow(result); rowErr != nil {
+		return nil, rowErr
+	}
+
+	return repo.GetApiKey(ctx, id, userId)
+}
+
+func (repo *sqlRepository) RevokeApiKey(ctx context.Context, id string, userId string, at time.Time, event *models.AuditEvent) error {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	result, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $1) WHERE id = $2 AND user_id = $3",
+		at.UTC(),
+		id,
+		userId,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	if err := expectRow(result); err != nil {
+		return err
+	}
+
+	if auditErr := insertAuditEvent(ctx, tx, event); auditErr != nil {
+		return auditErr
+	}
+
+	return tx.Commit()
+}
+
+func (repo *sqlRepository) UseApiKey(ctx context.Context, keyHash string, at time.Time) (*models.ApiKey, error) {
+	_, updateErr := repo.db.ExecContext(
+		ctx,
+		"UPDATE api_keys SET last_used_at = $1 WHERE key_hash = $2 AND revoked_at IS NULL AND (last_used_at IS NULL OR last_used_at < $3)",
+		at.UTC(),
+		keyHash,
+		at.Add(-models.ApiKeyTouchInterval).UTC(),
+	)
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+
+	key, getError := scanApiKey(repo.db.QueryRowContext(
+		ctx,
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1",
+		keyHash,
+	))
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	return key, nil
+}
+
+func scanApiKey(row scanner) (*models.ApiKey, error) {
+	var key = models.ApiKey{}
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	scanErr := row.Scan(
+		&key.Id,
+		&key.UserId,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&key.TokenVersion,
+		&key.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+
+	if scanErr != nil {
+		return nil, scanErr
+	}
+
+	for _, scope := range strings.Fields(scopes) {
+		key.Scopes = append(key.Scopes, models.Scope(scope))
+	}
+
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+
+	return &key, nil
+}
+
+// joinScopes stores scopes as one column, separated by spaces as in OAuth.
+func joinScopes(scopes []models.Scope) string {
+	var names = make([]string, 0, len(scopes))
+
+	for _, scope := range scopes {
+		names = append(names, string(scope))
+	}
+
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+)
+
+func (repo *sqlRepository) GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	var throttle = models.LoginThrottle{}
+
+	getError := repo.db.QueryRowContext(
+		ctx,
+		"SELECT throttle_key, failures, last_failure_at, blocked_until FROM login_throttles WHERE throttle_key = $1",
+		key,
+	).Scan(&throttle.Key, &throttle.Failures, &throttle.LastFailureAt, &throttle.BlockedUntil)
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	return &throttle, nil
+}
+
+// RecordLoginFailure makes sure the row exists before locking it, so that
+// concurrent failures against a new key are all counted.
+func (repo *sqlRepository) RecordLoginFailure(
+	ctx context.Context,
+	key string,
+	at time.Time,
+	policy models.LoginPolicy,
+	lockout *models.AuditEvent,
+) (*models.LoginThrottle, error) {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	defer tx.Rollback()
+
+	_, insertError := tx.ExecContext(
+		ctx,
+		"INSERT INTO login_throttles (throttle_key, failures, last_failure_at, blocked_until) VALUES ($1, 0, $2, $2) ON CONFLICT (throttle_key) DO NOTHING",
+		key,
+		at.UTC(),
+	)
+
+	if insertError != nil {
+		return nil, insertError
+	}
+
+	var throttle = models.LoginThrottle{}
+
+	getError := tx.QueryRowContext(
+		ctx,
+		"SELECT throttle_key, failures, last_failure_at, blocked_until FROM login_throttles WHERE throttle_key = $1"+repo.dialect.forUpdate,
+		key,
+	).Scan(&throttle.Key, &throttle.Failures, &throttle.LastFailureAt, &throttle.BlockedUntil)
+
+	if getError != nil {
+		return nil, getError
+	}
+
+	throttle.Fail(at.UTC(), policy)
+
+	_, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE login_throttles SET failures = $1, last_failure_at = $2, blocked_until = $3 WHERE throttle_key = $4",
+		throttle.Failures,
+		throttle.LastFailureAt,
+		throttle.BlockedUntil,
+		key,
+	)
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+
+	if throttle.LockedOut(policy) {
+		if auditErr := insertAuditEvent(ctx, tx, lockout); auditErr != nil {
+			return nil, auditErr
+		}
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+
+	return &throttle, nil
+}
+
+func (repo *sqlRepository) ClearLoginThrottle(ctx context.Context, key string, event *models.AuditEvent) error {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	if _, deleteErr := tx.ExecContext(ctx, "DELETE FROM login_throttles WHERE throttle_key = $1", key); deleteErr != nil {
+		return deleteErr
+	}
+
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (repo *sqlRepository) CreateAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	return insertAuditEvent(ctx, repo.db, event)
+}
+
+// insertAuditEvent records event, if any, through db, which may be a
+// transaction the audited change is made in.
+func insertAuditEvent(ctx context.Context, db execer, event *models.AuditEvent) error {
+	if event == nil {
+		return nil
+	}
+
+	_, insertError := db.ExecContext(
+		ctx,
+		"INSERT INTO audit_events (id, action, actor_id, subject, ip_address, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		event.Id,
+		event.Action,
+		event.ActorId,
+		event.Subject,
+		event.IpAddress,
+		event.CreatedAt.UTC(),
+	)
+
+	return insertError
+}
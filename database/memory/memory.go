@@ -0,0 +1,1411 @@
+// Package memory implements repositories.Repository in process memory. It
+// follows the semantics of the SQL backends, so handlers can be exercised
+// without a database, and it is safe for concurrent use.
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+type Repository struct {
+	mutex         sync.RWMutex
+	users         map[string]models.User
+	bankAccounts  map[string]models.BankAccount
+	balances      map[string]int64
+	transactions  map[string]models.Transaction
+	entries       map[string][]models.LedgerEntry
+	transitions   map[string][]models.BankAccountTransition
+	refreshTokens map[string]models.RefreshToken
+	revokedTokens map[string]models.RevokedAccessToken
+	sessions      map[string]models.Session
+	totp          map[string]models.TotpCredential
+	recoveryCodes map[string]models.RecoveryCode
+	mfa           map[string]models.MfaChallenge
+	userTokens    map[string]models.UserToken
+	throttles     map[string]models.LoginThrottle
+	auditEvents   []models.AuditEvent
+	signingKeys   map[string]models.SigningKey
+	apiKeys       map[string]models.ApiKey
+}
+
+func NewRepository() *Repository {
+	return &Repository{
+		users:         map[string]models.User{},
+		bankAccounts:  map[string]models.BankAccount{},
+		balances:      map[string]int64{},
+		transactions:  map[string]models.Transaction{},
+		entries:       map[string][]models.LedgerEntry{},
+		transitions:   map[string][]models.BankAccountTransition{},
+		refreshTokens: map[string]models.RefreshToken{},
+		revokedTokens: map[string]models.RevokedAccessToken{},
+		sessions:      map[string]models.Session{},
+		totp:          map[string]models.TotpCredential{},
+		recoveryCodes: map[string]models.RecoveryCode{},
+		mfa:           map[string]models.MfaChallenge{},
+		userTokens:    map[string]models.UserToken{},
+		throttles:     map[string]models.LoginThrottle{},
+		signingKeys:   map[string]models.SigningKey{},
+		apiKeys:       map[string]models.ApiKey{},
+	}
+}
+
+func (repo *Repository) CreateUser(ctx context.Context, user *models.User) (*models.User, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, existingUser := range repo.users {
+		if existingUser.Email == user.Email {
+			return nil, repositories.ErrDuplicateEmail
+		}
+	}
+
+	repo.users[user.Id] = *user
+
+	return &models.User{Id: user.Id}, nil
+}
+
+func (repo *Repository) ReadUser(ctx context.Context, id string) (*models.User, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	user, ok := repo.users[id]
+
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+
+	return &models.User{
+		Id:              user.Id,
+		Email:           user.Email,
+		FullName:        user.FullName,
+		Role:            user.Role,
+		TokenVersion:    user.TokenVersion,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+	}, nil
+}
+
+func (repo *Repository) ReadUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	for _, user := range repo.users {
+		if user.Email == email {
+			return &models.User{
+				Id:              user.Id,
+				Email:           user.Email,
+				Password:        user.Password,
+				Role:            user.Role,
+				TokenVersion:    user.TokenVersion,
+				EmailVerifiedAt: user.EmailVerifiedAt,
+			}, nil
+		}
+	}
+
+	return nil, repositories.ErrNotFound
+}
+
+func (repo *Repository) VerifyEmail(ctx context.Context, userId string, at time.Time) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	user, ok := repo.users[userId]
+
+	if !ok {
+		return repositories.ErrNotFound
+	}
+
+	if user.EmailVerifiedAt == nil {
+		verifiedAt := at
+		user.EmailVerifiedAt = &verifiedAt
+		repo.users[userId] = user
+	}
+
+	return nil
+}
+
+func (repo *Repository) ResetPassword(ctx context.Context, userId string, password string, at time.Time) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	user, ok := repo.users[userId]
+
+	if !ok {
+		return repositories.ErrNotFound
+	}
+
+	user.Password = password
+	repo.users[userId] = user
+	repo.revokeAllTokens(userId, at)
+
+	for id, key := range repo.apiKeys {
+		if key.UserId == userId && key.RevokedAt == nil {
+			revokedAt := at
+			key.RevokedAt = &revokedAt
+			repo.apiKeys[id] = key
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) RehashPassword(ctx context.Context, userId string, current string, password string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	user, ok := repo.users[userId]
+
+	if !ok || user.Password != current {
+		return repositories.ErrConflict
+	}
+
+	user.Password = password
+	repo.users[userId] = user
+
+	return nil
+}
+
+func (repo *Repository) SetUserRole(ctx context.Context, userId string, role models.Role, event *models.AuditEvent) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	user, ok := repo.users[userId]
+
+	if !ok {
+		return repositories.ErrNotFound
+	}
+
+	user.Role = role
+	user.TokenVersion++
+	repo.users[userId] = user
+	repo.appendAuditEvent(event)
+
+	return nil
+}
+
+func (repo *Repository) ListUsers(ctx context.Context, page models.PageRequest, event *models.AuditEvent) ([]*models.User, models.Page, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.appendAuditEvent(event)
+
+	var users []*models.User
+
+	for _, user := range repo.users {
+		if page.Backward() && user.Id >= page.Cursor.Id || !page.Backward() && page.Cursor != nil && user.Id <= page.Cursor.Id {
+			continue
+		}
+
+		users = append(users, &models.User{
+			Id:              user.Id,
+			Email:           user.Email,
+			FullName:        user.FullName,
+			Role:            user.Role,
+			TokenVersion:    user.TokenVersion,
+			EmailVerifiedAt: user.EmailVerifiedAt,
+		})
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if page.Backward() {
+			return users[i].Id > users[j].Id
+		}
+
+		return users[i].Id < users[j].Id
+	})
+
+	if len(users) > page.Limit+1 {
+		users = users[:page.Limit+1]
+	}
+
+	users, currentPage := models.Paginate(users, page, func(u *models.User) models.Cursor {
+		return models.Cursor{Id: u.Id}
+	})
+
+	return users, currentPage, nil
+}
+
+// CreateUserToken stores the token under its hash, which is what using it
+// looks it up by.
+func (repo *Repository) CreateUserToken(ctx context.Context, token *models.UserToken) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.userTokens[token.TokenHash] = *token
+
+	return nil
+}
+
+func (repo *Repository) UseUserToken(
+	ctx context.Context,
+	purpose models.UserTokenPurpose,
+	tokenHash string,
+	at time.Time,
+) (*models.UserToken, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	token, ok := repo.userTokens[tokenHash]
+
+	if !ok || token.Purpose != purpose {
+		return nil, repositories.ErrNotFound
+	}
+
+	if err := token.CheckUsable(at); err != nil {
+		return nil, err
+	}
+
+	for hash, other := range repo.userTokens {
+		if other.UserId == token.UserId && other.Purpose == purpose && other.UsedAt == nil {
+			usedAt := at
+			other.UsedAt = &usedAt
+			repo.userTokens[hash] = other
+		}
+	}
+
+	usedAt := at
+	token.UsedAt = &usedAt
+
+	return &token, nil
+}
+
+func (repo *Repository) CreateBankAccount(
+	ctx context.Context,
+	bankAccount *models.BankAccount,
+	opening *models.BankAccountTransition,
+) (*models.BankAccount, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if repo.hasBankAccountName(bankAccount.UserId, bankAccount.Name, "") {
+		return nil, repositories.ErrDuplicateBankAccountName
+	}
+
+	var saved = *bankAccount
+	saved.Balance = models.Money{}
+
+	repo.bankAccounts[saved.Id] = saved
+
+	if opening != nil {
+		if err := repo.checkTransition(saved.UserId, opening, false); err != nil {
+			delete(repo.bankAccounts, saved.Id)
+
+			return nil, err
+		}
+
+		repo.applyTransition(opening)
+	}
+
+	return &models.BankAccount{Id: saved.Id}, nil
+}
+
+func (repo *Repository) GetBankAccountById(ctx context.Context, id string, userId string) (*models.BankAccount, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	return repo.bankAccount(id, userId)
+}
+
+func (repo *Repository) ReadBankAccount(ctx context.Context, id string, event *models.AuditEvent) (*models.BankAccount, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	bankAccount, ok := repo.bankAccounts[id]
+
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+
+	repo.appendAuditEvent(event)
+
+	return repo.bankAccount(id, bankAccount.UserId)
+}
+
+func (repo *Repository) UpdateBankAccountById(
+	ctx context.Context,
+	id string,
+	userId string,
+	bankAccount *models.BankAccount,
+	transition *models.BankAccountTransition,
+) (*models.BankAccount, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if bankAccount.Name != "" && repo.hasBankAccountName(userId, bankAccount.Name, id) {
+		return nil, repositories.ErrDuplicateBankAccountName
+	}
+
+	existing, ok := repo.bankAccounts[id]
+
+	if !ok || existing.UserId != userId {
+		return nil, repositories.ErrNotFound
+	}
+
+	if transition != nil {
+		if err := repo.checkTransition(userId, transition, false); err != nil {
+			return nil, err
+		}
+
+		repo.applyTransition(transition)
+	}
+
+	if bankAccount.Name != "" {
+		existing = repo.bankAccounts[id]
+		existing.Name = bankAccount.Name
+		repo.bankAccounts[id] = existing
+	}
+
+	return repo.bankAccount(id, userId)
+}
+
+func (repo *Repository) TransitionBankAccount(
+	ctx context.Context,
+	userId string,
+	transition *models.BankAccountTransition,
+	event *models.AuditEvent,
+) (*models.BankAccount, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if err := repo.checkTransition(userId, transition, false); err != nil {
+		return nil, err
+	}
+
+	repo.applyTransition(transition)
+	repo.appendAuditEvent(event)
+
+	return repo.bankAccount(transition.BankAccountId, userId)
+}
+
+func (repo *Repository) GetBankAccountTransitions(
+	ctx context.Context,
+	id string,
+	userId string,
+) ([]*models.BankAccountTransition, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	if bankAccount, ok := repo.bankAccounts[id]; !ok || bankAccount.UserId != userId {
+		return nil, nil
+	}
+
+	var transitions []*models.BankAccountTransition
+
+	for _, transition := range repo.transitions[id] {
+		transition := transition
+		transitions = append(transitions, &transition)
+	}
+
+	return transitions, nil
+}
+
+func (repo *Repository) CloseBankAccount(
+	ctx context.Context,
+	userId string,
+	closure *models.BankAccountClosure,
+) (*models.BankAccount, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	bankAccountId := closure.Transition.BankAccountId
+
+	if bankAccount, ok := repo.bankAccounts[bankAccountId]; !ok || bankAccount.UserId != userId {
+		return nil, repositories.ErrNotFound
+	}
+
+	if closure.Transition.ActorId == userId && repo.frozenByStaff(bankAccountId, userId) {
+		return nil, models.ErrFrozenByStaff
+	}
+
+	balance := repo.balances[bankAccountId]
+
+	if balance != 0 {
+		if closure.Sweep == nil || balance < 0 {
+			return nil, models.ErrBankAccountNotEmpty
+		}
+
+		for _, entry := range closure.Sweep.Entries {
+			entry.Amount = models.Money{Amount: balance, Currency: repo.bankAccounts[bankAccountId].Currency}
+		}
+
+		if err := repo.checkTransaction(closure.Sweep, userId); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := repo.checkTransition(userId, closure.Transition, balance != 0); err != nil {
+		return nil, err
+	}
+
+	if balance != 0 {
+		repo.applyTransaction(closure.Sweep)
+	}
+
+	repo.applyTransition(closure.Transition)
+
+	return repo.bankAccount(bankAccountId, userId)
+}
+
+func (repo *Repository) DeleteBankAccountById(ctx context.Context, id string, userId string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	bankAccount, ok := repo.bankAccounts[id]
+
+	if !ok || bankAccount.UserId != userId {
+		return repositories.ErrNotFound
+	}
+
+	if len(repo.entries[id]) > 0 {
+		return models.ErrBankAccountHasHistory
+	}
+
+	delete(repo.bankAccounts, id)
+	delete(repo.balances, id)
+	delete(repo.transitions, id)
+
+	return nil
+}
+
+func (repo *Repository) GetAllBankAccountsByUserId(
+	ctx context.Context,
+	userId string,
+	filter *models.BankAccountFilter,
+	page models.PageRequest,
+) ([]*models.BankAccount, models.Page, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var cursorBalance int64
+
+	if page.Cursor != nil && filter.Sort == models.SortBankAccountsByBalance {
+		balance, parseErr := strconv.ParseInt(page.Cursor.Key, 10, 64)
+
+		if parseErr != nil {
+			return nil, models.Page{}, models.ErrInvalidCursor
+		}
+
+		cursorBalance = balance
+	}
+
+	// compare orders two accounts, or an account and the cursor, by the sort
+	// key and then by id.
+	compare := func(b *models.BankAccount, key string, balance int64, id string) int {
+		var byKey int
+
+		switch filter.Sort {
+		case models.SortBankAccountsByName:
+			byKey = strings.Compare(b.Name, key)
+		case models.SortBankAccountsByBalance:
+			byKey = compareInt64(b.Balance.Amount, balance)
+		}
+
+		if byKey != 0 {
+			return byKey
+		}
+
+		return strings.Compare(b.Id, id)
+	}
+
+	descending := filter.Descending != page.Backward()
+
+	var bankAccounts []*models.BankAccount
+
+	for id, bankAccount := range repo.bankAccounts {
+		if bankAccount.UserId != userId || filter.State != "" && bankAccount.State != filter.State {
+			continue
+		}
+
+		found, _ := repo.bankAccount(id, userId)
+
+		if page.Cursor != nil {
+			order := compare(found, page.Cursor.Key, cursorBalance, page.Cursor.Id)
+
+			if descending && order >= 0 || !descending && order <= 0 {
+				continue
+			}
+		}
+
+		bankAccounts = append(bankAccounts, found)
+	}
+
+	sort.Slice(bankAccounts, func(i, j int) bool {
+		order := compare(bankAccounts[i], bankAccounts[j].Name, bankAccounts[j].Balance.Amount, bankAccounts[j].Id)
+
+		if descending {
+			return order > 0
+		}
+
+		return order < 0
+	})
+
+	if len(bankAccounts) > page.Limit+1 {
+		bankAccounts = bankAccounts[:page.Limit+1]
+	}
+
+	bankAccounts, currentPage := models.Paginate(bankAccounts, page, func(b *models.BankAccount) models.Cursor {
+		return models.Cursor{Key: b.SortKey(filter.Sort), Id: b.Id}
+	})
+
+	return bankAccounts, currentPage, nil
+}
+
+func (repo *Repository) CreateTransaction(
+	ctx context.Context,
+	transaction *models.Transaction,
+) (*models.Transaction, error) {
+	return repo.createTransaction(transaction, "")
+}
+
+func (repo *Repository) CreateTransactionForUser(
+	ctx context.Context,
+	userId string,
+	transaction *models.Transaction,
+) (*models.Transaction, error) {
+	return repo.createTransaction(transaction, userId)
+}
+
+func (repo *Repository) GetBankAccountTransactions(
+	ctx context.Context,
+	userId string,
+	filter *models.TransactionFilter,
+	page models.PageRequest,
+) ([]*models.AccountTransaction, models.Page, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	if bankAccount, ok := repo.bankAccounts[filter.BankAccountId]; !ok || bankAccount.UserId != userId {
+		return nil, models.Page{}, nil
+	}
+
+	var transactions []*models.AccountTransaction
+
+	for _, entry := range repo.entries[filter.BankAccountId] {
+		transaction := repo.transactions[entry.TransactionId]
+
+		switch {
+		case !filter.From.IsZero() && transaction.CreatedAt.Before(filter.From),
+			!filter.To.IsZero() && !transaction.CreatedAt.Before(filter.To),
+			filter.MinAmount != nil && entry.Amount.Amount < filter.MinAmount.Amount,
+			filter.MaxAmount != nil && entry.Amount.Amount > filter.MaxAmount.Amount,
+			filter.Direction != "" && entry.Direction != filter.Direction,
+			page.Backward() && entry.Id <= page.Cursor.Id,
+			!page.Backward() && page.Cursor != nil && entry.Id >= page.Cursor.Id:
+			continue
+		}
+
+		transactions = append(transactions, &models.AccountTransaction{
+			EntryId:       entry.Id,
+			TransactionId: entry.TransactionId,
+			BankAccountId: entry.BankAccountId,
+			Kind:          transaction.Kind,
+			Description:   transaction.Description,
+			Direction:     entry.Direction,
+			Amount:        entry.Amount,
+			CreatedAt:     transaction.CreatedAt,
+		})
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		if page.Backward() {
+			return transactions[i].EntryId < transactions[j].EntryId
+		}
+
+		return transactions[i].EntryId > transactions[j].EntryId
+	})
+
+	if len(transactions) > page.Limit+1 {
+		transactions = transactions[:page.Limit+1]
+	}
+
+	transactions, currentPage := models.Paginate(transactions, page, func(t *models.AccountTransaction) models.Cursor {
+		return models.Cursor{Id: t.EntryId}
+	})
+
+	return transactions, currentPage, nil
+}
+
+// CreateRefreshToken stores the token under its hash, which is what a
+// rotation looks it up by.
+func (repo *Repository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.refreshTokens[token.TokenHash] = *token
+
+	return nil
+}
+
+func (repo *Repository) RotateRefreshToken(
+	ctx context.Context,
+	tokenHash string,
+	next *models.RefreshToken,
+) (*models.RefreshToken, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	current, ok := repo.refreshTokens[tokenHash]
+
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+
+	if next.CreatedAt.IsZero() {
+		next.CreatedAt = time.Now().UTC()
+	}
+
+	if err := current.CheckUsable(next.CreatedAt); err != nil {
+		if errors.Is(err, models.ErrRefreshTokenReused) {
+			repo.revokeRefreshTokenFamily(current.FamilyId, next.CreatedAt)
+		}
+
+		return nil, err
+	}
+
+	usedAt := next.CreatedAt
+	current.UsedAt = &usedAt
+	repo.refreshTokens[tokenHash] = current
+
+	next.FamilyId = current.FamilyId
+	next.UserId = current.UserId
+	repo.refreshTokens[next.TokenHash] = *next
+
+	return next, nil
+}
+
+func (repo *Repository) CreateSession(ctx context.Context, session *models.Session) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.sessions[session.Id] = *session
+
+	return nil
+}
+
+func (repo *Repository) GetSessionsByUserId(ctx context.Context, userId string) ([]*models.Session, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var sessions = []*models.Session{}
+
+	for _, session := range repo.sessions {
+		if session.UserId == userId && session.RevokedAt == nil {
+			session := session
+			sessions = append(sessions, &session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+
+		return sessions[i].Id > sessions[j].Id
+	})
+
+	return sessions, nil
+}
+
+func (repo *Repository) TouchSession(ctx context.Context, id string, userId string, at time.Time) (*models.Session, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	session, ok := repo.sessions[id]
+
+	if !ok || session.UserId != userId {
+		return nil, repositories.ErrNotFound
+	}
+
+	if session.RevokedAt == nil && session.LastSeenAt.Before(at.Add(-models.SessionTouchInterval)) {
+		session.LastSeenAt = at
+		repo.sessions[id] = session
+	}
+
+	return &session, nil
+}
+
+func (repo *Repository) RevokeSession(ctx context.Context, id string, userId string, at time.Time) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	session, ok := repo.sessions[id]
+
+	if !ok || session.UserId != userId {
+		return repositories.ErrNotFound
+	}
+
+	if session.RevokedAt != nil {
+		return nil
+	}
+
+	revokedAt := at
+	session.RevokedAt = &revokedAt
+	repo.sessions[id] = session
+
+	repo.revokeRefreshTokenFamily(id, at)
+
+	return nil
+}
+
+// RevokeAccessToken also drops the tokens that have expired since they were
+// revoked, as the signature check rejects those on its own.
+func (repo *Repository) RevokeAccessToken(ctx context.Context, token *models.RevokedAccessToken) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for id, revoked := range repo.revokedTokens {
+		if revoked.ExpiresAt.Before(token.RevokedAt) {
+			delete(repo.revokedTokens, id)
+		}
+	}
+
+	if _, ok := repo.revokedTokens[token.Id]; !ok {
+		repo.revokedTokens[token.Id] = *token
+	}
+
+	return nil
+}
+
+func (repo *Repository) IsAccessTokenRevoked(ctx context.Context, id string) (bool, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	_, ok := repo.revokedTokens[id]
+
+	return ok, nil
+}
+
+func (repo *Repository) RevokeAllTokens(ctx context.Context, userId string, at time.Time) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	_, ok := repo.users[userId]
+
+	if !ok {
+		return repositories.ErrNotFound
+	}
+
+	repo.revokeAllTokens(userId, at)
+
+	return nil
+}
+
+// revokeAllTokens does RevokeAllTokens of a user known to exist. The mutex
+// must be held.
+func (repo *Repository) revokeAllTokens(userId string, at time.Time) {
+	user := repo.users[userId]
+	user.TokenVersion++
+	repo.users[userId] = user
+
+	for hash, token := range repo.refreshTokens {
+		if token.UserId == userId && token.RevokedAt == nil {
+			revokedAt := at
+			token.RevokedAt = &revokedAt
+			repo.refreshTokens[hash] = token
+		}
+	}
+
+	for id, session := range repo.sessions {
+		if session.UserId == userId && session.RevokedAt == nil {
+			revokedAt := at
+			session.RevokedAt = &revokedAt
+			repo.sessions[id] = session
+		}
+	}
+}
+
+func (repo *Repository) SaveTotpCredential(ctx context.Context, credential *models.TotpCredential) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if existing, ok := repo.totp[credential.UserId]; ok && existing.Enabled() {
+		return models.ErrTotpAlreadyEnabled
+	}
+
+	repo.totp[credential.UserId] = models.TotpCredential{
+		UserId:    credential.UserId,
+		Secret:    credential.Secret,
+		CreatedAt: credential.CreatedAt,
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetTotpCredential(ctx context.Context, userId string) (*models.TotpCredential, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	credential, ok := repo.totp[userId]
+
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+
+	return &credential, nil
+}
+
+func (repo *Repository) ConfirmTotpCredential(
+	ctx context.Context,
+	userId string,
+	step int64,
+	at time.Time,
+	codes []*models.RecoveryCode,
+) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	credential, ok := repo.totp[userId]
+
+	if !ok {
+		return repositories.ErrNotFound
+	}
+
+	if credential.Enabled() {
+		return models.ErrTotpAlreadyEnabled
+	}
+
+	if step <= credential.LastUsedStep {
+		return models.ErrTotpCodeReused
+	}
+
+	confirmedAt := at
+	credential.ConfirmedAt = &confirmedAt
+	credential.LastUsedStep = step
+	repo.totp[userId] = credential
+
+	repo.deleteRecoveryCodes(userId)
+
+	for _, code := range codes {
+		repo.recoveryCodes[code.Id] = models.RecoveryCode{
+			Id:       code.Id,
+			UserId:   userId,
+			CodeHash: code.CodeHash,
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) UseTotpCode(ctx context.Context, userId string, step int64) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	credential, ok := repo.totp[userId]
+
+	if !ok || !credential.Enabled() {
+		return repositories.ErrNotFound
+	}
+
+	if step <= credential.LastUsedStep {
+		return models.ErrTotpCodeReused
+	}
+
+	credential.LastUsedStep = step
+	repo.totp[userId] = credential
+
+	return nil
+}
+
+func (repo *Repository) UseRecoveryCode(ctx context.Context, userId string, codeHash string, at time.Time) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for id, code := range repo.recoveryCodes {
+		if code.UserId == userId && code.CodeHash == codeHash && code.UsedAt == nil {
+			usedAt := at
+			code.UsedAt = &usedAt
+			repo.recoveryCodes[id] = code
+
+			return nil
+		}
+	}
+
+	return repositories.ErrNotFound
+}
+
+func (repo *Repository) DeleteTotpCredential(ctx context.Context, userId string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.totp[userId]; !ok {
+		return repositories.ErrNotFound
+	}
+
+	delete(repo.totp, userId)
+	repo.deleteRecoveryCodes(userId)
+
+	return nil
+}
+
+// CreateMfaChallenge stores the challenge under its hash, which is what an
+// attempt looks it up by.
+func (repo *Repository) CreateMfaChallenge(ctx context.Context, challenge *models.MfaChallenge) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.mfa[challenge.TokenHash] = *challenge
+
+	return nil
+}
+
+func (repo *Repository) AttemptMfaChallenge(
+	ctx context.Context,
+	tokenHash string,
+	at time.Time,
+) (*models.MfaChallenge, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	challenge, ok := repo.mfa[tokenHash]
+
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+
+	if err := challenge.CheckUsable(at); err != nil {
+		return nil, err
+	}
+
+	challenge.Attempts++
+	repo.mfa[tokenHash] = challenge
+
+	return &challenge, nil
+}
+
+func (repo *Repository) CompleteMfaChallenge(ctx context.Context, id string, at time.Time) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for hash, challenge := range repo.mfa {
+		if challenge.Id != id {
+			continue
+		}
+
+		if challenge.CompletedAt != nil {
+			return models.ErrMfaChallengeCompleted
+		}
+
+		completedAt := at
+		challenge.CompletedAt = &completedAt
+		repo.mfa[hash] = challenge
+
+		return nil
+	}
+
+	return models.ErrMfaChallengeCompleted
+}
+
+func (repo *Repository) GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	throttle, ok := repo.throttles[key]
+
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+
+	return &throttle, nil
+}
+
+func (repo *Repository) RecordLoginFailure(
+	ctx context.Context,
+	key string,
+	at time.Time,
+	policy models.LoginPolicy,
+	lockout *models.AuditEvent,
+) (*models.LoginThrottle, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	throttle, ok := repo.throttles[key]
+
+	if !ok {
+		throttle = models.LoginThrottle{Key: key}
+	}
+
+	throttle.Fail(at, policy)
+	repo.throttles[key] = throttle
+
+	if throttle.LockedOut(policy) {
+		repo.appendAuditEvent(lockout)
+	}
+
+	return &throttle, nil
+}
+
+func (repo *Repository) ClearLoginThrottle(ctx context.Context, key string, event *models.AuditEvent) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	delete(repo.throttles, key)
+	repo.appendAuditEvent(event)
+
+	return nil
+}
+
+func (repo *Repository) CreateAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.appendAuditEvent(event)
+
+	return nil
+}
+
+// appendAuditEvent records event, if any. The mutex must be held.
+func (repo *Repository) appendAuditEvent(event *models.AuditEvent) {
+	if event != nil {
+		repo.auditEvents = append(repo.auditEvents, *event)
+	}
+}
+
+func (repo *Repository) CreateSigningKey(ctx context.Context, key *models.SigningKey) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.signingKeys[key.Id] = *key
+
+	return nil
+}
+
+func (repo *Repository) GetSigningKeys(ctx context.Context, at time.Time) ([]*models.SigningKey, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var keys = []*models.SigningKey{}
+
+	for _, key := range repo.signingKeys {
+		if key.ExpiresAt.After(at) {
+			key := key
+			keys = append(keys, &key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].ActiveFrom.Equal(keys[j].ActiveFrom) {
+			return keys[i].ActiveFrom.Before(keys[j].ActiveFrom)
+		}
+
+		return keys[i].Id < keys[j].Id
+	})
+
+	return keys, nil
+}
+
+func (repo *Repository) CreateApiKey(ctx context.Context, key *models.ApiKey, event *models.AuditEvent) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	stored := *key
+	stored.Scopes = append([]models.Scope(nil), key.Scopes...)
+	repo.apiKeys[key.Id] = stored
+	repo.appendAuditEvent(event)
+
+	return nil
+}
+
+func (repo *Repository) GetApiKeysByUserId(ctx context.Context, userId string) ([]*models.ApiKey, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	var keys = []*models.ApiKey{}
+
+	for _, key := range repo.apiKeys {
+		if key.UserId == userId && key.RevokedAt == nil {
+			key := key
+			keys = append(keys, &key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+
+		return keys[i].Id > keys[j].Id
+	})
+
+	return keys, nil
+}
+
+func (repo *Repository) GetApiKey(ctx context.Context, id string, userId string) (*models.ApiKey, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	key, ok := repo.apiKeys[id]
+
+	if !ok || key.UserId != userId || key.RevokedAt != nil {
+		return nil, repositories.ErrNotFound
+	}
+
+	return &key, nil
+}
+
+func (repo *Repository) RenameApiKey(ctx context.Context, id string, userId string, name string) (*models.ApiKey, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	key, ok := repo.apiKeys[id]
+
+	if !ok || key.UserId != userId || key.RevokedAt != nil {
+		return nil, repositories.ErrNotFound
+	}
+
+	key.Name = name
+	repo.apiKeys[id] = key
+
+	return &key, nil
+}
+
+func (repo *Repository) RevokeApiKey(ctx context.Context, id string, userId string, at time.Time, event *models.AuditEvent) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	key, ok := repo.apiKeys[id]
+
+	if !ok || key.UserId != userId {
+		return repositories.ErrNotFound
+	}
+
+	if key.RevokedAt == nil {
+		revokedAt := at
+		key.RevokedAt = &revokedAt
+		repo.apiKeys[id] = key
+	}
+
+	repo.appendAuditEvent(event)
+
+	return nil
+}
+
+func (repo *Repository) UseApiKey(ctx context.Context, keyHash string, at time.Time) (*models.ApiKey, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for id, key := range repo.apiKeys {
+		if key.KeyHash != keyHash {
+			continue
+		}
+
+		if key.RevokedAt == nil && (key.LastUsedAt == nil || key.LastUsedAt.Before(at.Add(-models.ApiKeyTouchInterval))) {
+			lastUsedAt := at
+			key.LastUsedAt = &lastUsedAt
+			repo.apiKeys[id] = key
+		}
+
+		return &key, nil
+	}
+
+	return nil, repositories.ErrNotFound
+}
+
+func (repo *Repository) Close() error {
+	return nil
+}
+
+func (repo *Repository) createTransaction(transaction *models.Transaction, userId string) (*models.Transaction, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if err := repo.checkTransaction(transaction, userId); err != nil {
+		return nil, err
+	}
+
+	repo.applyTransaction(transaction)
+
+	return transaction, nil
+}
+
+// checkTransaction applies the same rules as the SQL backends before a
+// posting: the accounts exist, share the currency of the entries, accept
+// money in or out in their state, belong to userId when they are debited and
+// are not overdrawn. The caller must hold the write lock.
+func (repo *Repository) checkTransaction(transaction *models.Transaction, userId string) error {
+	if err := transaction.Validate(); err != nil {
+		return err
+	}
+
+	for _, bankAccountId := range transaction.BankAccountIds() {
+		if bankAccountId == models.ExternalBankAccountId {
+			continue
+		}
+
+		bankAccount, ok := repo.bankAccounts[bankAccountId]
+
+		if !ok {
+			return repositories.ErrNotFound
+		}
+
+		net := transaction.NetAmount(bankAccountId)
+
+		if net.IsNegative() && userId != "" && bankAccount.UserId != userId {
+			return repositories.ErrNotFound
+		}
+
+		if net.Currency != bankAccount.Currency {
+			return models.ErrCurrencyMismatch
+		}
+
+		if !net.IsNegative() {
+			if err := bankAccount.State.CanCredit(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := bankAccount.State.CanDebit(); err != nil {
+			return err
+		}
+
+		remaining, err := models.Money{Amount: repo.balances[bankAccountId], Currency: bankAccount.Currency}.Add(net)
+
+		if err != nil {
+			return err
+		}
+
+		if remaining.IsNegative() {
+			return models.ErrInsufficientFunds
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) applyTransaction(transaction *models.Transaction) {
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now().UTC()
+	}
+
+	var saved = *transaction
+	saved.Entries = nil
+
+	repo.transactions[transaction.Id] = saved
+
+	for _, entry := range transaction.Entries {
+		entry.TransactionId = transaction.Id
+
+		repo.entries[entry.BankAccountId] = append(repo.entries[entry.BankAccountId], *entry)
+		repo.balances[entry.BankAccountId] += entry.SignedAmount().Amount
+	}
+}
+
+// checkTransition validates a transition against the current state of the
+// account. swept tells that the balance is being swept out in the same
+// operation, so a closing account does not have to be empty yet. The caller
+// must hold the write lock.
+func (repo *Repository) checkTransition(userId string, transition *models.BankAccountTransition, swept bool) error {
+	bankAccount, ok := repo.bankAccounts[transition.BankAccountId]
+
+	if !ok || bankAccount.UserId != userId {
+		return repositories.ErrNotFound
+	}
+
+	transition.From = bankAccount.State
+
+	if transition.ActorId == userId && repo.frozenByStaff(transition.BankAccountId, userId) {
+		return models.ErrFrozenByStaff
+	}
+
+	if err := transition.Validate(); err != nil {
+		return err
+	}
+
+	if transition.To == models.BankAccountStateClosed && !swept && repo.balances[transition.BankAccountId] != 0 {
+		return models.ErrBankAccountNotEmpty
+	}
+
+	return nil
+}
+
+// frozenByStaff tells whether the account is frozen by someone other than
+// its owner. The mutex must be held.
+func (repo *Repository) frozenByStaff(bankAccountId string, ownerId string) bool {
+	transitions := repo.transitions[bankAccountId]
+
+	if len(transitions) == 0 {
+		return false
+	}
+
+	last := transitions[len(transitions)-1]
+
+	return last.To == models.BankAccountStateFrozen && last.ActorId != ownerId
+}
+
+func (repo *Repository) applyTransition(transition *models.BankAccountTransition) {
+	if transition.CreatedAt.IsZero() {
+		transition.CreatedAt = time.Now().UTC()
+	}
+
+	bankAccount := repo.bankAccounts[transition.BankAccountId]
+	bankAccount.State = transition.To
+	repo.bankAccounts[transition.BankAccountId] = bankAccount
+
+	repo.transitions[transition.BankAccountId] = append(repo.transitions[transition.BankAccountId], *transition)
+}
+
+// bankAccount returns a copy of one of the user's bank accounts with its
+// balance. The caller must hold the lock.
+func (repo *Repository) bankAccount(id string, userId string) (*models.BankAccount, error) {
+	bankAccount, ok := repo.bankAccounts[id]
+
+	if !ok || bankAccount.UserId != userId {
+		return nil, repositories.ErrNotFound
+	}
+
+	bankAccount.Balance = models.Money{Amount: repo.balances[id], Currency: bankAccount.Currency}
+
+	return &bankAccount, nil
+}
+
+func (repo *Repository) hasBankAccountName(userId string, name string, exceptId string) bool {
+	for _, bankAccount := range repo.bankAccounts {
+		if bankAccount.UserId == userId && bankAccount.Name == name && bankAccount.Id != exceptId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// revokeRefreshTokenFamily revokes every token of a family that is not
+// revoked yet. The caller must hold the write lock.
+func (repo *Repository) revokeRefreshTokenFamily(familyId string, at time.Time) {
+	for hash, token := range repo.refreshTokens {
+		if token.FamilyId == familyId && token.RevokedAt == nil {
+			revokedAt := at
+			token.RevokedAt = &revokedAt
+			repo.refreshTokens[hash] = token
+		}
+	}
+}
+
+// deleteRecoveryCodes drops every recovery code of the user. The caller must
+// hold the write lock.
+func (repo *Repository) deleteRecoveryCodes(userId string) {
+	for id, code := range repo.recoveryCodes {
+		if code.UserId == userId {
+			delete(repo.recoveryCodes, id)
+		}
+	}
+}
+
+func compareInt64(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
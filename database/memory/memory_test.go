@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/repositories/repositorytest"
+)
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repositories.Repository {
+		return NewRepository()
+	})
+}
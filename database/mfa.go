@@ -0,0 +1,372 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+const totpCredentialColumns = "user_id, secret, created_at, confirmed_at, last_used_step"
+
+const mfaChallengeColumns = "id, user_id, token_hash, created_at, expires_at, attempts, completed_at"
+
+func (repo *sqlRepository) SaveTotpCredential(ctx context.Context, credential *models.TotpCredential) error {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	existing, getError := scanTotpCredential(tx.QueryRowContext(
+		ctx,
+		"SELECT "+totpCredentialColumns+" FROM totp_credentials WHERE user_id = $1"+repo.dialect.forUpdate,
+		credential.UserId,
+	))
+
+	switch {
+	case errors.Is(getError, sql.ErrNoRows):
+		_, insertError := tx.ExecContext(
+			ctx,
+			"INSERT INTO totp_credentials (user_id, secret, created_at) VALUES ($1, $2, $3)",
+			credential.UserId,
+			credential.Secret,
+			credential.CreatedAt.UTC(),
+		)
+
+		if insertError != nil {
+			return insertError
+		}
+	case getError != nil:
+		return getError
+	case existing.Enabled():
+		return models.ErrTotpAlreadyEnabled
+	default:
+		_, updateErr := tx.ExecContext(
+			ctx,
+			"UPDATE totp_credentials SET secret = $1, created_at = $2, last_used_step = 0 WHERE user_id = $3",
+			credential.Secret,
+			credential.CreatedAt.UTC(),
+			credential.UserId,
+		)
+
+		if updateErr != nil {
+			return updateErr
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (repo *sqlRepository) GetTotpCredential(ctx context.Context, userId string) (*models.TotpCredential, error) {
+	credential, getError := scanTotpCredential(repo.db.QueryRowContext(
+		ctx,
+		"SELECT "+totpCredentialColumns+" FROM totp_credentials WHERE user_id = $1",
+		userId,
+	))
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	return credential, nil
+}
+
+func (repo *sqlRepository) ConfirmTotpCredential(
+	ctx context.Context,
+	userId string,
+	step int64,
+	at time.Time,
+	codes []*models.RecoveryCode,
+) error {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	credential, getError := scanTotpCredential(tx.QueryRowContext(
+		ctx,
+		"SELECT "+totpCredentialColumns+" FROM totp_credentials WHERE user_id = $1"+repo.dialect.forUpdate,
+		userId,
+	))
+
+	if getError != nil {
+		return notFound(getError)
+	}
+
+	if credential.Enabled() {
+		return models.ErrTotpAlreadyEnabled
+	}
+
+	if step <= credential.LastUsedStep {
+		return models.ErrTotpCodeReused
+	}
+
+	_, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE totp_credentials SET confirmed_at = $1, last_used_step = $2 WHERE user_id = $3",
+		at.UTC(),
+		step,
+		userId,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	if _, deleteErr := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userId); deleteErr != nil {
+		return deleteErr
+	}
+
+	for _, code := range codes {
+		_, insertError := tx.ExecContext(
+			ctx,
+			"INSERT INTO recovery_codes (id, user_id, code_hash) VALUES ($1, $2, $3)",
+			code.Id,
+			userId,
+			code.CodeHash,
+		)
+
+		if insertError != nil {
+			return insertError
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (repo *sqlRepository) UseTotpCode(ctx context.Context, userId string, step int64) error {
+	result, updateErr := repo.db.ExecContext(
+		ctx,
+		"UPDATE totp_credentials SET last_used_step = $1 WHERE user_id = $2 AND confirmed_at IS NOT NULL AND last_used_step < $1",
+		step,
+		userId,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return nil
+	}
+
+	credential, getError := repo.GetTotpCredential(ctx, userId)
+
+	if getError != nil {
+		return getError
+	}
+
+	if !credential.Enabled() {
+		return repositories.ErrNotFound
+	}
+
+	return models.ErrTotpCodeReused
+}
+
+func (repo *sqlRepository) UseRecoveryCode(ctx context.Context, userId string, codeHash string, at time.Time) error {
+	result, updateErr := repo.db.ExecContext(
+		ctx,
+		"UPDATE recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL",
+		at.UTC(),
+		userId,
+		codeHash,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return repositories.ErrNotFound
+	}
+
+	return nil
+}
+
+func (repo *sqlRepository) DeleteTotpCredential(ctx context.Context, userId string) error {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	if _, deleteErr := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userId); deleteErr != nil {
+		return deleteErr
+	}
+
+	result, deleteErr := tx.ExecContext(ctx, "DELETE FROM totp_credentials WHERE user_id = $1", userId)
+
+	if deleteErr != nil {
+		return deleteErr
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return repositories.ErrNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (repo *sqlRepository) CreateMfaChallenge(ctx context.Context, challenge *models.MfaChallenge) error {
+	_, insertError := repo.db.ExecContext(
+		ctx,
+		"INSERT INTO mfa_challenges (id, user_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		challenge.Id,
+		challenge.UserId,
+		challenge.TokenHash,
+		challenge.CreatedAt.UTC(),
+		challenge.ExpiresAt.UTC(),
+	)
+
+	return insertError
+}
+
+// AttemptMfaChallenge counts the attempt under a row lock, so concurrent
+// guesses cannot get past MaxMfaChallengeAttempts.
+func (repo *sqlRepository) AttemptMfaChallenge(
+	ctx context.Context,
+	tokenHash string,
+	at time.Time,
+) (*models.MfaChallenge, error) {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	defer tx.Rollback()
+
+	challenge, getError := scanMfaChallenge(tx.QueryRowContext(
+		ctx,
+		"SELECT "+mfaChallengeColumns+" FROM mfa_challenges WHERE token_hash = $1"+repo.dialect.forUpdate,
+		tokenHash,
+	))
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	if usableErr := challenge.CheckUsable(at); usableErr != nil {
+		return nil, usableErr
+	}
+
+	_, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1",
+		challenge.Id,
+	)
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+
+	challenge.Attempts++
+
+	return challenge, nil
+}
+
+func (repo *sqlRepository) CompleteMfaChallenge(ctx context.Context, id string, at time.Time) error {
+	result, updateErr := repo.db.ExecContext(
+		ctx,
+		"UPDATE mfa_challenges SET completed_at = $1 WHERE id = $2 AND completed_at IS NULL",
+		at.UTC(),
+		id,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return models.ErrMfaChallengeCompleted
+	}
+
+	return nil
+}
+
+func scanTotpCredential(row scanner) (*models.TotpCredential, error) {
+	var credential = models.TotpCredential{}
+	var confirmedAt sql.NullTime
+
+	scanErr := row.Scan(
+		&credential.UserId,
+		&credential.Secret,
+		&credential.CreatedAt,
+		&confirmedAt,
+		&credential.LastUsedStep,
+	)
+
+	if scanErr != nil {
+		return nil, scanErr
+	}
+
+	if confirmedAt.Valid {
+		credential.ConfirmedAt = &confirmedAt.Time
+	}
+
+	return &credential, nil
+}
+
+func scanMfaChallenge(row scanner) (*models.MfaChallenge, error) {
+	var challenge = models.MfaChallenge{}
+	var completedAt sql.NullTime
+
+	scanErr := row.Scan(
+		&challenge.Id,
+		&challenge.UserId,
+		&challenge.TokenHash,
+		&challenge.CreatedAt,
+		&challenge.ExpiresAt,
+		&challenge.Attempts,
+		&completedAt,
+	)
+
+	if scanErr != nil {
+		return nil, scanErr
+	}
+
+	if completedAt.Valid {
+		challenge.CompletedAt = &completedAt.Time
+	}
+
+	return &challenge, nil
+}
@@ -0,0 +1,252 @@
+// Package migrations holds the versioned SQL that brings a database up to
+// the schema the repositories expect. Every version ships as a pair of
+// files, NNNN_name.up.sql and NNNN_name.down.sql, embedded in the binary;
+// applied versions are tracked in the schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+const createSchemaMigrationsSql = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    BIGINT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+)`
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status tells whether a migration has been applied and when.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Load reads every embedded migration, ordered by version.
+func Load() ([]*Migration, error) {
+	names, err := fs.Glob(files, "sql/*.sql")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var byVersion = map[int64]*Migration{}
+
+	for _, name := range names {
+		base := path.Base(name)
+
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: name must end in .up.sql or .down.sql", base)
+		}
+
+		rawVersion, migrationName, ok := strings.Cut(stem, "_")
+
+		if !ok {
+			return nil, fmt.Errorf("migration %s: name must start with a version", base)
+		}
+
+		version, parseErr := strconv.ParseInt(rawVersion, 10, 64)
+
+		if parseErr != nil {
+			return nil, fmt.Errorf("migration %s: %w", base, parseErr)
+		}
+
+		contents, readErr := files.ReadFile(name)
+
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		migration, exists := byVersion[version]
+
+		if !exists {
+			migration = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != migrationName {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, migrationName)
+		}
+
+		if direction == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	var migrations []*Migration
+
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies every pending migration, each in its own transaction, and
+// returns the ones it applied.
+func Up(ctx context.Context, db *sql.DB) ([]*Migration, error) {
+	statuses, err := GetStatus(ctx, db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []*Migration
+
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			continue
+		}
+
+		migration := status.Migration
+
+		if err = run(ctx, db, migration.Up, func(tx *sql.Tx) error {
+			_, insertErr := tx.ExecContext(
+				ctx,
+				"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+				migration.Version,
+				migration.Name,
+				time.Now().UTC(),
+			)
+
+			return insertErr
+		}); err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		applied = append(applied, &migration)
+	}
+
+	return applied, nil
+}
+
+// Down rolls back the latest applied migration. It returns nil when there is
+// nothing left to roll back.
+func Down(ctx context.Context, db *sql.DB) (*Migration, error) {
+	statuses, err := GetStatus(ctx, db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if statuses[i].AppliedAt == nil {
+			continue
+		}
+
+		migration := statuses[i].Migration
+
+		if err = run(ctx, db, migration.Down, func(tx *sql.Tx) error {
+			_, deleteErr := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+
+			return deleteErr
+		}); err != nil {
+			return nil, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		return &migration, nil
+	}
+
+	return nil, nil
+}
+
+// GetStatus lists every known migration along with when it was applied.
+func GetStatus(ctx context.Context, db *sql.DB) ([]*Status, error) {
+	migrations, err := Load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = db.ExecContext(ctx, createSchemaMigrationsSql); err != nil {
+		return nil, err
+	}
+
+	result, err := db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer result.Close()
+
+	var appliedAt = map[int64]time.Time{}
+
+	for result.Next() {
+		var version int64
+		var at time.Time
+
+		if err = result.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+
+		appliedAt[version] = at
+	}
+
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	var statuses []*Status
+
+	for _, migration := range migrations {
+		var status = Status{Migration: *migration}
+
+		if at, ok := appliedAt[migration.Version]; ok {
+			status.AppliedAt = &at
+		}
+
+		statuses = append(statuses, &status)
+	}
+
+	return statuses, nil
+}
+
+func run(ctx context.Context, db *sql.DB, statements string, record func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, statements); err != nil {
+		return err
+	}
+
+	if err = record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,6 @@
+CREATE TABLE users (
+    id        TEXT PRIMARY KEY,
+    email     TEXT NOT NULL UNIQUE,
+    full_name TEXT NOT NULL DEFAULT '',
+    password  TEXT NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE bank_account_transitions;
+DROP TABLE bank_accounts;
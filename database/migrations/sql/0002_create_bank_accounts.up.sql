@@ -0,0 +1,20 @@
+CREATE TABLE bank_accounts (
+    id       TEXT PRIMARY KEY,
+    user_id  TEXT NOT NULL REFERENCES users (id),
+    name     TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    state    TEXT NOT NULL,
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE bank_account_transitions (
+    id              TEXT PRIMARY KEY,
+    bank_account_id TEXT NOT NULL REFERENCES bank_accounts (id) ON DELETE CASCADE,
+    from_state      TEXT NOT NULL,
+    to_state        TEXT NOT NULL,
+    reason          TEXT NOT NULL,
+    actor_id        TEXT NOT NULL,
+    created_at      TIMESTAMP NOT NULL
+);
+
+CREATE INDEX bank_account_transitions_bank_account_id_idx ON bank_account_transitions (bank_account_id, id);
@@ -0,0 +1,20 @@
+CREATE TABLE transactions (
+    id          TEXT PRIMARY KEY,
+    kind        TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMP NOT NULL
+);
+
+-- bank_account_id has no foreign key: the external settlement account is
+-- not a bank_accounts row.
+CREATE TABLE ledger_entries (
+    id              TEXT PRIMARY KEY,
+    transaction_id  TEXT NOT NULL REFERENCES transactions (id),
+    bank_account_id TEXT NOT NULL,
+    direction       TEXT NOT NULL CHECK (direction IN ('debit', 'credit')),
+    amount          BIGINT NOT NULL CHECK (amount > 0),
+    currency        TEXT NOT NULL
+);
+
+CREATE INDEX ledger_entries_bank_account_id_idx ON ledger_entries (bank_account_id, id);
+CREATE INDEX ledger_entries_transaction_id_idx ON ledger_entries (transaction_id);
@@ -0,0 +1,3 @@
+DROP TABLE revoked_access_tokens;
+
+ALTER TABLE users DROP COLUMN token_version;
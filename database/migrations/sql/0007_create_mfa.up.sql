@@ -0,0 +1,25 @@
+CREATE TABLE totp_credentials (
+    user_id        TEXT PRIMARY KEY REFERENCES users (id),
+    secret         TEXT NOT NULL,
+    created_at     TIMESTAMP NOT NULL,
+    confirmed_at   TIMESTAMP,
+    last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE recovery_codes (
+    id        TEXT PRIMARY KEY,
+    user_id   TEXT NOT NULL REFERENCES users (id),
+    code_hash TEXT NOT NULL,
+    used_at   TIMESTAMP,
+    UNIQUE (user_id, code_hash)
+);
+
+CREATE TABLE mfa_challenges (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL REFERENCES users (id),
+    token_hash   TEXT NOT NULL UNIQUE,
+    created_at   TIMESTAMP NOT NULL,
+    expires_at   TIMESTAMP NOT NULL,
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    completed_at TIMESTAMP
+);
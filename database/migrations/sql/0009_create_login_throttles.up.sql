@@ -0,0 +1,17 @@
+CREATE TABLE login_throttles (
+    throttle_key    TEXT PRIMARY KEY,
+    failures        INTEGER NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    blocked_until   TIMESTAMP NOT NULL
+);
+
+CREATE TABLE audit_events (
+    id         TEXT PRIMARY KEY,
+    action     TEXT NOT NULL,
+    actor_id   TEXT NOT NULL DEFAULT '',
+    subject    TEXT NOT NULL,
+    ip_address TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_events_subject_idx ON audit_events (subject, created_at);
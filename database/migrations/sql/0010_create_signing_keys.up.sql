@@ -0,0 +1,11 @@
+CREATE TABLE signing_keys (
+    id           TEXT PRIMARY KEY,
+    algorithm    TEXT NOT NULL,
+    private_key  TEXT NOT NULL,
+    created_at   TIMESTAMP NOT NULL,
+    active_from  TIMESTAMP NOT NULL,
+    active_until TIMESTAMP NOT NULL,
+    expires_at   TIMESTAMP NOT NULL
+);
+
+CREATE INDEX signing_keys_expires_at_idx ON signing_keys (expires_at);
@@ -0,0 +1,18 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+)
+
+const SqliteScheme = "sqlite://"
+
+// Open connects to the database at url: sqlite:// followed by a file path or
+// ":memory:", or else a Postgres connection string.
+func Open(url string) (*sql.DB, error) {
+	if strings.HasPrefix(url, SqliteScheme) {
+		return openSqlite(strings.TrimPrefix(url, SqliteScheme))
+	}
+
+	return sql.Open("postgres", url)
+}
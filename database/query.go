@@ -0,0 +1,30 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// query assembles a statement whose WHERE clause depends on optional
+// filters, numbering placeholders as arguments are added.
+type query struct {
+	sql  strings.Builder
+	args []interface{}
+}
+
+func (q *query) Write(sql string) *query {
+	q.sql.WriteString(sql)
+
+	return q
+}
+
+// Arg adds an argument and returns its placeholder.
+func (q *query) Arg(value interface{}) string {
+	q.args = append(q.args, value)
+
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+func (q *query) String() string {
+	return q.sql.String()
+}
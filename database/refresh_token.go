@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+)
+
+const refreshTokenColumns = "id, family_id, user_id, token_hash, created_at, expires_at, used_at, revoked_at"
+
+func (repo *sqlRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	_, insertError := repo.db.ExecContext(
+		ctx,
+		"INSERT INTO refresh_tokens (id, family_id, user_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		token.Id,
+		token.FamilyId,
+		token.UserId,
+		token.TokenHash,
+		token.CreatedAt.UTC(),
+		token.ExpiresAt.UTC(),
+	)
+
+	return insertError
+}
+
+// RotateRefreshToken reads the presented token under a row lock, so two
+// requests racing with the same token cannot both rotate it.
+func (repo *sqlRepository) RotateRefreshToken(
+	ctx context.Context,
+	tokenHash string,
+	next *models.RefreshToken,
+) (*models.RefreshToken, error) {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	defer tx.Rollback()
+
+	current, getError := scanRefreshToken(tx.QueryRowContext(
+		ctx,
+		"SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE token_hash = $1"+repo.dialect.forUpdate,
+		tokenHash,
+	))
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	if next.CreatedAt.IsZero() {
+		next.CreatedAt = time.Now().UTC()
+	}
+
+	if usableErr := current.CheckUsable(next.CreatedAt); usableErr != nil {
+		if !errors.Is(usableErr, models.ErrRefreshTokenReused) {
+			return nil, usableErr
+		}
+
+		if revokeErr := revokeRefreshTokenFamily(ctx, tx, current.FamilyId, next.CreatedAt); revokeErr != nil {
+			return nil, revokeErr
+		}
+
+		if commitErr := tx.Commit(); commitErr != nil {
+			return nil, commitErr
+		}
+
+		return nil, usableErr
+	}
+
+	_, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET used_at = $1 WHERE id = $2",
+		next.CreatedAt.UTC(),
+		current.Id,
+	)
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+
+	next.FamilyId = current.FamilyId
+	next.UserId = current.UserId
+
+	_, insertError := tx.ExecContext(
+		ctx,
+		"INSERT INTO refresh_tokens (id, family_id, user_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		next.Id,
+		next.FamilyId,
+		next.UserId,
+		next.TokenHash,
+		next.CreatedAt.UTC(),
+		next.ExpiresAt.UTC(),
+	)
+
+	if insertError != nil {
+		return nil, insertError
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+
+	return next, nil
+}
+
+func revokeRefreshTokenFamily(ctx context.Context, tx *sql.Tx, familyId string, at time.Time) error {
+	_, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL",
+		at.UTC(),
+		familyId,
+	)
+
+	return updateErr
+}
+
+func scanRefreshToken(row *sql.Row) (*models.RefreshToken, error) {
+	var token = models.RefreshToken{}
+	var usedAt, revokedAt sql.NullTime
+
+	scanErr := row.Scan(
+		&token.Id,
+		&token.FamilyId,
+		&token.UserId,
+		&token.TokenHash,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&usedAt,
+		&revokedAt,
+	)
+
+	if scanErr != nil {
+		return nil, scanErr
+	}
+
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+)
+
+const sessionColumns = "id, user_id, user_agent, ip_address, created_at, last_seen_at, revoked_at"
+
+func (repo *sqlRepository) CreateSession(ctx context.Context, session *models.Session) error {
+	_, insertError := repo.db.ExecContext(
+		ctx,
+		"INSERT INTO sessions (id, user_id, user_agent, ip_address, created_at, last_seen_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		session.Id,
+		session.UserId,
+		session.UserAgent,
+		session.IpAddress,
+		session.CreatedAt.UTC(),
+		session.LastSeenAt.UTC(),
+	)
+
+	return insertError
+}
+
+func (repo *sqlRepository) GetSessionsByUserId(ctx context.Context, userId string) ([]*models.Session, error) {
+	rows, queryErr := repo.db.QueryContext(
+		ctx,
+		"SELECT "+sessionColumns+" FROM sessions WHERE user_id = $1 AND revoked_at IS NULL ORDER BY last_seen_at DESC, id DESC",
+		userId,
+	)
+
+	if queryErr != nil {
+		return nil, queryErr
+	}
+
+	defer rows.Close()
+
+	var sessions = []*models.Session{}
+
+	for rows.Next() {
+		session, scanErr := scanSession(rows)
+
+		if scanErr != nil {
+			return nil, scanErr
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return nil, rowsErr
+	}
+
+	return sessions, nil
+}
+
+func (repo *sqlRepository) TouchSession(ctx context.Context, id string, userId string, at time.Time) (*models.Session, error) {
+	_, updateErr := repo.db.ExecContext(
+		ctx,
+		"UPDATE sessions SET last_seen_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL AND last_seen_at < $4",
+		at.UTC(),
+		id,
+		userId,
+		at.Add(-models.SessionTouchInterval).UTC(),
+	)
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+
+	session, getError := scanSession(repo.db.QueryRowContext(
+		ctx,
+		"SELECT "+sessionColumns+" FROM sessions WHERE id = $1 AND user_id = $2",
+		id,
+		userId,
+	))
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	return session, nil
+}
+
+func (repo *sqlRepository) RevokeSession(ctx context.Context, id string, userId string, at time.Time) error {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	var revokedAt sql.NullTime
+
+	getError := tx.QueryRowContext(
+		ctx,
+		"SELECT revoked_at FROM sessions WHERE id = $1 AND user_id = $2"+repo.dialect.forUpdate,
+		id,
+		userId,
+	).Scan(&revokedAt)
+
+	if getError != nil {
+		return notFound(getError)
+	}
+
+	if revokedAt.Valid {
+		return nil
+	}
+
+	_, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE sessions SET revoked_at = $1 WHERE id = $2",
+		at.UTC(),
+		id,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	if revokeErr := revokeRefreshTokenFamily(ctx, tx, id, at); revokeErr != nil {
+		return revokeErr
+	}
+
+	return tx.Commit()
+}
+
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// execer is either the database or a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func scanSession(row scanner) (*models.Session, error) {
+	var session = models.Session{}
+	var revokedAt sql.NullTime
+
+	scanErr := row.Scan(
+		&session.Id,
+		&session.UserId,
+		&session.UserAgent,
+		&session.IpAddress,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&revokedAt,
+	)
+
+	if scanErr != nil {
+		return nil, scanErr
+	}
+
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+
+	return &session, nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+)
+
+func (repo *sqlRepository) CreateSigningKey(ctx context.Context, key *models.SigningKey) error {
+	_, insertError := repo.db.ExecContext(
+		ctx,
+		"INSERT INTO signing_keys (id, algorithm, private_key, created_at, active_from, active_until, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		key.Id,
+		key.Algorithm,
+		key.PrivateKey,
+		key.CreatedAt.UTC(),
+		key.ActiveFrom.UTC(),
+		key.ActiveUntil.UTC(),
+		key.ExpiresAt.UTC(),
+	)
+
+	return insertError
+}
+
+func (repo *sqlRepository) GetSigningKeys(ctx context.Context, at time.Time) ([]*models.SigningKey, error) {
+	rows, queryErr := repo.db.QueryContext(
+		ctx,
+		"SELECT id, algorithm, private_key, created_at, active_from, active_until, expires_at FROM signing_keys WHERE expires_at > $1 ORDER BY active_from, id"+repo.dialect.byteOrder,
+		at.UTC(),
+	)
+
+	if queryErr != nil {
+		return nil, queryErr
+	}
+
+	defer rows.Close()
+
+	var keys = []*models.SigningKey{}
+
+	for rows.Next() {
+		var key = models.SigningKey{}
+
+		scanErr := rows.Scan(
+			&key.Id,
+			&key.Algorithm,
+			&key.PrivateKey,
+			&key.CreatedAt,
+			&key.ActiveFrom,
+			&key.ActiveUntil,
+			&key.ExpiresAt,
+		)
+
+		if scanErr != nil {
+			return nil, scanErr
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return nil, rowsErr
+	}
+
+	return keys, nil
+}
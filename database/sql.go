@@ -0,0 +1,991 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+// bankAccountBalanceSql derives the balance of a bank_accounts row from its
+// ledger entries, in minor units.
+const bankAccountBalanceSql = `COALESCE((
+	SELECT SUM(CASE WHEN ledger_entries.direction = 'credit' THEN ledger_entries.amount ELSE -ledger_entries.amount END)
+	FROM ledger_entries
+	WHERE ledger_entries.bank_account_id = bank_accounts.id
+), 0)`
+
+// dialect holds the bits of SQL that differ between the databases the
+// repository runs on.
+type dialect struct {
+	// forUpdate locks the rows a SELECT reads until the transaction ends.
+	forUpdate string
+	// byteOrder makes text comparisons follow byte order whatever the
+	// database collation is. Without it ksuids stop sorting by time, and the
+	// order would differ from the other backends.
+	byteOrder string
+}
+
+// sqlRepository implements repositories.Repository on top of database/sql.
+// PsqlRepository and SqliteRepository share it and only differ in dialect.
+type sqlRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// notFound reports a missing row as repositories.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.ErrNotFound
+	}
+
+	return err
+}
+
+func (repo *sqlRepository) CreateUser(ctx context.Context, user *models.User) (*models.User, error) {
+	var existingId string
+
+	existingError := repo.db.QueryRowContext(
+		ctx,
+		"SELECT id FROM users WHERE email = $1",
+		user.Email,
+	).Scan(&existingId)
+
+	if existingError == nil {
+		return nil, repositories.ErrDuplicateEmail
+	}
+
+	if !errors.Is(existingError, sql.ErrNoRows) {
+		return nil, existingError
+	}
+
+	_, insertError := repo.db.ExecContext(
+		ctx,
+		"INSERT INTO users (id, email, full_name, password, role) VALUES ($1, $2, $3, $4, $5)",
+		user.Id, user.Email, user.FullName, user.Password, user.Role,
+	)
+
+	if insertError != nil {
+		return nil, insertError
+	}
+
+	var savedUser = models.User{}
+
+	getError := repo.db.QueryRowContext(
+		ctx,
+		"SELECT id FROM users WHERE id = $1",
+		user.Id,
+	).Scan(&savedUser.Id)
+
+	if getError != nil {
+		return nil, getError
+	}
+
+	return &savedUser, nil
+}
+
+func (repo *sqlRepository) ReadUser(ctx context.Context, id string) (*models.User, error) {
+	var user = models.User{}
+	var emailVerifiedAt sql.NullTime
+
+	getError := repo.db.QueryRowContext(
+		ctx,
+		"SELECT id, email, full_name, role, token_version, email_verified_at FROM users WHERE id = $1",
+		id,
+	).Scan(&user.Id, &user.Email, &user.FullName, &user.Role, &user.TokenVersion, &emailVerifiedAt)
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+
+	return &user, nil
+}
+
+func (repo *sqlRepository) ReadUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user = models.User{}
+	var emailVerifiedAt sql.NullTime
+
+	getError := repo.db.QueryRowContext(
+		ctx,
+		"SELECT id, email, password, role, token_version, email_verified_at FROM users WHERE email = $1",
+		email,
+	).Scan(&user.Id, &user.Email, &user.Password, &user.Role, &user.TokenVersion, &emailVerifiedAt)
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+
+	return &user, nil
+}
+
+func (repo *sqlRepository) CreateBankAccount(
+	ctx context.Context,
+	bankAccount *models.BankAccount,
+	opening *models.BankAccountTransition,
+) (*models.BankAccount, error) {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	defer tx.Rollback()
+
+	var existingId string
+
+	existingError := tx.QueryRowContext(
+		ctx,
+		"SELECT id FROM bank_accounts WHERE user_id = $1 AND name = $2",
+		bankAccount.UserId,
+		bankAccount.Name,
+	).Scan(&existingId)
+
+	if existingError == nil {
+		return nil, repositories.ErrDuplicateBankAccountName
+	}
+
+	if !errors.Is(existingError, sql.ErrNoRows) {
+		return nil, existingError
+	}
+
+	_, insertError := tx.ExecContext(
+		ctx,
+		"INSERT INTO bank_accounts (id, user_id, name, currency, state) VALUES ($1, $2, $3, $4, $5)",
+		bankAccount.Id, bankAccount.UserId, bankAccount.Name, bankAccount.Currency, bankAccount.State,
+	)
+
+	if insertError != nil {
+		return nil, insertError
+	}
+
+	if opening != nil {
+		if transitionErr := repo.transitionBankAccount(ctx, tx, bankAccount.UserId, opening); transitionErr != nil {
+			return nil, transitionErr
+		}
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+
+	var savedBankAccount = models.BankAccount{}
+
+	getError := repo.db.QueryRowContext(
+		ctx,
+		"SELECT id FROM bank_accounts WHERE id = $1",
+		bankAccount.Id,
+	).Scan(&savedBankAccount.Id)
+
+	if getError != nil {
+		return nil, getError
+	}
+
+	return &savedBankAccount, nil
+}
+
+func (repo *sqlRepository) GetBankAccountById(ctx context.Context, id string, userId string) (*models.BankAccount, error) {
+	var bankAccount = models.BankAccount{}
+	var balance int64
+
+	getError := repo.db.QueryRowContext(
+		ctx,
+		"SELECT id, name, currency, "+bankAccountBalanceSql+", state FROM bank_accounts WHERE id = $1 AND user_id = $2",
+		id,
+		userId,
+	).Scan(
+		&bankAccount.Id,
+		&bankAccount.Name,
+		&bankAccount.Currency,
+		&balance,
+		&bankAccount.State,
+	)
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	bankAccount.Balance = models.Money{Amount: balance, Currency: bankAccount.Currency}
+
+	return &bankAccount, nil
+}
+
+// ReadBankAccount reads a bank account whoever owns it, for staff.
+func (repo *sqlRepository) ReadBankAccount(ctx context.Context, id string, event *models.AuditEvent) (*models.BankAccount, error) {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	defer tx.Rollback()
+
+	var bankAccount = models.BankAccount{}
+	var balance int64
+
+	getError := tx.QueryRowContext(
+		ctx,
+		"SELECT id, user_id, name, currency, "+bankAccountBalanceSql+", state FROM bank_accounts WHERE id = $1",
+		id,
+	).Scan(
+		&bankAccount.Id,
+		&bankAccount.UserId,
+		&bankAccount.Name,
+		&bankAccount.Currency,
+		&balance,
+		&bankAccount.State,
+	)
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	if auditErr := insertAuditEvent(ctx, tx, event); auditErr != nil {
+		return nil, auditErr
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+
+	bankAccount.Balance = models.Money{Amount: balance, Currency: bankAccount.Currency}
+
+	return &bankAccount, nil
+}
+
+func (repo *sqlRepository) UpdateBankAccountById(
+	ctx context.Context,
+	id string,
+	userId string,
+	bankAccount *models.BankAccount,
+	transition *models.BankAccountTransition,
+) (*models.BankAccount, error) {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	defer tx.Rollback()
+
+	if bankAccount.Name != "" {
+		if renameErr := repo.renameBankAccount(ctx, tx, id, userId, bankAccount.Name); renameErr != nil {
+			return nil, renameErr
+		}
+	}
+
+	if transition != nil {
+		if transitionErr := repo.transitionBankAccount(ctx, tx, userId, transition); transitionErr != nil {
+			return nil, transitionErr
+		}
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+
+	return repo.GetBankAccountById(ctx, id, userId)
+}
+
+func (repo *sqlRepository) renameBankAccount(ctx context.Context, tx *sql.Tx, id string, userId string, name string) error {
+	var existingId string
+
+	existingError := tx.QueryRowContext(
+		ctx,
+		"SELECT id FROM bank_accounts WHERE user_id = $1 AND name = $2 AND id <> $3",
+		userId,
+		name,
+		id,
+	).Scan(&existingId)
+
+	if existingError == nil {
+		return repositories.ErrDuplicateBankAccountName
+	}
+
+	if !errors.Is(existingError, sql.ErrNoRows) {
+		return existingError
+	}
+
+	execResult, execErr := tx.ExecContext(ctx,
+		"UPDATE bank_accounts SET name = $1 WHERE id = $2 AND user_id = $3",
+		name,
+		id,
+		userId)
+
+	if execErr != nil {
+		return execErr
+	}
+
+	return expectRow(execResult)
+}
+
+// TransitionBankAccount moves a bank account to transition.To. The current
+// state is read under a row lock, so the legality check and the update cannot
+// race with another transition or with a posting.
+func (repo *sqlRepository) TransitionBankAccount(
+	ctx context.Context,
+	userId string,
+	transition *models.BankAccountTransition,
+	event *models.AuditEvent,
+) (*models.BankAccount, error) {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	defer tx.Rollback()
+
+	if transitionErr := repo.transitionBankAccount(ctx, tx, userId, transition); transitionErr != nil {
+		return nil, transitionErr
+	}
+
+	if auditErr := insertAuditEvent(ctx, tx, event); auditErr != nil {
+		return nil, auditErr
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+
+	return repo.GetBankAccountById(ctx, transition.BankAccountId, userId)
+}
+
+// CloseBankAccount closes a bank account and keeps its row and history. An
+// account that still holds money can only be closed together with a sweep
+// transaction, whose entries are sized here to the balance found under lock.
+func (repo *sqlRepository) CloseBankAccount(
+	ctx context.Context,
+	userId string,
+	closure *models.BankAccountClosure,
+) (*models.BankAccount, error) {
+	bankAccountId := closure.Transition.BankAccountId
+
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	defer tx.Rollback()
+
+	var lockIds = []string{bankAccountId}
+
+	if closure.Sweep != nil {
+		lockIds = closure.Sweep.BankAccountIds()
+	}
+
+	for _, lockId := range lockIds {
+		_, lockErr := tx.ExecContext(ctx, "SELECT id FROM bank_accounts WHERE id = $1"+repo.dialect.forUpdate, lockId)
+
+		if lockErr != nil {
+			return nil, lockErr
+		}
+	}
+
+	var state models.BankAccountState
+
+	ownerErr := tx.QueryRowContext(
+		ctx,
+		"SELECT state FROM bank_accounts WHERE id = $1 AND user_id = $2",
+		bankAccountId,
+		userId,
+	).Scan(&state)
+
+	if ownerErr != nil {
+		return nil, notFound(ownerErr)
+	}
+
+	if state == models.BankAccountStateFrozen && closure.Transition.ActorId == userId {
+		if frozenErr := repo.checkFrozenByOwner(ctx, tx, bankAccountId, userId); frozenErr != nil {
+			return nil, frozenErr
+		}
+	}
+
+	balance, balanceErr := bankAccountBalance(ctx, tx, bankAccountId)
+
+	if balanceErr != nil {
+		return nil, balanceErr
+	}
+
+	if !balance.IsZero() {
+		if closure.Sweep == nil || balance.IsNegative() {
+			return nil, models.ErrBankAccountNotEmpty
+		}
+
+		for _, entry := range closure.Sweep.Entries {
+			entry.Amount = balance
+		}
+
+		if postErr := repo.postTransaction(ctx, tx, closure.Sweep, userId); postErr != nil {
+			return nil, postErr
+		}
+	}
+
+	if transitionErr := repo.transitionBankAccount(ctx, tx, userId, closure.Transition); transitionErr != nil {
+		return nil, transitionErr
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+
+	return repo.GetBankAccountById(ctx, bankAccountId, userId)
+}
+
+// transitionBankAccount applies a state transition inside tx. Closing an
+// account requires its balance to be zero.
+func (repo *sqlRepository) transitionBankAccount(
+	ctx context.Context,
+	tx *sql.Tx,
+	userId string,
+	transition *models.BankAccountTransition,
+) error {
+	if transition.CreatedAt.IsZero() {
+		transition.CreatedAt = time.Now().UTC()
+	}
+
+	lockErr := tx.QueryRowContext(
+		ctx,
+		"SELECT state FROM bank_accounts WHERE id = $1 AND user_id = $2"+repo.dialect.forUpdate,
+		transition.BankAccountId,
+		userId,
+	).Scan(&transition.From)
+
+	if lockErr != nil {
+		return notFound(lockErr)
+	}
+
+	if transition.From == models.BankAccountStateFrozen && transition.ActorId == userId {
+		if frozenErr := repo.checkFrozenByOwner(ctx, tx, transition.BankAccountId, userId); frozenErr != nil {
+			return frozenErr
+		}
+	}
+
+	if validationErr := transition.Validate(); validationErr != nil {
+		return validationErr
+	}
+
+	if transition.To == models.BankAccountStateClosed {
+		balance, balanceErr := bankAccountBalance(ctx, tx, transition.BankAccountId)
+
+		if balanceErr != nil {
+			return balanceErr
+		}
+
+		if !balance.IsZero() {
+			return models.ErrBankAccountNotEmpty
+		}
+	}
+
+	_, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE bank_accounts SET state = $1 WHERE id = $2",
+		transition.To,
+		transition.BankAccountId,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	_, insertError := tx.ExecContext(
+		ctx,
+		"INSERT INTO bank_account_transitions (id, bank_account_id, from_state, to_state, reason, actor_id, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		transition.Id,
+		transition.BankAccountId,
+		transition.From,
+		transition.To,
+		transition.Reason,
+		transition.ActorId,
+		transition.CreatedAt.UTC(),
+	)
+
+	return insertError
+}
+
+// checkFrozenByOwner fails with models.ErrFrozenByStaff when the frozen
+// account, locked in tx, was frozen by someone other than its owner, so that
+// only staff can lift a freeze of theirs.
+func (repo *sqlRepository) checkFrozenByOwner(ctx context.Context, tx *sql.Tx, bankAccountId string, ownerId string) error {
+	var actorId string
+
+	lastErr := tx.QueryRowContext(
+		ctx,
+		"SELECT actor_id FROM bank_account_transitions WHERE bank_account_id = $1 ORDER BY created_at DESC, id"+repo.dialect.byteOrder+" DESC LIMIT 1",
+		bankAccountId,
+	).Scan(&actorId)
+
+	if errors.Is(lastErr, sql.ErrNoRows) {
+		return nil
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+
+	if actorId != ownerId {
+		return models.ErrFrozenByStaff
+	}
+
+	return nil
+}
+
+func bankAccountBalance(ctx context.Context, tx *sql.Tx, id string) (models.Money, error) {
+	var balance models.Money
+
+	balanceErr := tx.QueryRowContext(
+		ctx,
+		"SELECT currency, "+bankAccountBalanceSql+" FROM bank_accounts WHERE id = $1",
+		id,
+	).Scan(&balance.Currency, &balance.Amount)
+
+	return balance, balanceErr
+}
+
+func (repo *sqlRepository) GetBankAccountTransitions(
+	ctx context.Context,
+	id string,
+	userId string,
+) ([]*models.BankAccountTransition, error) {
+	result, getError := repo.db.QueryContext(
+		ctx,
+		`SELECT t.id, t.bank_account_id, t.from_state, t.to_state, t.reason, t.actor_id, t.created_at
+		FROM bank_account_transitions t
+		JOIN bank_accounts b ON b.id = t.bank_account_id
+		WHERE t.bank_account_id = $1 AND b.user_id = $2
+		ORDER BY t.created_at, t.id`+repo.dialect.byteOrder,
+		id,
+		userId,
+	)
+
+	if getError != nil {
+		return nil, getError
+	}
+
+	defer result.Close()
+
+	var transitions []*models.BankAccountTransition
+
+	for result.Next() {
+		var transition = models.BankAccountTransition{}
+
+		if getError = result.Scan(
+			&transition.Id,
+			&transition.BankAccountId,
+			&transition.From,
+			&transition.To,
+			&transition.Reason,
+			&transition.ActorId,
+			&transition.CreatedAt,
+		); getError != nil {
+			return nil, getError
+		}
+
+		transitions = append(transitions, &transition)
+	}
+
+	if getError = result.Err(); getError != nil {
+		return nil, getError
+	}
+
+	return transitions, nil
+}
+
+// DeleteBankAccountById hard deletes a bank account. Only accounts that never
+// had a ledger entry can go; anything with history has to be closed instead.
+func (repo *sqlRepository) DeleteBankAccountById(ctx context.Context, id string, userId string) error {
+	execResult, execErr := repo.db.ExecContext(
+		ctx,
+		`DELETE FROM bank_accounts
+		WHERE id = $1 AND user_id = $2
+		AND NOT EXISTS (SELECT 1 FROM ledger_entries WHERE ledger_entries.bank_account_id = bank_accounts.id)`,
+		id,
+		userId,
+	)
+
+	if execErr != nil {
+		return execErr
+	}
+
+	n, err := execResult.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		var existingId string
+
+		existingErr := repo.db.QueryRowContext(
+			ctx,
+			"SELECT id FROM bank_accounts WHERE id = $1 AND user_id = $2",
+			id,
+			userId,
+		).Scan(&existingId)
+
+		if existingErr != nil {
+			return notFound(existingErr)
+		}
+
+		return models.ErrBankAccountHasHistory
+	}
+
+	return nil
+}
+
+// GetAllBankAccountsByUserId lists the user's bank accounts one page at a
+// time. Pages are keyed on the sort column plus the id, which breaks ties, so
+// the order is stable even when names or balances repeat.
+func (repo *sqlRepository) GetAllBankAccountsByUserId(
+	ctx context.Context,
+	userId string,
+	filter *models.BankAccountFilter,
+	page models.PageRequest,
+) ([]*models.BankAccount, models.Page, error) {
+	q := &query{}
+
+	q.Write("SELECT id, name, currency, " + bankAccountBalanceSql + ", state FROM bank_accounts")
+	q.Write(" WHERE user_id = " + q.Arg(userId))
+
+	if filter.State != "" {
+		q.Write(" AND state = " + q.Arg(filter.State))
+	}
+
+	idColumn := "id" + repo.dialect.byteOrder
+	sortColumn := idColumn
+
+	switch filter.Sort {
+	case models.SortBankAccountsByName:
+		sortColumn = "name" + repo.dialect.byteOrder
+	case models.SortBankAccountsByBalance:
+		sortColumn = bankAccountBalanceSql
+	}
+
+	descending := filter.Descending != page.Backward()
+	comparison, direction := ">", "ASC"
+
+	if descending {
+		comparison, direction = "<", "DESC"
+	}
+
+	if page.Cursor != nil {
+		var key interface{} = page.Cursor.Key
+
+		if filter.Sort == models.SortBankAccountsByBalance {
+			balance, parseErr := strconv.ParseInt(page.Cursor.Key, 10, 64)
+
+			if parseErr != nil {
+				return nil, models.Page{}, models.ErrInvalidCursor
+			}
+
+			key = balance
+		}
+
+		if sortColumn == idColumn {
+			q.Write(" AND " + idColumn + " " + comparison + " " + q.Arg(page.Cursor.Id))
+		} else {
+			q.Write(" AND (" + sortColumn + ", " + idColumn + ") " + comparison + " (" + q.Arg(key) + ", " + q.Arg(page.Cursor.Id) + ")")
+		}
+	}
+
+	if sortColumn != idColumn {
+		q.Write(" ORDER BY " + sortColumn + " " + direction + ", " + idColumn + " " + direction)
+	} else {
+		q.Write(" ORDER BY " + idColumn + " " + direction)
+	}
+
+	q.Write(" LIMIT " + q.Arg(page.Limit+1))
+
+	result, getError := repo.db.QueryContext(ctx, q.String(), q.args...)
+
+	if getError != nil {
+		return nil, models.Page{}, getError
+	}
+
+	defer result.Close()
+
+	var bankAccounts []*models.BankAccount
+
+	for result.Next() {
+		var bankAccount = models.BankAccount{UserId: userId}
+		var balance int64
+
+		if getError = result.Scan(
+			&bankAccount.Id,
+			&bankAccount.Name,
+			&bankAccount.Currency,
+			&balance,
+			&bankAccount.State,
+		); getError != nil {
+			return nil, models.Page{}, getError
+		}
+
+		bankAccount.Balance = models.Money{Amount: balance, Currency: bankAccount.Currency}
+		bankAccounts = append(bankAccounts, &bankAccount)
+	}
+
+	if getError = result.Err(); getError != nil {
+		return nil, models.Page{}, getError
+	}
+
+	bankAccounts, currentPage := models.Paginate(bankAccounts, page, func(b *models.BankAccount) models.Cursor {
+		return models.Cursor{Key: b.SortKey(filter.Sort), Id: b.Id}
+	})
+
+	return bankAccounts, currentPage, nil
+}
+
+func (repo *sqlRepository) CreateTransaction(
+	ctx context.Context,
+	transaction *models.Transaction,
+) (*models.Transaction, error) {
+	return repo.createTransaction(ctx, transaction, "")
+}
+
+func (repo *sqlRepository) CreateTransactionForUser(
+	ctx context.Context,
+	userId string,
+	transaction *models.Transaction,
+) (*models.Transaction, error) {
+	return repo.createTransaction(ctx, transaction, userId)
+}
+
+func (repo *sqlRepository) createTransaction(
+	ctx context.Context,
+	transaction *models.Transaction,
+	userId string,
+) (*models.Transaction, error) {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	defer tx.Rollback()
+
+	if postErr := repo.postTransaction(ctx, tx, transaction, userId); postErr != nil {
+		return nil, postErr
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+
+	return transaction, nil
+}
+
+// postTransaction writes the transaction inside tx. Every bank account it
+// touches is locked first, in id order, so concurrent postings against the
+// same accounts are serialized and can neither overdraw an account, bypass
+// its state nor lose an update. When userId is set, every account the
+// transaction takes money from must belong to that user.
+func (repo *sqlRepository) postTransaction(
+	ctx context.Context,
+	tx *sql.Tx,
+	transaction *models.Transaction,
+	userId string,
+) error {
+	if validationErr := transaction.Validate(); validationErr != nil {
+		return validationErr
+	}
+
+	if transaction.CreatedAt.IsZero() {
+		transaction.CreatedAt = time.Now().UTC()
+	}
+
+	for _, bankAccountId := range transaction.BankAccountIds() {
+		if bankAccountId == models.ExternalBankAccountId {
+			continue
+		}
+
+		var ownerId string
+		var state models.BankAccountState
+
+		lockErr := tx.QueryRowContext(
+			ctx,
+			"SELECT user_id, state FROM bank_accounts WHERE id = $1"+repo.dialect.forUpdate,
+			bankAccountId,
+		).Scan(&ownerId, &state)
+
+		if lockErr != nil {
+			return notFound(lockErr)
+		}
+
+		net := transaction.NetAmount(bankAccountId)
+
+		// Only the owner may debit an account. Checking before anything else
+		// keeps the currency and state of other users' accounts from
+		// showing in the error.
+		if net.IsNegative() && userId != "" && ownerId != userId {
+			return repositories.ErrNotFound
+		}
+
+		balance, balanceErr := bankAccountBalance(ctx, tx, bankAccountId)
+
+		if balanceErr != nil {
+			return balanceErr
+		}
+
+		if net.Currency != balance.Currency {
+			return models.ErrCurrencyMismatch
+		}
+
+		if !net.IsNegative() {
+			if stateErr := state.CanCredit(); stateErr != nil {
+				return stateErr
+			}
+
+			continue
+		}
+
+		if stateErr := state.CanDebit(); stateErr != nil {
+			return stateErr
+		}
+
+		remaining, remainingErr := balance.Add(net)
+
+		if remainingErr != nil {
+			return remainingErr
+		}
+
+		if remaining.IsNegative() {
+			return models.ErrInsufficientFunds
+		}
+	}
+
+	_, insertError := tx.ExecContext(
+		ctx,
+		"INSERT INTO transactions (id, kind, description, created_at) VALUES ($1, $2, $3, $4)",
+		transaction.Id, transaction.Kind, transaction.Description, transaction.CreatedAt.UTC(),
+	)
+
+	if insertError != nil {
+		return insertError
+	}
+
+	for _, entry := range transaction.Entries {
+		entry.TransactionId = transaction.Id
+
+		_, insertError = tx.ExecContext(
+			ctx,
+			"INSERT INTO ledger_entries (id, transaction_id, bank_account_id, direction, amount, currency) VALUES ($1, $2, $3, $4, $5, $6)",
+			entry.Id,
+			entry.TransactionId,
+			entry.BankAccountId,
+			entry.Direction,
+			entry.Amount.Amount,
+			entry.Amount.Currency,
+		)
+
+		if insertError != nil {
+			return insertError
+		}
+	}
+
+	return nil
+}
+
+// GetBankAccountTransactions lists the ledger entries of one of the user's
+// bank accounts, newest first. Entry ids are ksuids, so ordering by id orders
+// by posting time and gives a stable sort to page over.
+func (repo *sqlRepository) GetBankAccountTransactions(
+	ctx context.Context,
+	userId string,
+	filter *models.TransactionFilter,
+	page models.PageRequest,
+) ([]*models.AccountTransaction, models.Page, error) {
+	q := &query{}
+
+	q.Write(`SELECT e.id, e.transaction_id, e.bank_account_id, t.kind, t.description, e.direction, e.amount, e.currency, t.created_at
+		FROM ledger_entries e
+		JOIN transactions t ON t.id = e.transaction_id
+		JOIN bank_accounts b ON b.id = e.bank_account_id`)
+	q.Write(" WHERE e.bank_account_id = " + q.Arg(filter.BankAccountId))
+	q.Write(" AND b.user_id = " + q.Arg(userId))
+
+	if !filter.From.IsZero() {
+		q.Write(" AND t.created_at >= " + q.Arg(filter.From.UTC()))
+	}
+
+	if !filter.To.IsZero() {
+		q.Write(" AND t.created_at < " + q.Arg(filter.To.UTC()))
+	}
+
+	if filter.MinAmount != nil {
+		q.Write(" AND e.amount >= " + q.Arg(filter.MinAmount.Amount))
+	}
+
+	if filter.MaxAmount != nil {
+		q.Write(" AND e.amount <= " + q.Arg(filter.MaxAmount.Amount))
+	}
+
+	if filter.Direction != "" {
+		q.Write(" AND e.direction = " + q.Arg(filter.Direction))
+	}
+
+	if page.Backward() {
+		q.Write(" AND e.id" + repo.dialect.byteOrder + " > " + q.Arg(page.Cursor.Id) + " ORDER BY e.id" + repo.dialect.byteOrder + " ASC")
+	} else if page.Cursor != nil {
+		q.Write(" AND e.id" + repo.dialect.byteOrder + " < " + q.Arg(page.Cursor.Id) + " ORDER BY e.id" + repo.dialect.byteOrder + " DESC")
+	} else {
+		q.Write(" ORDER BY e.id" + repo.dialect.byteOrder + " DESC")
+	}
+
+	q.Write(" LIMIT " + q.Arg(page.Limit+1))
+
+	result, getError := repo.db.QueryContext(ctx, q.String(), q.args...)
+
+	if getError != nil {
+		return nil, models.Page{}, getError
+	}
+
+	defer result.Close()
+
+	var transactions []*models.AccountTransaction
+
+	for result.Next() {
+		var transaction = models.AccountTransaction{}
+
+		if getError = result.Scan(
+			&transaction.EntryId,
+			&transaction.TransactionId,
+			&transaction.BankAccountId,
+			&transaction.Kind,
+			&transaction.Description,
+			&transaction.Direction,
+			&transaction.Amount.Amount,
+			&transaction.Amount.Currency,
+			&transaction.CreatedAt,
+		); getError != nil {
+			return nil, models.Page{}, getError
+		}
+
+		transactions = append(transactions, &transaction)
+	}
+
+	if getError = result.Err(); getError != nil {
+		return nil, models.Page{}, getError
+	}
+
+	transactions, currentPage := models.Paginate(transactions, page, func(t *models.AccountTransaction) models.Cursor {
+		return models.Cursor{Id: t.EntryId}
+	})
+
+	return transactions, currentPage, nil
+}
+
+func (repo *sqlRepository) Close() error {
+	return repo.db.Close()
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pipeline1987/SVB/database/migrations"
+	_ "modernc.org/sqlite"
+)
+
+// SQLite has no row locks and compares text in byte order already. Writes are
+// serialized instead by keeping a single connection open.
+var sqliteDialect = dialect{}
+
+// SqliteRepository keeps everything in a SQLite file, or in memory for
+// ":memory:", so SVB can run as a single binary without a database server.
+type SqliteRepository struct {
+	sqlRepository
+}
+
+// NewSqliteRepository opens the SQLite database at path and applies any
+// pending migration, since nobody runs the migrate command against a
+// database that only lives as long as the process.
+func NewSqliteRepository(ctx context.Context, path string) (*SqliteRepository, error) {
+	db, instanceError := openSqlite(path)
+
+	if instanceError != nil {
+		return nil, instanceError
+	}
+
+	if _, migrateError := migrations.Up(ctx, db); migrateError != nil {
+		db.Close()
+
+		return nil, migrateError
+	}
+
+	return &SqliteRepository{sqlRepository{db: db, dialect: sqliteDialect}}, nil
+}
+
+func openSqlite(path string) (*sql.DB, error) {
+	separator := "?"
+
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	db, err := sql.Open("sqlite", path+separator+"_pragma=foreign_keys(1)")
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection serializes transactions, which stands in for the
+	// row locks Postgres takes, and keeps a ":memory:" database alive.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	return db, nil
+}
@@ -0,0 +1,25 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/repositories/repositorytest"
+)
+
+func TestSqliteRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repositories.Repository {
+		repo, err := NewSqliteRepository(context.Background(), ":memory:")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			repo.Close()
+		})
+
+		return repo
+	})
+}
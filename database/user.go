@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pipeline1987/SVB/models"
+)
+
+// SetUserRole bumps the token version along with the role, so access tokens
+// carrying the old role stop working and the next refresh picks up the new
+// one.
+func (repo *sqlRepository) SetUserRole(ctx context.Context, userId string, role models.Role, event *models.AuditEvent) error {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	result, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE users SET role = $1, token_version = token_version + 1 WHERE id = $2",
+		role,
+		userId,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	if err := expectRow(result); err != nil {
+		return err
+	}
+
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (repo *sqlRepository) ListUsers(ctx context.Context, page models.PageRequest, event *models.AuditEvent) ([]*models.User, models.Page, error) {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, models.Page{}, txErr
+	}
+
+	defer tx.Rollback()
+
+	q := &query{}
+	idColumn := "id" + repo.dialect.byteOrder
+
+	q.Write("SELECT id, email, full_name, role, token_version, email_verified_at FROM users")
+
+	if page.Backward() {
+		q.Write(" WHERE " + idColumn + " < " + q.Arg(page.Cursor.Id) + " ORDER BY " + idColumn + " DESC")
+	} else if page.Cursor != nil {
+		q.Write(" WHERE " + idColumn + " > " + q.Arg(page.Cursor.Id) + " ORDER BY " + idColumn + " ASC")
+	} else {
+		q.Write(" ORDER BY " + idColumn + " ASC")
+	}
+
+	q.Write(" LIMIT " + q.Arg(page.Limit+1))
+
+	rows, queryErr := tx.QueryContext(ctx, q.String(), q.args...)
+
+	if queryErr != nil {
+		return nil, models.Page{}, queryErr
+	}
+
+	defer rows.Close()
+
+	var users []*models.User
+
+	for rows.Next() {
+		var user = models.User{}
+		var emailVerifiedAt sql.NullTime
+
+		scanErr := rows.Scan(&user.Id, &user.Email, &user.FullName, &user.Role, &user.TokenVersion, &emailVerifiedAt)
+
+		if scanErr != nil {
+			return nil, models.Page{}, scanErr
+		}
+
+		if emailVerifiedAt.Valid {
+			user.EmailVerifiedAt = &emailVerifiedAt.Time
+		}
+
+		users = append(users, &user)
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return nil, models.Page{}, rowsErr
+	}
+
+	rows.Close()
+
+	if auditErr := insertAuditEvent(ctx, tx, event); auditErr != nil {
+		return nil, models.Page{}, auditErr
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, models.Page{}, commitErr
+	}
+
+	users, currentPage := models.Paginate(users, page, func(u *models.User) models.Cursor {
+		return models.Cursor{Id: u.Id}
+	})
+
+	return users, currentPage, nil
+}
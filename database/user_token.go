@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+func (repo *sqlRepository) VerifyEmail(ctx context.Context, userId string, at time.Time) error {
+	result, updateErr := repo.db.ExecContext(
+		ctx,
+		"UPDATE users SET email_verified_at = COALESCE(email_verified_at, $1) WHERE id = $2",
+		at.UTC(),
+		userId,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	return expectRow(result)
+}
+
+func (repo *sqlRepository) ResetPassword(ctx context.Context, userId string, password string, at time.Time) error {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return txErr
+	}
+
+	defer tx.Rollback()
+
+	result, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE users SET password = $1 WHERE id = $2",
+		password,
+		userId,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	if err := expectRow(result); err != nil {
+		return err
+	}
+
+	if err := revokeAllTokens(ctx, tx, userId, at); err != nil {
+		return err
+	}
+
+	_, keysErr := tx.ExecContext(
+		ctx,
+		"UPDATE api_keys SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL",
+		at.UTC(),
+		userId,
+	)
+
+	if keysErr != nil {
+		return keysErr
+	}
+
+	return tx.Commit()
+}
+
+func (repo *sqlRepository) RehashPassword(ctx context.Context, userId string, current string, password string) error {
+	result, updateErr := repo.db.ExecContext(
+		ctx,
+		"UPDATE users SET password = $1 WHERE id = $2 AND password = $3",
+		password,
+		userId,
+		current,
+	)
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	if rowErr := expectRow(result); rowErr != nil {
+		return repositories.ErrConflict
+	}
+
+	return nil
+}
+
+func (repo *sqlRepository) CreateUserToken(ctx context.Context, token *models.UserToken) error {
+	_, insertError := repo.db.ExecContext(
+		ctx,
+		"INSERT INTO user_tokens (id, user_id, purpose, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		token.Id,
+		token.UserId,
+		token.Purpose,
+		token.TokenHash,
+		token.CreatedAt.UTC(),
+		token.ExpiresAt.UTC(),
+	)
+
+	return insertError
+}
+
+// UseUserToken reads the token under a row lock, so two requests racing
+// with the same token cannot both use it.
+func (repo *sqlRepository) UseUserToken(
+	ctx context.Context,
+	purpose models.UserTokenPurpose,
+	tokenHash string,
+	at time.Time,
+) (*models.UserToken, error) {
+	tx, txErr := repo.db.BeginTx(ctx, nil)
+
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	defer tx.Rollback()
+
+	var token = models.UserToken{}
+	var usedAt sql.NullTime
+
+	getError := tx.QueryRowContext(
+		ctx,
+		"SELECT id, user_id, purpose, token_hash, created_at, expires_at, used_at FROM user_tokens WHERE token_hash = $1 AND purpose = $2"+repo.dialect.forUpdate,
+		tokenHash,
+		purpose,
+	).Scan(&token.Id, &token.UserId, &token.Purpose, &token.TokenHash, &token.CreatedAt, &token.ExpiresAt, &usedAt)
+
+	if getError != nil {
+		return nil, notFound(getError)
+	}
+
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+
+	if usableErr := token.CheckUsable(at); usableErr != nil {
+		return nil, usableErr
+	}
+
+	_, updateErr := tx.ExecContext(
+		ctx,
+		"UPDATE user_tokens SET used_at = $1 WHERE user_id = $2 AND purpose = $3 AND used_at IS NULL",
+		at.UTC(),
+		token.UserId,
+		purpose,
+	)
+
+	if updateErr != nil {
+		return nil, updateErr
+	}
+
+	if commitErr := tx.Commit(); commitErr != nil {
+		return nil, commitErr
+	}
+
+	usedNow := at
+	token.UsedAt = &usedNow
+
+	return &token, nil
+}
+
+// expectRow reports an update that matched no row as
+// repositories.ErrNotFound.
+func expectRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return repositories.ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/validation"
+)
+
+// The handlers here serve the admin API. Routes pick who may call them with
+// middlewares.RequirePermission, and every action is audited.
+
+type AdminUserResponse struct {
+	Id            string      `json:"id"`
+	FullName      string      `json:"full_name"`
+	Email         string      `json:"email"`
+	EmailVerified bool        `json:"email_verified"`
+	Role          models.Role `json:"role"`
+}
+
+type AdminBankAccountResponse struct {
+	Id      string                  `json:"id"`
+	UserId  string                  `json:"user_id"`
+	Name    string                  `json:"name"`
+	Balance models.Money            `json:"balance"`
+	State   models.BankAccountState `json:"state"`
+}
+
+type AdminTransitionRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (r *AdminTransitionRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.Reason, "reason")
+	v.MaxLength(r.Reason, maxReasonLength, "reason")
+
+	return v.Err()
+}
+
+func ListUsersHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, err := pageRequestFromQuery(r)
+
+		if err != nil {
+			problems.Write(w, r, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		event, err := newAuditEvent(r, models.AuditUsersListed, "")
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		users, currentPage, repoErr := repositories.ListUsers(r.Context(), page, event)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		var data = make([]AdminUserResponse, 0, len(users))
+
+		for _, user := range users {
+			data = append(data, AdminUserResponse{
+				Id:            user.Id,
+				FullName:      user.FullName,
+				Email:         user.Email,
+				EmailVerified: user.EmailVerifiedAt != nil,
+				Role:          user.Role,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PageResponse{
+			Data:       data,
+			NextCursor: currentPage.NextCursor,
+			PrevCursor: currentPage.PrevCursor,
+		})
+	}
+}
+
+// UnlockUserHandler forgets the failed sign ins of a user, so they can sign
+// in again before their lockout ends.
+func UnlockUserHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, repoErr := repositories.ReadUser(r.Context(), mux.Vars(r)["id"])
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		key := models.EmailLoginKey(user.Email)
+		event, err := newAuditEvent(r, models.AuditLoginUnlocked, key)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		if repoErr = repositories.ClearLoginThrottle(r.Context(), key, event); repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func GetAnyBankAccountHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		event, err := newAuditEvent(r, models.AuditBankAccountViewed, id)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		bankAccount, repoErr := repositories.ReadBankAccount(r.Context(), id, event)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		writeAdminBankAccount(w, bankAccount)
+	}
+}
+
+// FreezeBankAccountHandler freezes any bank account. Its owner can't
+// unfreeze it, only staff can.
+func FreezeBankAccountHandler(s server.Server) http.HandlerFunc {
+	return adminTransitionHandler(s, models.BankAccountStateFrozen, models.AuditBankAccountFrozen)
+}
+
+func UnfreezeBankAccountHandler(s server.Server) http.HandlerFunc {
+	return adminTransitionHandler(s, models.BankAccountStateActive, models.AuditBankAccountUnfrozen)
+}
+
+// adminTransitionHandler moves any bank account to the state to on behalf of
+// the signed in staff member, and audits it as action.
+func adminTransitionHandler(s server.Server, to models.BankAccountState, action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actorId := r.Context().Value(middlewares.ContextUserId)
+
+		var request = AdminTransitionRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		bankAccount, repoErr := repositories.ReadBankAccount(r.Context(), mux.Vars(r)["id"], nil)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		// Dormant accounts go back to active too, but that is up to
+		// their owner.
+		if to == models.BankAccountStateActive && bankAccount.State != models.BankAccountStateFrozen {
+			problems.Write(w, r, http.StatusConflict, "bank account is not frozen")
+
+			return
+		}
+
+		ownerId := bankAccount.UserId
+		event, err := newAuditEvent(r, action, bankAccount.Id)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		bankAccount, repoErr = transitionBankAccount(r, bankAccount.Id, ownerId, actorId.(string), to, request.Reason, event)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		bankAccount.UserId = ownerId
+
+		s.Hub().Broadcast(models.WebSocketMessage{
+			Type:    "bank_account_" + string(bankAccount.State),
+			Payload: bankAccount.Id,
+		}, nil)
+
+		writeAdminBankAccount(w, bankAccount)
+	}
+}
+
+func writeAdminBankAccount(w http.ResponseWriter, bankAccount *models.BankAccount) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AdminBankAccountResponse{
+		Id:      bankAccount.Id,
+		UserId:  bankAccount.UserId,
+		Name:    bankAccount.Name,
+		Balance: bankAccount.Balance,
+		State:   bankAccount.State,
+	})
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pipeline1987/SVB/database/memory"
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/segmentio/ksuid"
+)
+
+func TestAdminFreeze(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	ctx := context.Background()
+	s := &testServer{}
+
+	router := mux.NewRouter()
+	router.Handle("/admin/bank-accounts/{id}/freeze", middlewares.RequirePermission(models.PermissionFreezeBankAccounts)(FreezeBankAccountHandler(s)))
+	router.Handle("/admin/bank-accounts/{id}/unfreeze", middlewares.RequirePermission(models.PermissionFreezeBankAccounts)(UnfreezeBankAccountHandler(s)))
+	router.Handle("/bank-accounts/{id}/transitions", CreateBankAccountTransitionHandler(s))
+	router.Handle("/bank-accounts/{id}/close", CloseBankAccountHandler(s))
+	router.Handle("/bank-accounts/{id}", DeleteBankAccountByIdHandler(s))
+
+	// signUp makes a user with role and returns the claims of their token.
+	signUp := func(email string, role models.Role) *server.AppClaims {
+		var response SignUpResponse
+
+		if status := call(t, SignUpHandler(s), "", `{"email":"`+email+`","password":"correct horse"}`, &response); status != http.StatusOK {
+			t.Fatalf("sign up: status %d", status)
+		}
+
+		if err := repositories.SetUserRole(ctx, response.Id, role, nil); err != nil {
+			t.Fatalf("SetUserRole: %v", err)
+		}
+
+		return &server.AppClaims{UserId: response.Id, Role: role}
+	}
+
+	do := func(claims *server.AppClaims, path string, body string) int {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), middlewares.ContextUserId, claims.UserId))
+		r = r.WithContext(context.WithValue(r.Context(), middlewares.ContextClaims, claims))
+		r = r.WithContext(context.WithValue(r.Context(), middlewares.ContextRole, claims.Role))
+
+		router.ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	customer := signUp("ada@example.com", models.RoleCustomer)
+	support := signUp("grace@example.com", models.RoleSupport)
+	admin := signUp("alan@example.com", models.RoleAdmin)
+
+	id := ksuid.New().String()
+	bankAccount := &models.BankAccount{
+		Id:       id,
+		UserId:   customer.UserId,
+		Name:     "Savings",
+		Currency: models.DefaultCurrency,
+		Balance:  models.Money{Currency: models.DefaultCurrency},
+		State:    models.BankAccountStatePending,
+	}
+
+	if _, err := repositories.CreateBankAccount(ctx, bankAccount, nil); err != nil {
+		t.Fatalf("CreateBankAccount: %v", err)
+	}
+
+	reason := `{"reason":"checking"}`
+	activate := `{"state":"active","reason":"opening"}`
+	freeze := `{"state":"frozen","reason":"lost my card"}`
+
+	if status := do(customer, "/bank-accounts/"+id+"/transitions", activate); status != http.StatusOK {
+		t.Fatalf("activating: status %d", status)
+	}
+
+	for _, claims := range []*server.AppClaims{customer, support} {
+		if status := do(claims, "/admin/bank-accounts/"+id+"/freeze", reason); status != http.StatusForbidden {
+			t.Errorf("freezing as %s: status %d, want %d", claims.Role, status, http.StatusForbidden)
+		}
+	}
+
+	if status := do(admin, "/admin/bank-accounts/"+id+"/freeze", reason); status != http.StatusOK {
+		t.Fatalf("freezing as admin: status %d", status)
+	}
+
+	if status := do(customer, "/bank-accounts/"+id+"/transitions", activate); status != http.StatusForbidden {
+		t.Errorf("unfreezing a staff freeze as the owner: status %d, want %d", status, http.StatusForbidden)
+	}
+
+	// Nor can they get out of it by closing the account.
+	closings := map[string]string{
+		"closing":  "/bank-accounts/" + id + "/close",
+		"deleting": "/bank-accounts/" + id,
+	}
+
+	for name, path := range closings {
+		if status := do(customer, path, "{}"); status != http.StatusForbidden {
+			t.Errorf("%s a staff freeze as the owner: status %d, want %d", name, status, http.StatusForbidden)
+		}
+	}
+
+	if status := do(customer, "/bank-accounts/"+id+"/transitions", `{"state":"closed","reason":"leaving"}`); status != http.StatusForbidden {
+		t.Errorf("closing a staff freeze by transition: status %d, want %d", status, http.StatusForbidden)
+	}
+
+	if status := do(admin, "/admin/bank-accounts/"+id+"/unfreeze", reason); status != http.StatusOK {
+		t.Fatalf("unfreezing as admin: status %d", status)
+	}
+
+	// Owners still undo freezes of their own.
+	if status := do(customer, "/bank-accounts/"+id+"/transitions", freeze); status != http.StatusOK {
+		t.Fatalf("freezing as the owner: status %d", status)
+	}
+
+	if status := do(customer, "/bank-accounts/"+id+"/transitions", activate); status != http.StatusOK {
+		t.Errorf("unfreezing as the owner: status %d, want %d", status, http.StatusOK)
+	}
+}
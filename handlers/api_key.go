@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/validation"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// maxApiKeys is how many live API keys a user may hold at once.
+	maxApiKeys = 20
+	// apiKeyPrefixLength is how much of a key is kept in the clear to tell
+	// it apart from the others.
+	apiKeyPrefixLength = len(models.ApiKeyPrefix) + 8
+)
+
+// CreateApiKeyRequest makes an API key granted Scopes. The key never
+// expires unless ExpiresAt is set.
+type CreateApiKeyRequest struct {
+	Name      string         `json:"name"`
+	Scopes    []models.Scope `json:"scopes"`
+	ExpiresAt *time.Time     `json:"expires_at"`
+}
+
+func (r *CreateApiKeyRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.Name, "name")
+	v.MaxLength(r.Name, maxNameLength, "name")
+	v.Check(len(r.Scopes) > 0, "scopes", "is required")
+
+	for _, scope := range r.Scopes {
+		v.Check(scope.IsValid(), "scopes", models.ErrInvalidScope.Error())
+	}
+
+	if r.ExpiresAt != nil {
+		v.Check(r.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+
+	return v.Err()
+}
+
+type UpdateApiKeyRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *UpdateApiKeyRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.Name, "name")
+	v.MaxLength(r.Name, maxNameLength, "name")
+
+	return v.Err()
+}
+
+// ApiKeyResponse describes an API key. The key itself is only shown once,
+// in CreateApiKeyResponse.
+type ApiKeyResponse struct {
+	Id         string         `json:"id"`
+	Name       string         `json:"name"`
+	Prefix     string         `json:"prefix"`
+	Scopes     []models.Scope `json:"scopes"`
+	CreatedAt  time.Time      `json:"created_at"`
+	ExpiresAt  *time.Time     `json:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at"`
+}
+
+type CreateApiKeyResponse struct {
+	ApiKeyResponse
+	Key string `json:"key"`
+}
+
+// CreateApiKeyHandler makes an API key for the signed in user. Its response
+// is the only time the key is shown, as only a hash of it is kept.
+func CreateApiKeyHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId).(string)
+
+		var request = CreateApiKeyRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		user, repoErr := repositories.ReadUser(r.Context(), userId)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		keys, repoErr := repositories.GetApiKeysByUserId(r.Context(), userId)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		if len(keys) >= maxApiKeys {
+			problems.Write(w, r, http.StatusConflict, "too many API keys, revoke one first")
+
+			return
+		}
+
+		id, err := ksuid.NewRandom()
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		token, _, err := newOpaqueToken()
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		key := models.ApiKeyPrefix + token
+		apiKey := &models.ApiKey{
+			Id:           id.String(),
+			UserId:       userId,
+			Name:         request.Name,
+			Prefix:       key[:apiKeyPrefixLength],
+			KeyHash:      models.HashApiKey(key),
+			Scopes:       uniqueScopes(request.Scopes),
+			TokenVersion: user.TokenVersion,
+			CreatedAt:    time.Now().UTC(),
+			ExpiresAt:    request.ExpiresAt,
+		}
+
+		if apiKey.ExpiresAt != nil {
+			expiresAt := apiKey.ExpiresAt.UTC()
+			apiKey.ExpiresAt = &expiresAt
+		}
+
+		event, err := newAuditEvent(r, models.AuditApiKeyCreated, apiKey.Id)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		if repoErr = repositories.CreateApiKey(r.Context(), apiKey, event); repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(CreateApiKeyResponse{
+			ApiKeyResponse: newApiKeyResponse(apiKey),
+			Key:            key,
+		})
+	}
+}
+
+func GetApiKeysHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId).(string)
+
+		keys, repoErr := repositories.GetApiKeysByUserId(r.Context(), userId)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		var response = make([]ApiKeyResponse, 0, len(keys))
+
+		for _, key := range keys {
+			response = append(response, newApiKeyResponse(key))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func GetApiKeyHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId).(string)
+
+		key, repoErr := repositories.GetApiKey(r.Context(), mux.Vars(r)["id"], userId)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newApiKeyResponse(key))
+	}
+}
+
+// UpdateApiKeyHandler renames an API key. Its scopes and expiry can't be
+// changed, a key granted more has to be made instead.
+func UpdateApiKeyHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId).(string)
+
+		var request = UpdateApiKeyRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		key, repoErr := repositories.RenameApiKey(r.Context(), mux.Vars(r)["id"], userId, request.Name)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newApiKeyResponse(key))
+	}
+}
+
+// DeleteApiKeyHandler revokes an API key. Requests made with it fail from
+// then on.
+func DeleteApiKeyHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId).(string)
+		id := mux.Vars(r)["id"]
+
+		event, err := newAuditEvent(r, models.AuditApiKeyRevoked, id)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		if repoErr := repositories.RevokeApiKey(r.Context(), id, userId, time.Now().UTC(), event); repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newApiKeyResponse(key *models.ApiKey) ApiKeyResponse {
+	return ApiKeyResponse{
+		Id:         key.Id,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+// uniqueScopes drops repeated scopes, keeping the order they were asked in.
+func uniqueScopes(scopes []models.Scope) []models.Scope {
+	var unique []models.Scope
+
+	for _, scope := range scopes {
+		repeated := false
+
+		for _, seen := range unique {
+			repeated = repeated || seen == scope
+		}
+
+		if !repeated {
+			unique = append(unique, scope)
+		}
+	}
+
+	return unique
+}
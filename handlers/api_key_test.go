@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pipeline1987/SVB/database/memory"
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+func TestApiKeyScopes(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	s := &testServer{}
+
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(middlewares.AuthMiddleware(s))
+	api.Handle("/bank-accounts", middlewares.Declare(
+		middlewares.Scoped(models.ScopeAccountsRead),
+		GetAllBankAccountByUserIdHandler(s),
+	)).Methods(http.MethodGet)
+	api.Handle("/bank-accounts", middlewares.Declare(
+		middlewares.Scoped(models.ScopeAccountsWrite),
+		CreateBankAccountHandler(s),
+	)).Methods(http.MethodPost)
+	api.Handle("/users/me/api-keys", middlewares.Declare(
+		middlewares.Authenticated,
+		CreateApiKeyHandler(s),
+	)).Methods(http.MethodPost)
+
+	var user SignUpResponse
+
+	if status := call(t, SignUpHandler(s), "", `{"email":"ada@example.com","password":"correct horse"}`, &user); status != http.StatusOK {
+		t.Fatalf("sign up: status %d", status)
+	}
+
+	var created CreateApiKeyResponse
+
+	body := `{"name":"reports","scopes":["accounts:read","accounts:read"]}`
+
+	if status := call(t, CreateApiKeyHandler(s), user.Id, body, &created); status != http.StatusCreated {
+		t.Fatalf("creating an API key: status %d", status)
+	}
+
+	if !strings.HasPrefix(created.Key, models.ApiKeyPrefix) || !strings.HasPrefix(created.Key, created.Prefix) {
+		t.Errorf("created key %q with prefix %q, want both to start with %q", created.Key, created.Prefix, models.ApiKeyPrefix)
+	}
+
+	if len(created.Scopes) != 1 {
+		t.Errorf("created key scopes = %v, want accounts:read once", created.Scopes)
+	}
+
+	do := func(method string, path string, key string, body string) int {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set(middlewares.ApiKeyHeader, key)
+
+		router.ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	if status := do(http.MethodGet, "/api/bank-accounts", created.Key, ""); status != http.StatusOK {
+		t.Errorf("listing bank accounts with the key: status %d, want 200", status)
+	}
+
+	if status := do(http.MethodPost, "/api/bank-accounts", created.Key, `{"name":"Savings"}`); status != http.StatusForbidden {
+		t.Errorf("creating a bank account without accounts:write: status %d, want 403", status)
+	}
+
+	if status := do(http.MethodPost, "/api/users/me/api-keys", created.Key, body); status != http.StatusForbidden {
+		t.Errorf("creating an API key with an API key: status %d, want 403", status)
+	}
+
+	if status := do(http.MethodGet, "/api/bank-accounts", created.Key+"x", ""); status != http.StatusUnauthorized {
+		t.Errorf("listing bank accounts with a wrong key: status %d, want 401", status)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": created.Id})
+	r = r.WithContext(context.WithValue(r.Context(), middlewares.ContextUserId, user.Id))
+
+	DeleteApiKeyHandler(s)(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("revoking the key: status %d", w.Code)
+	}
+
+	if status := do(http.MethodGet, "/api/bank-accounts", created.Key, ""); status != http.StatusUnauthorized {
+		t.Errorf("listing bank accounts with a revoked key: status %d, want 401", status)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/segmentio/ksuid"
+)
+
+// newAuditEvent returns the record of action on subject, done by the user
+// signed in to r, if any, from the address r came from. Changes that are
+// audited pass it to the repository along with the change, so that neither
+// is stored without the other.
+func newAuditEvent(r *http.Request, action string, subject string) (*models.AuditEvent, error) {
+	id, err := ksuid.NewRandom()
+
+	if err != nil {
+		return nil, err
+	}
+
+	actorId, _ := r.Context().Value(middlewares.ContextUserId).(string)
+
+	return &models.AuditEvent{
+		Id:        id.String(),
+		Action:    action,
+		ActorId:   actorId,
+		Subject:   subject,
+		IpAddress: clientIp(r),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
@@ -4,16 +4,34 @@ import (
 	"encoding/json"
 	"github.com/gorilla/mux"
 	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
 	"github.com/segmentio/ksuid"
 	"net/http"
 
 	"github.com/pipeline1987/SVB/middlewares"
 	"github.com/pipeline1987/SVB/repositories"
 	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/validation"
 )
 
 type CreateBankAccountRequest struct {
-	Name string `json:"name"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+}
+
+func (r *CreateBankAccountRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.Name, "name")
+	v.MaxLength(r.Name, maxNameLength, "name")
+
+	if r.Currency != "" {
+		_, err := models.CurrencyExponent(r.Currency)
+
+		v.Check(err == nil, "currency", "is not supported")
+	}
+
+	return v.Err()
 }
 
 type CreateBankAccountResponse struct {
@@ -21,15 +39,36 @@ type CreateBankAccountResponse struct {
 }
 
 type GetBankAccountResponse struct {
-	Id      string  `json:"id"`
-	Name    string  `json:"name"`
-	Balance float64 `json:"balance"`
-	State   string  `json:"state"`
+	Id      string                  `json:"id"`
+	Name    string                  `json:"name"`
+	Balance models.Money            `json:"balance"`
+	State   models.BankAccountState `json:"state"`
 }
 
+// UpdateBankAccountRequest renames a bank account and, when State is set,
+// moves it through its lifecycle. A state change needs a Reason.
 type UpdateBankAccountRequest struct {
-	Name  string `json:"name"`
-	State string `json:"state"`
+	Name   string                  `json:"name"`
+	State  models.BankAccountState `json:"state"`
+	Reason string                  `json:"reason"`
+}
+
+func (r *UpdateBankAccountRequest) Validate() error {
+	var v validation.Validator
+
+	if r.Name != "" {
+		v.Required(r.Name, "name")
+		v.MaxLength(r.Name, maxNameLength, "name")
+	}
+
+	if r.State != "" {
+		v.Check(r.State.IsValid(), "state", "is not a bank account state")
+		v.Required(r.Reason, "reason")
+	}
+
+	v.MaxLength(r.Reason, maxReasonLength, "reason")
+
+	return v.Err()
 }
 
 func CreateBankAccountHandler(s server.Server) http.HandlerFunc {
@@ -38,10 +77,22 @@ func CreateBankAccountHandler(s server.Server) http.HandlerFunc {
 
 		var request = CreateBankAccountRequest{}
 
-		err := json.NewDecoder(r.Body).Decode(&request)
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		if !requireVerifiedEmail(w, r, userId.(string)) {
+			return
+		}
+
+		if request.Currency == "" {
+			request.Currency = models.DefaultCurrency
+		}
+
+		balance, err := models.NewMoney(0, request.Currency)
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			problems.WriteError(w, r, err)
 
 			return
 		}
@@ -49,23 +100,34 @@ func CreateBankAccountHandler(s server.Server) http.HandlerFunc {
 		id, err := ksuid.NewRandom()
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problems.WriteError(w, r, err)
 
 			return
 		}
 
 		var bankAccount = models.BankAccount{
-			Id:      id.String(),
-			UserId:  userId.(string),
-			Name:    request.Name,
-			Balance: 0,
-			State:   "active",
+			Id:       id.String(),
+			UserId:   userId.(string),
+			Name:     request.Name,
+			Currency: request.Currency,
+			Balance:  balance,
+			State:    models.BankAccountStatePending,
+		}
+
+		// The account is opened in the same step, so it is never left
+		// pending.
+		opening, err := newBankAccountTransition(bankAccount.Id, userId.(string), models.BankAccountStateActive, "account opened")
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
 		}
 
-		savedBankAccount, repoErr := repositories.CreateBankAccount(r.Context(), &bankAccount)
+		savedBankAccount, repoErr := repositories.CreateBankAccount(r.Context(), &bankAccount, opening)
 
 		if repoErr != nil {
-			http.Error(w, repoErr.Error(), http.StatusInternalServerError)
+			problems.WriteError(w, r, repoErr)
 
 			return
 		}
@@ -93,7 +155,7 @@ func GetBankAccountByIdHandler(s server.Server) http.HandlerFunc {
 		bankAccount, repoErr := repositories.GetBankAccountById(r.Context(), params["id"], userId.(string))
 
 		if repoErr != nil {
-			http.Error(w, repoErr.Error(), http.StatusInternalServerError)
+			problems.WriteError(w, r, repoErr)
 
 			return
 		}
@@ -114,30 +176,52 @@ func UpdateBankAccountByIdHandler(s server.Server) http.HandlerFunc {
 		params := mux.Vars(r)
 
 		var request = UpdateBankAccountRequest{}
-		err := json.NewDecoder(r.Body).Decode(&request)
 
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		updatedBankAccount, repoErr := repositories.GetBankAccountById(r.Context(), params["id"], userId.(string))
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
 
 			return
 		}
 
-		var bankAccount = models.BankAccount{
-			Name:  request.Name,
-			State: request.State,
+		var bankAccount = models.BankAccount{}
+		var transition *models.BankAccountTransition
+
+		if request.Name != updatedBankAccount.Name {
+			bankAccount.Name = request.Name
 		}
 
-		updatedBankAccount, repoErr := repositories.UpdateBankAccountById(
-			r.Context(),
-			params["id"],
-			userId.(string),
-			&bankAccount,
-		)
+		if request.State != "" && request.State != updatedBankAccount.State {
+			transition, repoErr = newBankAccountTransition(params["id"], userId.(string), request.State, request.Reason)
 
-		if repoErr != nil {
-			http.Error(w, repoErr.Error(), http.StatusInternalServerError)
+			if repoErr != nil {
+				problems.WriteError(w, r, repoErr)
 
-			return
+				return
+			}
+		}
+
+		// The rename and the transition are made together, so that one
+		// failing leaves the other undone.
+		if bankAccount.Name != "" || transition != nil {
+			updatedBankAccount, repoErr = repositories.UpdateBankAccountById(
+				r.Context(),
+				params["id"],
+				userId.(string),
+				&bankAccount,
+				transition,
+			)
+
+			if repoErr != nil {
+				problems.WriteError(w, r, repoErr)
+
+				return
+			}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -150,43 +234,116 @@ func UpdateBankAccountByIdHandler(s server.Server) http.HandlerFunc {
 	}
 }
 
+// DeleteBankAccountByIdHandler closes the bank account rather than deleting
+// it, so its row and history stay around for audit. The sweep_to query
+// parameter names the account that receives any remaining balance.
 func DeleteBankAccountByIdHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userId := r.Context().Value(middlewares.ContextUserId)
 		params := mux.Vars(r)
 
-		repoErr := repositories.DeleteBankAccountById(
-			r.Context(),
+		bankAccount, repoErr := closeBankAccount(
+			r,
 			params["id"],
 			userId.(string),
+			r.URL.Query().Get("sweep_to"),
+			defaultClosureReason,
 		)
 
 		if repoErr != nil {
-			http.Error(w, repoErr.Error(), http.StatusInternalServerError)
+			problems.WriteError(w, r, repoErr)
 
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
+		var message = models.WebSocketMessage{
+			Type:    "bank_account_closed",
+			Payload: bankAccount.Id,
+		}
+
+		s.Hub().Broadcast(message, nil)
+
 		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// bankAccountFilterFromQuery reads the state, sort (created, name or
+// balance) and order (asc or desc) query parameters.
+func bankAccountFilterFromQuery(r *http.Request) (*models.BankAccountFilter, error) {
+	query := r.URL.Query()
+
+	var filter = models.BankAccountFilter{
+		State: models.BankAccountState(query.Get("state")),
+		Sort:  query.Get("sort"),
+	}
+
+	if filter.State != "" && !filter.State.IsValid() {
+		return nil, models.ErrInvalidBankAccountState
+	}
 
-		return
+	switch filter.Sort {
+	case "":
+		filter.Sort = models.SortBankAccountsByCreated
+	case models.SortBankAccountsByCreated, models.SortBankAccountsByName, models.SortBankAccountsByBalance:
+	default:
+		return nil, models.ErrInvalidBankAccountSort
 	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return nil, models.ErrInvalidBankAccountSort
+	}
+
+	return &filter, nil
 }
 
 func GetAllBankAccountByUserIdHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userId := r.Context().Value(middlewares.ContextUserId)
 
-		bankAccounts, repoErr := repositories.GetAllBankAccountsByUserId(r.Context(), userId.(string))
+		page, err := pageRequestFromQuery(r)
+
+		if err != nil {
+			problems.Write(w, r, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		filter, err := bankAccountFilterFromQuery(r)
+
+		if err != nil {
+			problems.Write(w, r, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		bankAccounts, currentPage, repoErr := repositories.GetAllBankAccountsByUserId(r.Context(), userId.(string), filter, page)
 
 		if repoErr != nil {
-			http.Error(w, repoErr.Error(), http.StatusInternalServerError)
+			problems.WriteError(w, r, repoErr)
 
 			return
 		}
 
+		var data = make([]GetBankAccountResponse, 0, len(bankAccounts))
+
+		for _, bankAccount := range bankAccounts {
+			data = append(data, GetBankAccountResponse{
+				Id:      bankAccount.Id,
+				Name:    bankAccount.Name,
+				Balance: bankAccount.Balance,
+				State:   bankAccount.State,
+			})
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(bankAccounts)
+		json.NewEncoder(w).Encode(PageResponse{
+			Data:       data,
+			NextCursor: currentPage.NextCursor,
+			PrevCursor: currentPage.PrevCursor,
+		})
 	}
 }
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/validation"
+	"github.com/segmentio/ksuid"
+)
+
+const defaultClosureReason = "closed by owner"
+
+// CloseBankAccountRequest closes a bank account. An account that still holds
+// money needs SweepToBankAccountId, which receives the remaining balance.
+type CloseBankAccountRequest struct {
+	SweepToBankAccountId string `json:"sweep_to_bank_account_id"`
+	Reason               string `json:"reason"`
+}
+
+func (r *CloseBankAccountRequest) Validate() error {
+	var v validation.Validator
+
+	v.Check(r.SweepToBankAccountId != models.ExternalBankAccountId, "sweep_to_bank_account_id", "must be a bank account")
+	v.MaxLength(r.Reason, maxReasonLength, "reason")
+
+	return v.Err()
+}
+
+func closeBankAccount(
+	r *http.Request,
+	bankAccountId string,
+	userId string,
+	sweepToBankAccountId string,
+	reason string,
+) (*models.BankAccount, error) {
+	id, err := ksuid.NewRandom()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if reason == "" {
+		reason = defaultClosureReason
+	}
+
+	var closure = models.BankAccountClosure{
+		Transition: &models.BankAccountTransition{
+			Id:            id.String(),
+			BankAccountId: bankAccountId,
+			To:            models.BankAccountStateClosed,
+			Reason:        reason,
+			ActorId:       userId,
+		},
+	}
+
+	if sweepToBankAccountId != "" {
+		if sweepToBankAccountId == bankAccountId || sweepToBankAccountId == models.ExternalBankAccountId {
+			return nil, models.ErrInvalidSweepTarget
+		}
+
+		closure.Sweep, err = newTransaction(
+			models.SweepTransaction,
+			reason,
+			&models.LedgerEntry{
+				BankAccountId: bankAccountId,
+				Direction:     models.DebitEntry,
+			},
+			&models.LedgerEntry{
+				BankAccountId: sweepToBankAccountId,
+				Direction:     models.CreditEntry,
+			},
+		)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return repositories.CloseBankAccount(r.Context(), userId, &closure)
+}
+
+func CloseBankAccountHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+		params := mux.Vars(r)
+
+		var request = CloseBankAccountRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		bankAccount, repoErr := closeBankAccount(
+			r,
+			params["id"],
+			userId.(string),
+			request.SweepToBankAccountId,
+			request.Reason,
+		)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		var message = models.WebSocketMessage{
+			Type:    "bank_account_closed",
+			Payload: bankAccount.Id,
+		}
+
+		s.Hub().Broadcast(message, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetBankAccountResponse{
+			Id:      bankAccount.Id,
+			Name:    bankAccount.Name,
+			Balance: bankAccount.Balance,
+			State:   bankAccount.State,
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/validation"
+	"github.com/segmentio/ksuid"
+)
+
+type CreateBankAccountTransitionRequest struct {
+	State  models.BankAccountState `json:"state"`
+	Reason string                  `json:"reason"`
+}
+
+func (r *CreateBankAccountTransitionRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(string(r.State), "state")
+	v.Check(r.State.IsValid(), "state", "is not a bank account state")
+	v.Required(r.Reason, "reason")
+	v.MaxLength(r.Reason, maxReasonLength, "reason")
+
+	return v.Err()
+}
+
+type BankAccountTransitionResponse struct {
+	Id        string                  `json:"id"`
+	From      models.BankAccountState `json:"from"`
+	To        models.BankAccountState `json:"to"`
+	Reason    string                  `json:"reason"`
+	ActorId   string                  `json:"actor_id"`
+	CreatedAt time.Time               `json:"created_at"`
+}
+
+// transitionBankAccount moves one of the user's bank accounts to the given
+// state on behalf of actorId, audited as event if there is one.
+func transitionBankAccount(
+	r *http.Request,
+	bankAccountId string,
+	userId string,
+	actorId string,
+	to models.BankAccountState,
+	reason string,
+	event *models.AuditEvent,
+) (*models.BankAccount, error) {
+	transition, err := newBankAccountTransition(bankAccountId, actorId, to, reason)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return repositories.TransitionBankAccount(r.Context(), userId, transition, event)
+}
+
+// newBankAccountTransition returns the transition of a bank account to the
+// given state on behalf of actorId. Whether it is allowed, the owner
+// undoing a staff freeze included, is checked by the repository.
+func newBankAccountTransition(
+	bankAccountId string,
+	actorId string,
+	to models.BankAccountState,
+	reason string,
+) (*models.BankAccountTransition, error) {
+	id, err := ksuid.NewRandom()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.BankAccountTransition{
+		Id:            id.String(),
+		BankAccountId: bankAccountId,
+		To:            to,
+		Reason:        reason,
+		ActorId:       actorId,
+	}, nil
+}
+
+func CreateBankAccountTransitionHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+		params := mux.Vars(r)
+
+		var request = CreateBankAccountTransitionRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		bankAccount, repoErr := transitionBankAccount(
+			r,
+			params["id"],
+			userId.(string),
+			userId.(string),
+			request.State,
+			request.Reason,
+			nil,
+		)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		var message = models.WebSocketMessage{
+			Type:    "bank_account_" + string(bankAccount.State),
+			Payload: bankAccount.Id,
+		}
+
+		s.Hub().Broadcast(message, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetBankAccountResponse{
+			Id:      bankAccount.Id,
+			Name:    bankAccount.Name,
+			Balance: bankAccount.Balance,
+			State:   bankAccount.State,
+		})
+	}
+}
+
+func GetBankAccountTransitionsHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+		params := mux.Vars(r)
+
+		transitions, repoErr := repositories.GetBankAccountTransitions(r.Context(), params["id"], userId.(string))
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		var response = make([]BankAccountTransitionResponse, 0, len(transitions))
+
+		for _, transition := range transitions {
+			response = append(response, BankAccountTransitionResponse{
+				Id:        transition.Id,
+				From:      transition.From,
+				To:        transition.To,
+				Reason:    transition.Reason,
+				ActorId:   transition.ActorId,
+				CreatedAt: transition.CreatedAt,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pipeline1987/SVB/mailer"
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/validation"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	verifyEmailLifetime   = 24 * time.Hour
+	resetPasswordLifetime = time.Hour
+	maxUserTokenLength    = 128
+	// backgroundMailTimeout bounds emails sent after the request they are
+	// for has been answered.
+	backgroundMailTimeout = time.Minute
+)
+
+// mailing tracks the emails being sent after answering, so tests can wait
+// for them.
+var mailing sync.WaitGroup
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type RequestPasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (r *VerifyEmailRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.Token, "token")
+	v.MaxLength(r.Token, maxUserTokenLength, "token")
+
+	return v.Err()
+}
+
+func (r *RequestPasswordResetRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.Email, "email")
+	v.MaxLength(r.Email, maxEmailLength, "email")
+
+	return v.Err()
+}
+
+func (r *ResetPasswordRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.Token, "token")
+	v.MaxLength(r.Token, maxUserTokenLength, "token")
+	checkPassword(&v, r.Password, "password")
+
+	return v.Err()
+}
+
+// RequestEmailVerificationHandler sends a new verification email, for users
+// who lost the one sent when they signed up.
+func RequestEmailVerificationHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+
+		user, repoErr := repositories.ReadUser(r.Context(), userId.(string))
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		if user.EmailVerifiedAt != nil {
+			problems.WriteError(w, r, models.ErrEmailAlreadyVerified)
+
+			return
+		}
+
+		if err := sendVerificationEmail(r, s, user); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func VerifyEmailHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request = VerifyEmailRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		token, ok := useUserToken(w, r, s, models.UserTokenVerifyEmail, request.Token)
+
+		if !ok {
+			return
+		}
+
+		if err := repositories.VerifyEmail(r.Context(), token.UserId, time.Now().UTC()); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RequestPasswordResetHandler emails a reset token to the address given. It
+// answers the same, and as fast, whether or not the address belongs to a
+// user, so it cannot be used to find out who banks with SVB. Requests are throttled like
+// failed sign ins, so it cannot be used to flood an inbox either.
+func RequestPasswordResetHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request = RequestPasswordResetRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		throttles := resetThrottles(r, request.Email)
+
+		if !checkLoginThrottles(w, r, throttles) {
+			return
+		}
+
+		if err := recordLoginFailure(r, throttles); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		// The address is looked up and mailed after answering, so how long
+		// the answer takes doesn't tell whether it is registered.
+		mailing.Add(1)
+
+		go func() {
+			defer mailing.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), backgroundMailTimeout)
+			defer cancel()
+
+			user, repoErr := repositories.ReadUserByEmail(ctx, request.Email)
+
+			if repoErr == nil {
+				repoErr = sendUserToken(ctx, s, user, models.UserTokenResetPassword, resetPasswordLifetime, resetPasswordEmail)
+			}
+
+			if repoErr != nil && !errors.Is(repoErr, repositories.ErrNotFound) {
+				log.Printf("sending a password reset email: %v", repoErr)
+			}
+		}()
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// ResetPasswordHandler sets a new password, signs the user out of every
+// session and revokes their API keys, as whoever knew the old password may
+// have signed in or made keys. Reading the
+// reset email also proves the address, so it is verified too.
+func ResetPasswordHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request = ResetPasswordRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		token, ok := useUserToken(w, r, s, models.UserTokenResetPassword, request.Token)
+
+		if !ok {
+			return
+		}
+
+		hashedPassword, err := s.Passwords().Hash(request.Password)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		now := time.Now().UTC()
+
+		if err = repositories.ResetPassword(r.Context(), token.UserId, hashedPassword, now); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		if err = repositories.VerifyEmail(r.Context(), token.UserId, now); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// requireVerifiedEmail answers with a 403 unless the user has verified their
+// email address. It reports whether the request can go on.
+func requireVerifiedEmail(w http.ResponseWriter, r *http.Request, userId string) bool {
+	user, repoErr := repositories.ReadUser(r.Context(), userId)
+
+	if repoErr != nil {
+		problems.WriteError(w, r, repoErr)
+
+		return false
+	}
+
+	if user.EmailVerifiedAt == nil {
+		problems.WriteError(w, r, models.ErrEmailNotVerified)
+
+		return false
+	}
+
+	return true
+}
+
+// useUserToken uses up the signed token for purpose, answering with a 400
+// when it is not one. It reports whether the request can go on.
+func useUserToken(
+	w http.ResponseWriter,
+	r *http.Request,
+	s server.Server,
+	purpose models.UserTokenPurpose,
+	signed string,
+) (*models.UserToken, bool) {
+	tokenHash, ok := openSignedToken(s, purpose, signed)
+
+	if !ok {
+		problems.Write(w, r, http.StatusBadRequest, "invalid token")
+
+		return nil, false
+	}
+
+	token, repoErr := repositories.UseUserToken(r.Context(), purpose, tokenHash, time.Now().UTC())
+
+	if errors.Is(repoErr, repositories.ErrNotFound) {
+		problems.Write(w, r, http.StatusBadRequest, "invalid token")
+
+		return nil, false
+	}
+
+	if repoErr != nil {
+		problems.WriteError(w, r, repoErr)
+
+		return nil, false
+	}
+
+	return token, true
+}
+
+func sendVerificationEmail(r *http.Request, s server.Server, user *models.User) error {
+	return sendUserToken(r.Context(), s, user, models.UserTokenVerifyEmail, verifyEmailLifetime, verifyEmail)
+}
+
+// sendUserToken stores a new token for purpose and emails it to user in the
+// message compose writes.
+func sendUserToken(
+	ctx context.Context,
+	s server.Server,
+	user *models.User,
+	purpose models.UserTokenPurpose,
+	lifetime time.Duration,
+	compose func(token string) mailer.Message,
+) error {
+	id, err := ksuid.NewRandom()
+
+	if err != nil {
+		return err
+	}
+
+	token, tokenHash, err := newSignedToken(s, purpose)
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	err = repositories.CreateUserToken(ctx, &models.UserToken{
+		Id:        id.String(),
+		UserId:    user.Id,
+		Purpose:   purpose,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(lifetime),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	message := compose(token)
+	message.To = user.Email
+
+	return s.Mailer().Send(ctx, message)
+}
+
+func verifyEmail(token string) mailer.Message {
+	return mailer.Message{
+		Subject: "Verify your email address",
+		Body: "Welcome to SVB.\n\n" +
+			"To verify your email address, send this token to POST /api/users/verify-email:\n\n" +
+			token + "\n\n" +
+			"It expires in 24 hours. You need a verified address to open bank accounts.\n",
+	}
+}
+
+func resetPasswordEmail(token string) mailer.Message {
+	return mailer.Message{
+		Subject: "Reset your password",
+		Body: "Someone asked to reset the password of your SVB account.\n\n" +
+			"To choose a new password, send this token to POST /api/users/password-reset:\n\n" +
+			token + "\n\n" +
+			"It expires in an hour. If it wasn't you, you can ignore this email.\n",
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pipeline1987/SVB/database/memory"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+// mailedToken finds the token in the last email sent, the only line of the
+// body without spaces.
+func mailedToken(t *testing.T, s *testServer) string {
+	t.Helper()
+
+	if len(s.outbox) == 0 {
+		t.Fatalf("no email was sent")
+	}
+
+	for _, line := range strings.Split(s.outbox[len(s.outbox)-1].Body, "\n") {
+		if line != "" && !strings.Contains(line, " ") {
+			return line
+		}
+	}
+
+	t.Fatalf("the email has no token")
+
+	return ""
+}
+
+func TestVerifyEmail(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	s := &testServer{}
+
+	var signUp SignUpResponse
+
+	if status := call(t, SignUpHandler(s), "", `{"email":"ada@example.com","password":"correct horse"}`, &signUp); status != http.StatusOK {
+		t.Fatalf("sign up: status %d", status)
+	}
+
+	if len(s.outbox) != 1 || s.outbox[0].To != "ada@example.com" {
+		t.Fatalf("sign up sent %+v, want a verification email to the new user", s.outbox)
+	}
+
+	if status := call(t, CreateBankAccountHandler(s), signUp.Id, `{"name":"main"}`, nil); status != http.StatusForbidden {
+		t.Errorf("opening an account unverified: status %d, want %d", status, http.StatusForbidden)
+	}
+
+	token := mailedToken(t, s)
+
+	if status := call(t, ResetPasswordHandler(s), "", `{"token":"`+token+`","password":"new password"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("resetting the password with a verification token: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	if status := call(t, VerifyEmailHandler(s), "", `{"token":"`+token+`"}`, nil); status != http.StatusNoContent {
+		t.Fatalf("verify: status %d", status)
+	}
+
+	if status := call(t, VerifyEmailHandler(s), "", `{"token":"`+token+`"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("verifying twice: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var user GetUserResponse
+
+	if call(t, GetUserHandler(s), signUp.Id, "", &user); !user.EmailVerified {
+		t.Errorf("GetUserHandler = %+v, want a verified email", user)
+	}
+
+	if status := call(t, RequestEmailVerificationHandler(s), signUp.Id, "", nil); status != http.StatusConflict {
+		t.Errorf("asking to verify a verified email: status %d, want %d", status, http.StatusConflict)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	s := &testServer{}
+
+	if status := call(t, SignUpHandler(s), "", `{"email":"ada@example.com","password":"correct horse"}`, nil); status != http.StatusOK {
+		t.Fatalf("sign up: status %d", status)
+	}
+
+	sent := len(s.outbox)
+
+	if status := call(t, RequestPasswordResetHandler(s), "", `{"email":"eve@example.com"}`, nil); status != http.StatusAccepted {
+		t.Errorf("reset for an unknown email: status %d, want %d", status, http.StatusAccepted)
+	}
+
+	mailing.Wait()
+
+	if len(s.outbox) != sent {
+		t.Errorf("a reset for an unknown email sent an email")
+	}
+
+	if status := call(t, RequestPasswordResetHandler(s), "", `{"email":"ada@example.com"}`, nil); status != http.StatusAccepted {
+		t.Fatalf("reset: status %d", status)
+	}
+
+	mailing.Wait()
+
+	token := mailedToken(t, s)
+
+	if status := call(t, ResetPasswordHandler(s), "", `{"token":"`+token+`x","password":"new password"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("reset with a tampered token: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	if status := call(t, ResetPasswordHandler(s), "", `{"token":"`+token+`","password":"new password"}`, nil); status != http.StatusNoContent {
+		t.Fatalf("reset: status %d", status)
+	}
+
+	if status := call(t, ResetPasswordHandler(s), "", `{"token":"`+token+`","password":"other password"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("reset twice: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	if status := call(t, SignInHandler(s), "", `{"email":"ada@example.com","password":"correct horse"}`, nil); status != http.StatusUnauthorized {
+		t.Errorf("sign in with the old password: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	if status := call(t, SignInHandler(s), "", `{"email":"ada@example.com","password":"new password"}`, nil); status != http.StatusOK {
+		t.Errorf("sign in with the new password: status %d, want %d", status, http.StatusOK)
+	}
+}
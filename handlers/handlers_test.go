@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pipeline1987/SVB/keyring"
+	"github.com/pipeline1987/SVB/mailer"
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/passwords"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/websocket"
+)
+
+// testServer is a server.Server whose mailer keeps what it sends in outbox.
+type testServer struct {
+	outbox []mailer.Message
+	keys   *keyring.Ring
+}
+
+func (s *testServer) Config() *server.Config {
+	return &server.Config{
+		JWT_SECRET:            "secret",
+		HASH_COST:             "4",
+		SIGN_EXPIRE_HOURS:     "1",
+		ACCESS_EXPIRE_MINUTES: "15",
+	}
+}
+
+func (s *testServer) Hub() *websocket.Hub {
+	return websocket.NewHub()
+}
+
+func (s *testServer) Mailer() mailer.Mailer {
+	return s
+}
+
+func (s *testServer) Keys() *keyring.Ring {
+	if s.keys == nil {
+		keys, err := keyring.New("secret", keyring.EdDSA, time.Hour, time.Hour)
+
+		if err != nil {
+			panic(err)
+		}
+
+		s.keys = keys
+	}
+
+	return s.keys
+}
+
+// Passwords hashes with the cheapest argon2id there is, to keep tests fast.
+func (s *testServer) Passwords() *passwords.Hasher {
+	return passwords.New(
+		passwords.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		passwords.Bcrypt{Cost: 4},
+	)
+}
+
+func (s *testServer) Send(ctx context.Context, message mailer.Message) error {
+	s.outbox = append(s.outbox, message)
+
+	return nil
+}
+
+// call runs handler with body, signed in as userId unless it is empty, and
+// decodes the response into response.
+func call(t *testing.T, handler http.HandlerFunc, userId string, body string, response interface{}) int {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+	if userId != "" {
+		r = r.WithContext(context.WithValue(r.Context(), middlewares.ContextUserId, userId))
+	}
+
+	handler(w, r)
+
+	if response != nil && w.Code < http.StatusMultipleChoices {
+		if err := json.NewDecoder(w.Body).Decode(response); err != nil {
+			t.Fatalf("decoding the response: %v", err)
+		}
+	}
+
+	return w.Code
+}
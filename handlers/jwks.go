@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pipeline1987/SVB/keyring"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/server"
+)
+
+// jwksMaxAge is how long verifiers may cache the key set. Keys are
+// published a whole overlap before they sign anything, so this only has to
+// be well short of JWT_OVERLAP_HOURS.
+const jwksMaxAge = "public, max-age=300"
+
+type JwksResponse struct {
+	Keys []keyring.JWK `json:"keys"`
+}
+
+// JwksHandler publishes the public keys access tokens are verified with, so
+// that other services can check SVB tokens without asking SVB.
+func JwksHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := s.Keys().JWKS(r.Context())
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", jwksMaxAge)
+		json.NewEncoder(w).Encode(JwksResponse{Keys: keys})
+	}
+}
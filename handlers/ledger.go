@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/validation"
+	"github.com/segmentio/ksuid"
+)
+
+type CreateMovementRequest struct {
+	Amount      models.Money `json:"amount"`
+	Description string       `json:"description"`
+}
+
+func (r *CreateMovementRequest) Validate() error {
+	var v validation.Validator
+
+	checkAmount(&v, r.Amount, "amount")
+	v.MaxLength(r.Description, maxDescriptionLength, "description")
+
+	return v.Err()
+}
+
+type CreateMovementResponse struct {
+	Id            string       `json:"id"`
+	Kind          string       `json:"kind"`
+	BankAccountId string       `json:"bank_account_id"`
+	Amount        models.Money `json:"amount"`
+	Description   string       `json:"description"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+// newTransaction assigns fresh ids to a transaction and its entries.
+func newTransaction(kind string, description string, entries ...*models.LedgerEntry) (*models.Transaction, error) {
+	id, err := ksuid.NewRandom()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		entryId, entryErr := ksuid.NewRandom()
+
+		if entryErr != nil {
+			return nil, entryErr
+		}
+
+		entry.Id = entryId.String()
+		entry.TransactionId = id.String()
+	}
+
+	return &models.Transaction{
+		Id:          id.String(),
+		Kind:        kind,
+		Description: description,
+		Entries:     entries,
+	}, nil
+}
+
+func CreateDepositHandler(s server.Server) http.HandlerFunc {
+	return createMovementHandler(s, models.DepositTransaction)
+}
+
+func CreateWithdrawalHandler(s server.Server) http.HandlerFunc {
+	return createMovementHandler(s, models.WithdrawalTransaction)
+}
+
+// createMovementHandler posts money into (deposits) or out of (withdrawals)
+// one of the caller's bank accounts, against the external settlement account.
+func createMovementHandler(s server.Server, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+		params := mux.Vars(r)
+
+		var request = CreateMovementRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		bankAccount, repoErr := repositories.GetBankAccountById(r.Context(), params["id"], userId.(string))
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		from, to := models.ExternalBankAccountId, bankAccount.Id
+
+		if kind == models.WithdrawalTransaction {
+			from, to = bankAccount.Id, models.ExternalBankAccountId
+		}
+
+		transaction, err := newTransaction(
+			kind,
+			request.Description,
+			&models.LedgerEntry{
+				BankAccountId: from,
+				Direction:     models.DebitEntry,
+				Amount:        request.Amount,
+			},
+			&models.LedgerEntry{
+				BankAccountId: to,
+				Direction:     models.CreditEntry,
+				Amount:        request.Amount,
+			},
+		)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		savedTransaction, repoErr := repositories.CreateTransactionForUser(r.Context(), userId.(string), transaction)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		var message = models.WebSocketMessage{
+			Type:    kind + "_created",
+			Payload: savedTransaction.Id,
+		}
+
+		s.Hub().Broadcast(message, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(CreateMovementResponse{
+			Id:            savedTransaction.Id,
+			Kind:          savedTransaction.Kind,
+			BankAccountId: bankAccount.Id,
+			Amount:        request.Amount,
+			Description:   savedTransaction.Description,
+			CreatedAt:     savedTransaction.CreatedAt,
+		})
+	}
+}
+
+type AccountTransactionResponse struct {
+	Id          string       `json:"id"`
+	EntryId     string       `json:"entry_id"`
+	Kind        string       `json:"kind"`
+	Description string       `json:"description"`
+	Direction   string       `json:"direction"`
+	Amount      models.Money `json:"amount"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// transactionFilterFromQuery reads the from, to (RFC 3339, to is exclusive),
+// min_amount, max_amount (decimal, in the account currency) and direction
+// query parameters.
+func transactionFilterFromQuery(r *http.Request, bankAccount *models.BankAccount) (*models.TransactionFilter, error) {
+	query := r.URL.Query()
+
+	var filter = models.TransactionFilter{
+		BankAccountId: bankAccount.Id,
+		Direction:     query.Get("direction"),
+	}
+
+	if filter.Direction != "" && filter.Direction != models.DebitEntry && filter.Direction != models.CreditEntry {
+		return nil, models.ErrInvalidEntryDirection
+	}
+
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+
+			*target = parsed
+		}
+	}
+
+	for name, target := range map[string]**models.Money{"min_amount": &filter.MinAmount, "max_amount": &filter.MaxAmount} {
+		if value := query.Get(name); value != "" {
+			parsed, err := models.ParseMoney(value, bankAccount.Currency, models.RoundUnnecessary)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+
+			*target = &parsed
+		}
+	}
+
+	return &filter, nil
+}
+
+// GetBankAccountTransactionsHandler lists the transaction history of one of
+// the caller's bank accounts, newest first, one page at a time.
+func GetBankAccountTransactionsHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+		params := mux.Vars(r)
+
+		page, err := pageRequestFromQuery(r)
+
+		if err != nil {
+			problems.Write(w, r, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		bankAccount, repoErr := repositories.GetBankAccountById(r.Context(), params["id"], userId.(string))
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		filter, err := transactionFilterFromQuery(r, bankAccount)
+
+		if err != nil {
+			problems.Write(w, r, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		transactions, currentPage, repoErr := repositories.GetBankAccountTransactions(r.Context(), userId.(string), filter, page)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		var data = make([]AccountTransactionResponse, 0, len(transactions))
+
+		for _, transaction := range transactions {
+			data = append(data, AccountTransactionResponse{
+				Id:          transaction.TransactionId,
+				EntryId:     transaction.EntryId,
+				Kind:        transaction.Kind,
+				Description: transaction.Description,
+				Direction:   transaction.Direction,
+				Amount:      transaction.Amount,
+				CreatedAt:   transaction.CreatedAt,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PageResponse{
+			Data:       data,
+			NextCursor: currentPage.NextCursor,
+			PrevCursor: currentPage.PrevCursor,
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+// loginThrottle is one of the keys failed sign ins are counted against.
+type loginThrottle struct {
+	key    string
+	policy models.LoginPolicy
+}
+
+// loginThrottles are the keys a sign in for email from r is throttled by:
+// the account, so one password can't be guessed at quickly from many
+// addresses, and the client address, so many accounts can't be guessed at
+// quickly from one.
+func loginThrottles(r *http.Request, email string) []loginThrottle {
+	return []loginThrottle{
+		{models.EmailLoginKey(email), models.EmailLoginPolicy},
+		{models.IpLoginKey(clientIp(r)), models.IpLoginPolicy},
+	}
+}
+
+// resetThrottles are the keys requests for a password reset of email from r
+// are throttled by. They are kept apart from the sign in keys, so asking for
+// resets can't lock anybody out of signing in.
+func resetThrottles(r *http.Request, email string) []loginThrottle {
+	throttles := loginThrottles(r, email)
+
+	for i := range throttles {
+		throttles[i].key = "reset:" + throttles[i].key
+	}
+
+	return throttles
+}
+
+// checkLoginThrottles answers with a 429 while any of throttles must wait,
+// telling the client for how long in Retry-After. It reports whether the
+// request can go on.
+func checkLoginThrottles(w http.ResponseWriter, r *http.Request, throttles []loginThrottle) bool {
+	now := time.Now().UTC()
+	var wait time.Duration
+
+	for _, throttle := range throttles {
+		stored, repoErr := repositories.GetLoginThrottle(r.Context(), throttle.key)
+
+		if errors.Is(repoErr, repositories.ErrNotFound) {
+			continue
+		}
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return false
+		}
+
+		if stored.Blocked(now) && stored.BlockedUntil.Sub(now) > wait {
+			wait = stored.BlockedUntil.Sub(now)
+		}
+	}
+
+	if wait == 0 {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	problems.WriteError(w, r, models.ErrLoginThrottled)
+
+	return false
+}
+
+// recordLoginFailure counts a failed sign in against every one of throttles
+// and audits each lockout it causes.
+func recordLoginFailure(r *http.Request, throttles []loginThrottle) error {
+	now := time.Now().UTC()
+
+	for _, throttle := range throttles {
+		lockout, err := newAuditEvent(r, models.AuditLoginLockedOut, throttle.key)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = repositories.RecordLoginFailure(r.Context(), throttle.key, now, throttle.policy, lockout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// failSignIn records a failed sign in and answers it.
+func failSignIn(w http.ResponseWriter, r *http.Request, throttles []loginThrottle) {
+	if err := recordLoginFailure(r, throttles); err != nil {
+		problems.WriteError(w, r, err)
+
+		return
+	}
+
+	problems.Write(w, r, http.StatusUnauthorized, "invalid credentials")
+}
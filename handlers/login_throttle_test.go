@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pipeline1987/SVB/database/memory"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+func TestSignInThrottle(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	s := &testServer{}
+
+	if status := call(t, SignUpHandler(s), "", `{"email":"ada@example.com","password":"correct horse"}`, nil); status != http.StatusOK {
+		t.Fatalf("sign up: status %d", status)
+	}
+
+	signIn := func(password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := `{"email":"ada@example.com","password":"` + password + `"}`
+
+		SignInHandler(s)(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+		return w
+	}
+
+	for i := 0; i <= models.EmailLoginPolicy.FreeFailures; i++ {
+		if w := signIn("wrong horse"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password #%d: status %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	w := signIn("correct horse")
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("sign in while throttled: status %d, Retry-After %q, want %d after 1s",
+			w.Code, w.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+
+	// Unlocking the account lets its owner straight back in.
+	if err := repositories.ClearLoginThrottle(context.Background(), models.EmailLoginKey("ada@example.com"), nil); err != nil {
+		t.Fatalf("ClearLoginThrottle: %v", err)
+	}
+
+	if w = signIn("correct horse"); w.Code != http.StatusOK {
+		t.Fatalf("sign in once unlocked: status %d", w.Code)
+	}
+
+	throttle, err := repositories.GetLoginThrottle(context.Background(), models.IpLoginKey("192.0.2.1"))
+
+	if err != nil || throttle.Failures != models.EmailLoginPolicy.FreeFailures+1 {
+		t.Errorf("throttle of the client address = %+v, %v, want the failures still counted", throttle, err)
+	}
+}
@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/totp"
+	"github.com/pipeline1987/SVB/validation"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// totpIssuer names SVB in authenticator apps.
+	totpIssuer            = "SVB"
+	mfaChallengeLifetime  = 5 * time.Minute
+	recoveryCodeCount     = 10
+	recoveryCodeBytes     = 10
+	maxSecondFactorLength = 32
+)
+
+// totpCodes checks TOTP codes. Tests replace its clock.
+var totpCodes = totp.New()
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type ConfirmTotpRequest struct {
+	Code string `json:"code"`
+}
+
+// SecondFactorRequest proves a user has their authenticator, with either a
+// TOTP code or one of their recovery codes.
+type SecondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MfaSignInRequest struct {
+	MfaToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (r *ConfirmTotpRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.Code, "code")
+	v.MaxLength(r.Code, maxSecondFactorLength, "code")
+
+	return v.Err()
+}
+
+func (r *SecondFactorRequest) Validate() error {
+	var v validation.Validator
+
+	checkSecondFactor(&v, r.Code, r.RecoveryCode)
+
+	return v.Err()
+}
+
+func (r *MfaSignInRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.MfaToken, "mfa_token")
+	checkSecondFactor(&v, r.Code, r.RecoveryCode)
+
+	return v.Err()
+}
+
+// checkSecondFactor checks that exactly one of code and recoveryCode was
+// given.
+func checkSecondFactor(v *validation.Validator, code string, recoveryCode string) {
+	v.Check(code != "" || recoveryCode != "", "code", "is required unless a recovery_code is given")
+	v.Check(code == "" || recoveryCode == "", "recovery_code", "cannot be given together with a code")
+	v.MaxLength(code, maxSecondFactorLength, "code")
+	v.MaxLength(recoveryCode, maxSecondFactorLength, "recovery_code")
+}
+
+type EnrollTotpResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+type ConfirmTotpResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MfaChallengeResponse is the answer to a correct password when two-factor
+// authentication is enabled. The mfa_token is traded for tokens at
+// /users/sign-in/mfa together with a code.
+type MfaChallengeResponse struct {
+	MfaRequired bool   `json:"mfa_required"`
+	MfaToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// EnrollTotpHandler starts enrolling an authenticator app. Sign in does not
+// ask for codes until the enrollment is confirmed with a first one.
+func EnrollTotpHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+
+		user, repoErr := repositories.ReadUser(r.Context(), userId.(string))
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		err = repositories.SaveTotpCredential(r.Context(), &models.TotpCredential{
+			UserId:    user.Id,
+			Secret:    secret,
+			CreatedAt: time.Now().UTC(),
+		})
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(EnrollTotpResponse{
+			Secret:     secret,
+			OtpauthUri: totpCodes.URI(secret, totpIssuer, user.Email),
+		})
+	}
+}
+
+// ConfirmTotpHandler enables two-factor authentication once the user shows a
+// code from their app, and hands out the recovery codes. They are shown this
+// once only.
+func ConfirmTotpHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+
+		var request = ConfirmTotpRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		credential, repoErr := repositories.GetTotpCredential(r.Context(), userId.(string))
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		if credential.Enabled() {
+			problems.WriteError(w, r, models.ErrTotpAlreadyEnabled)
+
+			return
+		}
+
+		step, ok, err := totpCodes.Verify(credential.Secret, request.Code)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		if !ok {
+			problems.WriteError(w, r, invalidCode("code"))
+
+			return
+		}
+
+		codes, records, err := newRecoveryCodes()
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		err = repositories.ConfirmTotpCredential(r.Context(), credential.UserId, step, time.Now().UTC(), records)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ConfirmTotpResponse{
+			RecoveryCodes: codes,
+		})
+	}
+}
+
+// DisableTotpHandler turns two-factor authentication off. It takes a code,
+// so a stolen access token alone cannot turn it off.
+func DisableTotpHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+
+		var request = SecondFactorRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		ok, err := verifySecondFactor(r.Context(), userId.(string), request.Code, request.RecoveryCode)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		if !ok {
+			problems.WriteError(w, r, invalidCode(secondFactorField(request.Code)))
+
+			return
+		}
+
+		if err = repositories.DeleteTotpCredential(r.Context(), userId.(string)); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// MfaSignInHandler is the second step of signing in with two-factor
+// authentication: it trades the mfa_token of the first step and a code for
+// tokens. Wrong codes count as failed sign ins, see loginThrottles.
+func MfaSignInHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request = MfaSignInRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		challenge, repoErr := repositories.AttemptMfaChallenge(r.Context(), hashToken(request.MfaToken), time.Now().UTC())
+
+		if errors.Is(repoErr, repositories.ErrNotFound) {
+			problems.Write(w, r, http.StatusUnauthorized, "invalid sign in challenge")
+
+			return
+		}
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		user, repoErr := repositories.ReadUser(r.Context(), challenge.UserId)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		throttles := loginThrottles(r, user.Email)
+
+		if !checkLoginThrottles(w, r, throttles) {
+			return
+		}
+
+		ok, err := verifySecondFactor(r.Context(), challenge.UserId, request.Code, request.RecoveryCode)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		if !ok {
+			if err = recordLoginFailure(r, throttles); err != nil {
+				problems.WriteError(w, r, err)
+
+				return
+			}
+
+			problems.Write(w, r, http.StatusUnauthorized, "invalid code")
+
+			return
+		}
+
+		if err = repositories.CompleteMfaChallenge(r.Context(), challenge.Id, time.Now().UTC()); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		if err = repositories.ClearLoginThrottle(r.Context(), models.EmailLoginKey(user.Email), nil); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		tokens, tokenErr := issueTokens(r, s, user)
+
+		if tokenErr != nil {
+			problems.WriteError(w, r, tokenErr)
+
+			return
+		}
+
+		writeTokens(w, tokens)
+	}
+}
+
+// startMfaChallenge checks whether user has two-factor authentication
+// enabled and, if so, answers the sign in with a challenge. It reports
+// whether it wrote the response.
+func startMfaChallenge(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	credential, repoErr := repositories.GetTotpCredential(r.Context(), user.Id)
+
+	if errors.Is(repoErr, repositories.ErrNotFound) || (repoErr == nil && !credential.Enabled()) {
+		return false
+	}
+
+	if repoErr != nil {
+		problems.WriteError(w, r, repoErr)
+
+		return true
+	}
+
+	id, err := ksuid.NewRandom()
+
+	if err != nil {
+		problems.WriteError(w, r, err)
+
+		return true
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+
+	if err != nil {
+		problems.WriteError(w, r, err)
+
+		return true
+	}
+
+	now := time.Now().UTC()
+
+	err = repositories.CreateMfaChallenge(r.Context(), &models.MfaChallenge{
+		Id:        id.String(),
+		UserId:    user.Id,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(mfaChallengeLifetime),
+	})
+
+	if err != nil {
+		problems.WriteError(w, r, err)
+
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MfaChallengeResponse{
+		MfaRequired: true,
+		MfaToken:    token,
+		ExpiresIn:   int64(mfaChallengeLifetime / time.Second),
+	})
+
+	return true
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code when code is
+// empty, for the enabled credential of userId. Accepted codes are used up.
+func verifySecondFactor(ctx context.Context, userId string, code string, recoveryCode string) (bool, error) {
+	if code == "" {
+		err := repositories.UseRecoveryCode(ctx, userId, hashToken(normalizeRecoveryCode(recoveryCode)), time.Now().UTC())
+
+		if errors.Is(err, repositories.ErrNotFound) {
+			return false, nil
+		}
+
+		return err == nil, err
+	}
+
+	credential, repoErr := repositories.GetTotpCredential(ctx, userId)
+
+	if errors.Is(repoErr, repositories.ErrNotFound) || (repoErr == nil && !credential.Enabled()) {
+		return false, nil
+	}
+
+	if repoErr != nil {
+		return false, repoErr
+	}
+
+	step, ok, err := totpCodes.Verify(credential.Secret, code)
+
+	if err != nil || !ok {
+		return false, err
+	}
+
+	err = repositories.UseTotpCode(ctx, userId, step)
+
+	if errors.Is(err, models.ErrTotpCodeReused) || errors.Is(err, repositories.ErrNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// newRecoveryCodes returns recovery codes to show the user and the records
+// to store for them.
+func newRecoveryCodes() ([]string, []*models.RecoveryCode, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]*models.RecoveryCode, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		id, err := ksuid.NewRandom()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		secret := make([]byte, recoveryCodeBytes)
+
+		if _, err = rand.Read(secret); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(secret))
+
+		codes = append(codes, code[0:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:16])
+		records = append(records, &models.RecoveryCode{
+			Id:       id.String(),
+			CodeHash: hashToken(code),
+		})
+	}
+
+	return codes, records, nil
+}
+
+// normalizeRecoveryCode undoes the grouping and casing recovery codes are
+// shown with, which users may or may not type.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func secondFactorField(code string) string {
+	if code == "" {
+		return "recovery_code"
+	}
+
+	return "code"
+}
+
+func invalidCode(field string) error {
+	return &validation.Error{Fields: []validation.FieldError{{Field: field, Message: "is not a valid code"}}}
+}
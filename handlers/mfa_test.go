@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pipeline1987/SVB/database/memory"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+func TestMfaSignIn(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	now := time.Unix(1700000000, 0)
+	totpCodes.Now = func() time.Time { return now }
+
+	defer func() { totpCodes.Now = time.Now }()
+
+	s := &testServer{}
+	credentials := `{"email":"ada@example.com","password":"correct horse"}`
+
+	var signUp SignUpResponse
+
+	if status := call(t, SignUpHandler(s), "", credentials, &signUp); status != http.StatusOK {
+		t.Fatalf("sign up: status %d", status)
+	}
+
+	var enrollment EnrollTotpResponse
+
+	if status := call(t, EnrollTotpHandler(s), signUp.Id, "", &enrollment); status != http.StatusCreated {
+		t.Fatalf("enroll: status %d", status)
+	}
+
+	code := func() string {
+		code, err := totpCodes.Code(enrollment.Secret, now)
+
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+
+		return code
+	}
+
+	var confirmation ConfirmTotpResponse
+
+	status := call(t, ConfirmTotpHandler(s), signUp.Id, `{"code":"`+code()+`"}`, &confirmation)
+
+	if status != http.StatusOK || len(confirmation.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("confirm: status %d with %d recovery codes", status, len(confirmation.RecoveryCodes))
+	}
+
+	challenge := func() string {
+		var response MfaChallengeResponse
+
+		if status := call(t, SignInHandler(s), "", credentials, &response); status != http.StatusOK || !response.MfaRequired {
+			t.Fatalf("sign in: status %d, %+v, want an MFA challenge", status, response)
+		}
+
+		return response.MfaToken
+	}
+
+	mfaToken := challenge()
+
+	// The code that confirmed the enrollment is used up.
+	if status = call(t, MfaSignInHandler(s), "", `{"mfa_token":"`+mfaToken+`","code":"`+code()+`"}`, nil); status != http.StatusUnauthorized {
+		t.Errorf("replayed code: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	now = now.Add(30 * time.Second)
+
+	var tokens SignInResponse
+
+	if status = call(t, MfaSignInHandler(s), "", `{"mfa_token":"`+mfaToken+`","code":"`+code()+`"}`, &tokens); status != http.StatusOK {
+		t.Fatalf("sign in with the next code: status %d", status)
+	}
+
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("sign in with a code = %+v, want tokens", tokens)
+	}
+
+	now = now.Add(30 * time.Second)
+
+	if status = call(t, MfaSignInHandler(s), "", `{"mfa_token":"`+mfaToken+`","code":"`+code()+`"}`, nil); status != http.StatusUnauthorized {
+		t.Errorf("completed challenge: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	recovery := strings.ToUpper(confirmation.RecoveryCodes[0])
+	body := `{"mfa_token":"` + challenge() + `","recovery_code":"` + recovery + `"}`
+
+	if status = call(t, MfaSignInHandler(s), "", body, &tokens); status != http.StatusOK {
+		t.Errorf("sign in with a recovery code: status %d", status)
+	}
+
+	body = `{"mfa_token":"` + challenge() + `","recovery_code":"` + recovery + `"}`
+
+	if status = call(t, MfaSignInHandler(s), "", body, nil); status != http.StatusUnauthorized {
+		t.Errorf("used recovery code: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	mfaToken = challenge()
+
+	for i := 0; i < models.MaxMfaChallengeAttempts; i++ {
+		call(t, MfaSignInHandler(s), "", `{"mfa_token":"`+mfaToken+`","code":"000000"}`, nil)
+	}
+
+	if status = call(t, MfaSignInHandler(s), "", `{"mfa_token":"`+mfaToken+`","code":"`+code()+`"}`, nil); status != http.StatusUnauthorized {
+		t.Errorf("locked challenge: status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	// The wrong codes count against the account like wrong passwords, and
+	// the right password doesn't forgive them.
+	if status = call(t, SignInHandler(s), "", credentials, nil); status != http.StatusTooManyRequests {
+		t.Errorf("sign in after wrong codes: status %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
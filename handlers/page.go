@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/pipeline1987/SVB/models"
+)
+
+// PageResponse is the envelope of every paginated list. The cursors are
+// opaque and go back in the cursor query parameter.
+type PageResponse struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+}
+
+// pageRequestFromQuery reads the limit and cursor query parameters.
+func pageRequestFromQuery(r *http.Request) (models.PageRequest, error) {
+	var limit int
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+
+		if err != nil {
+			return models.PageRequest{}, models.ErrInvalidPageLimit
+		}
+
+		limit = parsed
+	}
+
+	return models.NewPageRequest(r.URL.Query().Get("cursor"), limit)
+}
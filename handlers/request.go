@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/validation"
+)
+
+// Limits of the free text fields of requests.
+const (
+	maxEmailLength       = 254
+	maxNameLength        = 100
+	maxReasonLength      = 255
+	maxDescriptionLength = 255
+	maxUserAgentLength   = 255
+	minPasswordLength    = 8
+	// maxPasswordBytes bounds passwords. When passwords are hashed with
+	// bcrypt, those over the 72 bytes it looks at are refused as they are
+	// hashed.
+	maxPasswordBytes = 1024
+)
+
+// maxRequestBytes bounds every JSON request body. The largest request SVB
+// takes is a sign up, well under a kilobyte.
+const maxRequestBytes = 64 << 10
+
+// validatable is a request that checks its own fields once decoded.
+type validatable interface {
+	Validate() error
+}
+
+// checkAmount checks that an amount was given and moves money.
+func checkAmount(v *validation.Validator, amount models.Money, field string) {
+	v.Required(amount.Currency, field)
+	v.Check(amount.IsPositive(), field, "must be positive")
+}
+
+// checkPassword checks that a new password is long enough, and not so long
+// that hashing it is a burden.
+func checkPassword(v *validation.Validator, password string, field string) {
+	v.MinLength(password, minPasswordLength, field)
+	v.Check(len(password) <= maxPasswordBytes, field, "must be at most "+strconv.Itoa(maxPasswordBytes)+" bytes long")
+}
+
+// decodeRequest reads the JSON body of r into request and validates it. It
+// rejects oversized bodies, unknown fields and trailing data. When it returns
+// false the problem has already been written to w.
+func decodeRequest(w http.ResponseWriter, r *http.Request, request validatable) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(request)
+
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("request body must hold a single JSON object")
+	}
+
+	var tooLarge *http.MaxBytesError
+
+	if errors.As(err, &tooLarge) {
+		problems.Write(w, r, http.StatusRequestEntityTooLarge, "request body is too large")
+
+		return false
+	}
+
+	// Values such as amounts check themselves as they are decoded, and are
+	// reported like any other invalid field.
+	if err != nil && problems.Status(err) != http.StatusInternalServerError {
+		problems.WriteError(w, r, err)
+
+		return false
+	}
+
+	if err != nil {
+		problems.Write(w, r, http.StatusBadRequest, err.Error())
+
+		return false
+	}
+
+	if err = request.Validate(); err != nil {
+		problems.WriteError(w, r, err)
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"valid", `{"email":"ada@example.com","password":"correct horse"}`, http.StatusOK},
+		{"malformed", `{"email":`, http.StatusBadRequest},
+		{"unknown field", `{"email":"ada@example.com","password":"correct horse","admin":true}`, http.StatusBadRequest},
+		{"trailing data", `{"email":"ada@example.com","password":"correct horse"} {}`, http.StatusBadRequest},
+		{"oversized", `{"email":"ada@example.com","password":"` + strings.Repeat("x", maxRequestBytes) + `"}`, http.StatusRequestEntityTooLarge},
+		{"invalid fields", `{"email":"ada","password":"short"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/users/sign-up", strings.NewReader(tt.body))
+
+		var request SignUpRequest
+
+		if decodeRequest(w, r, &request) {
+			w.WriteHeader(http.StatusOK)
+		}
+
+		if w.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
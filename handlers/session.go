@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+)
+
+// SessionResponse describes a signed in device. Current marks the session
+// the request was made from.
+type SessionResponse struct {
+	Id         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IpAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+func GetSessionsHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := r.Context().Value(middlewares.ContextClaims).(*server.AppClaims)
+
+		sessions, repoErr := repositories.GetSessionsByUserId(r.Context(), claims.UserId)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		var response = make([]SessionResponse, 0, len(sessions))
+
+		for _, session := range sessions {
+			response = append(response, SessionResponse{
+				Id:         session.Id,
+				UserAgent:  session.UserAgent,
+				IpAddress:  session.IpAddress,
+				CreatedAt:  session.CreatedAt,
+				LastSeenAt: session.LastSeenAt,
+				Current:    session.Id == claims.SessionId,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// DeleteSessionHandler signs a device out. Its access tokens stop working
+// right away and its refresh token can no longer be used.
+func DeleteSessionHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+		params := mux.Vars(r)
+
+		if err := repositories.RevokeSession(r.Context(), params["id"], userId.(string), time.Now().UTC()); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	tokenType = "Bearer"
+	// opaqueTokenBytes is how much randomness refresh tokens and sign in
+	// challenges carry.
+	opaqueTokenBytes = 32
+)
+
+// tokenPair is what signing in and refreshing hand out: a short-lived access
+// token and the opaque refresh token that gets the next one.
+type tokenPair struct {
+	accessToken  string
+	refreshToken string
+	expiresIn    time.Duration
+}
+
+// hashToken is how opaque tokens are stored and looked up. The tokens are
+// random enough that a plain SHA-256 cannot be reversed.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// newOpaqueToken returns a random token for a client to hold and the hash to
+// store for it.
+func newOpaqueToken() (string, string, error) {
+	secret := make([]byte, opaqueTokenBytes)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	return token, hashToken(token), nil
+}
+
+// newSignedToken returns a random token signed for purpose and the hash to
+// store for it. The signature lets a forged token, or one issued for another
+// purpose, be turned away before it is looked up.
+func newSignedToken(s server.Server, purpose models.UserTokenPurpose) (string, string, error) {
+	token, tokenHash, err := newOpaqueToken()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return token + "." + tokenSignature(s, purpose, token), tokenHash, nil
+}
+
+// openSignedToken checks the signature of a token made by newSignedToken and
+// returns the hash it is stored under.
+func openSignedToken(s server.Server, purpose models.UserTokenPurpose, signed string) (string, bool) {
+	token, signature, found := strings.Cut(signed, ".")
+
+	if !found || !hmac.Equal([]byte(signature), []byte(tokenSignature(s, purpose, token))) {
+		return "", false
+	}
+
+	return hashToken(token), true
+}
+
+func tokenSignature(s server.Server, purpose models.UserTokenPurpose, token string) string {
+	mac := hmac.New(sha256.New, []byte(s.Config().JWT_SECRET))
+	mac.Write([]byte(string(purpose) + ":" + token))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newRefreshToken makes a refresh token and the record to store for it. The
+// record has no family or user yet.
+func newRefreshToken(s server.Server, now time.Time) (string, *models.RefreshToken, error) {
+	hours, err := strconv.Atoi(s.Config().SIGN_EXPIRE_HOURS)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, err := ksuid.NewRandom()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &models.RefreshToken{
+		Id:        id.String(),
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(hours) * time.Hour),
+	}, nil
+}
+
+// signAccessToken signs an access token for user in the session familyId
+// that expires after ACCESS_EXPIRE_MINUTES, with the active key of the ring.
+func signAccessToken(
+	ctx context.Context,
+	s server.Server,
+	user *models.User,
+	familyId string,
+	now time.Time,
+) (string, time.Duration, error) {
+	minutes, err := strconv.Atoi(s.Config().ACCESS_EXPIRE_MINUTES)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	id, err := ksuid.NewRandom()
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	lifetime := time.Duration(minutes) * time.Minute
+
+	claims := server.AppClaims{
+		UserId:       user.Id,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
+		SessionId:    familyId,
+		StandardClaims: jwt.StandardClaims{
+			Id:        id.String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(lifetime).Unix(),
+		},
+	}
+
+	token, err := s.Keys().Sign(ctx, claims)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	return token, lifetime, nil
+}
+
+// issueTokens starts a session for user on the device making r, as signing in
+// does, and returns the first pair of its refresh token family.
+func issueTokens(r *http.Request, s server.Server, user *models.User) (*tokenPair, error) {
+	now := time.Now().UTC()
+
+	refreshToken, record, err := newRefreshToken(s, now)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessionId, err := ksuid.NewRandom()
+
+	if err != nil {
+		return nil, err
+	}
+
+	session := models.Session{
+		Id:         sessionId.String(),
+		UserId:     user.Id,
+		UserAgent:  truncate(r.UserAgent(), maxUserAgentLength),
+		IpAddress:  clientIp(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+
+	if err = repositories.CreateSession(r.Context(), &session); err != nil {
+		return nil, err
+	}
+
+	record.FamilyId = session.Id
+	record.UserId = user.Id
+
+	if err = repositories.CreateRefreshToken(r.Context(), record); err != nil {
+		return nil, err
+	}
+
+	return signPair(r.Context(), s, user, session.Id, refreshToken, now)
+}
+
+// clientIp is the address the request came from. X-Forwarded-For is not
+// trusted, as SVB does not know which proxies are in front of it.
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// truncate cuts value down to at most max characters.
+func truncate(value string, max int) string {
+	if utf8.RuneCountInString(value) <= max {
+		return value
+	}
+
+	return string([]rune(value)[:max])
+}
+
+// rotateTokens trades refreshToken for the next pair of its family.
+func rotateTokens(ctx context.Context, s server.Server, refreshToken string) (*tokenPair, error) {
+	now := time.Now().UTC()
+
+	nextToken, next, err := newRefreshToken(s, now)
+
+	if err != nil {
+		return nil, err
+	}
+
+	next, err = repositories.RotateRefreshToken(ctx, hashToken(refreshToken), next)
+
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := repositories.ReadUser(ctx, next.UserId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return signPair(ctx, s, user, next.FamilyId, nextToken, now)
+}
+
+func signPair(
+	ctx context.Context,
+	s server.Server,
+	user *models.User,
+	familyId string,
+	refreshToken string,
+	now time.Time,
+) (*tokenPair, error) {
+	accessToken, lifetime, err := signAccessToken(ctx, s, user, familyId, now)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenPair{
+		accessToken:  accessToken,
+		refreshToken: refreshToken,
+		expiresIn:    lifetime,
+	}, nil
+}
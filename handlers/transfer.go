@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pipeline1987/SVB/middlewares"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/validation"
+)
+
+type CreateTransferRequest struct {
+	FromBankAccountId string       `json:"from_bank_account_id"`
+	ToBankAccountId   string       `json:"to_bank_account_id"`
+	Amount            models.Money `json:"amount"`
+	Description       string       `json:"description"`
+}
+
+func (r *CreateTransferRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.FromBankAccountId, "from_bank_account_id")
+	v.Required(r.ToBankAccountId, "to_bank_account_id")
+	v.Check(r.ToBankAccountId != r.FromBankAccountId, "to_bank_account_id", "must differ from from_bank_account_id")
+	checkAmount(&v, r.Amount, "amount")
+	v.MaxLength(r.Description, maxDescriptionLength, "description")
+
+	return v.Err()
+}
+
+type CreateTransferResponse struct {
+	Id                string       `json:"id"`
+	FromBankAccountId string       `json:"from_bank_account_id"`
+	ToBankAccountId   string       `json:"to_bank_account_id"`
+	Amount            models.Money `json:"amount"`
+	Description       string       `json:"description"`
+	CreatedAt         time.Time    `json:"created_at"`
+}
+
+func CreateTransferHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+
+		var request = CreateTransferRequest{}
+
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		if request.FromBankAccountId == models.ExternalBankAccountId || request.ToBankAccountId == models.ExternalBankAccountId {
+			problems.Write(w, r, http.StatusNotFound, "bank account not found")
+
+			return
+		}
+
+		transaction, err := newTransaction(
+			models.TransferTransaction,
+			request.Description,
+			&models.LedgerEntry{
+				BankAccountId: request.FromBankAccountId,
+				Direction:     models.DebitEntry,
+				Amount:        request.Amount,
+			},
+			&models.LedgerEntry{
+				BankAccountId: request.ToBankAccountId,
+				Direction:     models.CreditEntry,
+				Amount:        request.Amount,
+			},
+		)
+
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		savedTransaction, repoErr := repositories.CreateTransactionForUser(r.Context(), userId.(string), transaction)
+
+		if repoErr != nil {
+			problems.WriteError(w, r, repoErr)
+
+			return
+		}
+
+		var message = models.WebSocketMessage{
+			Type:    "transfer_created",
+			Payload: savedTransaction.Id,
+		}
+
+		s.Hub().Broadcast(message, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(CreateTransferResponse{
+			Id:                savedTransaction.Id,
+			FromBankAccountId: request.FromBankAccountId,
+			ToBankAccountId:   request.ToBankAccountId,
+			Amount:            request.Amount,
+			Description:       savedTransaction.Description,
+			CreatedAt:         savedTransaction.CreatedAt,
+		})
+	}
+}
@@ -2,17 +2,19 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"strconv"
 	"time"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/pipeline1987/SVB/middlewares"
 	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/passwords"
+	"github.com/pipeline1987/SVB/problems"
 	"github.com/pipeline1987/SVB/repositories"
 	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/validation"
 	"github.com/segmentio/ksuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type SignUpRequest struct {
@@ -26,51 +28,105 @@ type SignInRequest struct {
 	Password string `json:"password"`
 }
 
+func (r *SignUpRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.Email, "email")
+	v.MaxLength(r.Email, maxEmailLength, "email")
+	v.Email(r.Email, "email")
+	v.MaxLength(r.FullName, maxNameLength, "full_name")
+	checkPassword(&v, r.Password, "password")
+
+	return v.Err()
+}
+
+func (r *SignInRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.Email, "email")
+	v.Required(r.Password, "password")
+
+	return v.Err()
+}
+
 type SignUpResponse struct {
 	Id string `json:"id"`
 }
 
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (r *RefreshTokenRequest) Validate() error {
+	var v validation.Validator
+
+	v.Required(r.RefreshToken, "refresh_token")
+
+	return v.Err()
+}
+
+// SignInResponse is also the response of a refresh. ExpiresIn is the lifetime
+// of the access token in seconds.
 type SignInResponse struct {
-	AccessToken string `json:"access_token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// rehashPassword replaces the stored hash of user with a hash of password
+// made the way new ones are. The sign in goes on should it fail, the hash
+// is replaced at a later one.
+func rehashPassword(r *http.Request, s server.Server, user *models.User, password string) {
+	hashed, err := s.Passwords().Hash(password)
+
+	if err == nil {
+		err = repositories.RehashPassword(r.Context(), user.Id, user.Password, hashed)
+	}
+
+	if err != nil && !errors.Is(err, repositories.ErrConflict) {
+		log.Println("rehashing the password of", user.Id, err)
+	}
+}
+
+func writeTokens(w http.ResponseWriter, tokens *tokenPair) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SignInResponse{
+		AccessToken:  tokens.accessToken,
+		RefreshToken: tokens.refreshToken,
+		TokenType:    tokenType,
+		ExpiresIn:    int64(tokens.expiresIn / time.Second),
+	})
 }
 
 type GetUserResponse struct {
-	Id       string `json:"id"`
-	FullName string `json:"full_name"`
-	Email    string `json:"email"`
+	Id            string      `json:"id"`
+	FullName      string      `json:"full_name"`
+	Email         string      `json:"email"`
+	EmailVerified bool        `json:"email_verified"`
+	Role          models.Role `json:"role"`
 }
 
 func SignUpHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request = SignUpRequest{}
-		err := json.NewDecoder(r.Body).Decode(&request)
-
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
 
+		if !decodeRequest(w, r, &request) {
 			return
 		}
 
 		id, err := ksuid.NewRandom()
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problems.WriteError(w, r, err)
 
 			return
 		}
 
-		defaultHash, _envErr := strconv.Atoi(s.Config().HASH_COST)
-
-		if _envErr != nil {
-			http.Error(w, _envErr.Error(), http.StatusInternalServerError)
-
-			return
-		}
-
-		hashedPassword, cryptErr := bcrypt.GenerateFromPassword([]byte(request.Password), defaultHash)
+		hashedPassword, cryptErr := s.Passwords().Hash(request.Password)
 
 		if cryptErr != nil {
-			http.Error(w, cryptErr.Error(), http.StatusInternalServerError)
+			problems.WriteError(w, r, cryptErr)
 
 			return
 		}
@@ -79,17 +135,24 @@ func SignUpHandler(s server.Server) http.HandlerFunc {
 			Id:       id.String(),
 			Email:    request.Email,
 			FullName: request.FullName,
-			Password: string(hashedPassword),
+			Password: hashedPassword,
+			Role:     models.RoleCustomer,
 		}
 
 		savedUser, err := repositories.CreateUser(r.Context(), &user)
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problems.WriteError(w, r, err)
 
 			return
 		}
 
+		// The account exists either way; a user whose email did not go out
+		// can ask for another one.
+		if mailErr := sendVerificationEmail(r, s, &user); mailErr != nil {
+			log.Printf("sending the verification email to user %s: %v", user.Id, mailErr)
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(SignUpResponse{
 			Id: savedUser.Id,
@@ -97,66 +160,154 @@ func SignUpHandler(s server.Server) http.HandlerFunc {
 	}
 }
 
+// SignInHandler checks the password of a user. Failed attempts slow down
+// further ones, see loginThrottles, and a correct password forgives the
+// failures against the account.
 func SignInHandler(s server.Server) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request = SignInRequest{}
-		err := json.NewDecoder(r.Body).Decode(&request)
 
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		throttles := loginThrottles(r, request.Email)
 
+		if !checkLoginThrottles(w, r, throttles) {
 			return
 		}
 
 		user, repoErr := repositories.ReadUserByEmail(r.Context(), request.Email)
 
+		if errors.Is(repoErr, repositories.ErrNotFound) {
+			s.Passwords().VerifyDummy(request.Password)
+			failSignIn(w, r, throttles)
+
+			return
+		}
+
 		if repoErr != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problems.WriteError(w, r, repoErr)
 
 			return
 		}
 
-		if user == nil {
-			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		rehash, verifyErr := s.Passwords().Verify(request.Password, user.Password)
+
+		if errors.Is(verifyErr, passwords.ErrMismatch) {
+			failSignIn(w, r, throttles)
 
 			return
 		}
 
-		if decryptErr := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)); decryptErr != nil {
-			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		if verifyErr != nil {
+			problems.WriteError(w, r, verifyErr)
 
 			return
 		}
 
-		defaultSignExpireDays, _envErr := strconv.Atoi(s.Config().SIGN_EXPIRE_HOURS)
+		if rehash {
+			rehashPassword(r, s, user, request.Password)
+		}
 
-		if _envErr != nil {
-			http.Error(w, _envErr.Error(), http.StatusInternalServerError)
+		// With two-factor authentication the failures are only forgiven
+		// once the code is right too, so that the password can't be used
+		// to reset the throttle on guessing codes.
+		if startMfaChallenge(w, r, user) {
+			return
+		}
+
+		if clearErr := repositories.ClearLoginThrottle(r.Context(), models.EmailLoginKey(request.Email), nil); clearErr != nil {
+			problems.WriteError(w, r, clearErr)
 
 			return
 		}
 
-		claims := server.AppClaims{
-			UserId: user.Id,
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: time.Now().Add(time.Duration(defaultSignExpireDays) * time.Hour * 24).Unix(),
-			},
+		tokens, tokenErr := issueTokens(r, s, user)
+
+		if tokenErr != nil {
+			problems.WriteError(w, r, tokenErr)
+
+			return
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		writeTokens(w, tokens)
+	}
+}
 
-		tokenString, tokenErr := token.SignedString([]byte(s.Config().JWT_SECRET))
+// RefreshTokenHandler trades a refresh token for a new access token and the
+// next refresh token. The old one can't be used again: presenting it twice
+// revokes every token of its family, so a leaked token is cut off as soon as
+// either holder refreshes.
+func RefreshTokenHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request = RefreshTokenRequest{}
 
-		if tokenErr != nil {
-			http.Error(w, tokenErr.Error(), http.StatusInternalServerError)
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		tokens, err := rotateTokens(r.Context(), s, request.RefreshToken)
+
+		if errors.Is(err, repositories.ErrNotFound) {
+			problems.Write(w, r, http.StatusUnauthorized, "invalid refresh token")
 
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(SignInResponse{
-			AccessToken: tokenString,
+		if err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		writeTokens(w, tokens)
+	}
+}
+
+// SignOutHandler revokes the access token of the request and ends the
+// session it was issued for.
+func SignOutHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := r.Context().Value(middlewares.ContextClaims).(*server.AppClaims)
+		now := time.Now().UTC()
+
+		revokeErr := repositories.RevokeAccessToken(r.Context(), &models.RevokedAccessToken{
+			Id:        claims.Id,
+			UserId:    claims.UserId,
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+			RevokedAt: now,
 		})
+
+		if revokeErr != nil {
+			problems.WriteError(w, r, revokeErr)
+
+			return
+		}
+
+		if err := repositories.RevokeSession(r.Context(), claims.SessionId, claims.UserId, now); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SignOutAllHandler ends every session of the user, including the one making
+// the request.
+func SignOutAllHandler(s server.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId := r.Context().Value(middlewares.ContextUserId)
+
+		if err := repositories.RevokeAllTokens(r.Context(), userId.(string), time.Now().UTC()); err != nil {
+			problems.WriteError(w, r, err)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
@@ -167,14 +318,18 @@ func GetUserHandler(s server.Server) http.HandlerFunc {
 		user, repoErr := repositories.ReadUser(r.Context(), userId.(string))
 
 		if repoErr != nil {
-			http.Error(w, repoErr.Error(), http.StatusInternalServerError)
+			problems.WriteError(w, r, repoErr)
+
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(GetUserResponse{
-			Id:       user.Id,
-			Email:    user.Email,
-			FullName: user.FullName,
+			Id:            user.Id,
+			Email:         user.Email,
+			FullName:      user.FullName,
+			EmailVerified: user.EmailVerifiedAt != nil,
+			Role:          user.Role,
 		})
 	}
 }
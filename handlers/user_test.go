@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pipeline1987/SVB/database/memory"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSignInRehashesPasswords(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	ctx := context.Background()
+	s := &testServer{}
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+
+	user := &models.User{Id: "ada", Email: "ada@example.com", Password: string(legacy), Role: models.RoleCustomer}
+
+	if _, err = repositories.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	password := func() string {
+		t.Helper()
+
+		read, err := repositories.ReadUserByEmail(ctx, user.Email)
+
+		if err != nil {
+			t.Fatalf("ReadUserByEmail: %v", err)
+		}
+
+		return read.Password
+	}
+
+	body := `{"email":"ada@example.com","password":"correct horse"}`
+
+	if status := call(t, SignInHandler(s), "", `{"email":"ada@example.com","password":"wrong horse"}`, nil); status != http.StatusUnauthorized {
+		t.Fatalf("sign in with a wrong password: status %d", status)
+	}
+
+	if password() != string(legacy) {
+		t.Errorf("a failed sign in replaced the bcrypt hash")
+	}
+
+	if status := call(t, SignInHandler(s), "", body, nil); status != http.StatusOK {
+		t.Fatalf("sign in with a bcrypt hash: status %d", status)
+	}
+
+	upgraded := password()
+
+	if !strings.HasPrefix(upgraded, "$argon2id$") {
+		t.Fatalf("password hash after signing in = %q, want an argon2id hash", upgraded)
+	}
+
+	if status := call(t, SignInHandler(s), "", body, nil); status != http.StatusOK {
+		t.Fatalf("sign in with the upgraded hash: status %d", status)
+	}
+
+	if password() != upgraded {
+		t.Errorf("signing in with a current hash replaced it")
+	}
+}
@@ -0,0 +1,498 @@
+// Package keyring signs access tokens with asymmetric keys that rotate on a
+// schedule. Services that verify SVB tokens only need the public halves,
+// which the ring publishes as a JSON Web Key Set, so they can't forge them.
+package keyring
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/segmentio/ksuid"
+)
+
+// The algorithms keys can be made for.
+const (
+	EdDSA = "EdDSA"
+	RS256 = "RS256"
+)
+
+const (
+	rsaKeyBits = 2048
+	// reloadEvery is how long the keys read from the repository are used
+	// before reading them again, to pick up keys other instances made.
+	reloadEvery = time.Minute
+	// reloadUnknownEvery limits how often tokens signed with a key the ring
+	// doesn't know make it read the repository again.
+	reloadUnknownEvery = 5 * time.Second
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrUnknownKey           = errors.New("token is not signed with a known key")
+	ErrSealedKey            = errors.New("signing key can't be opened with this secret")
+)
+
+var methods = map[string]jwt.SigningMethod{
+	EdDSA: jwt.SigningMethodEdDSA,
+	RS256: jwt.SigningMethodRS256,
+}
+
+// Ring holds the signing keys stored in the repository. Each key signs for
+// RotateEvery and is offered to verifiers for Overlap on either side of
+// that: before, so verifiers caching the key set learn of it before its
+// first token, and after, so its last tokens can be checked until they
+// expire. Overlap must be at least the lifetime of an access token.
+type Ring struct {
+	// Algorithm is what new keys are made for. Keys made for another one
+	// keep verifying until they expire.
+	Algorithm   string
+	RotateEvery time.Duration
+	Overlap     time.Duration
+	Now         func() time.Time
+
+	sealer   cipher.AEAD
+	mutex    sync.Mutex
+	keys     []*key
+	loadedAt time.Time
+}
+
+// JWK is the public half of a key, as RFC 7517 describes it.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type key struct {
+	*models.SigningKey
+	private interface{}
+	public  interface{}
+	method  jwt.SigningMethod
+}
+
+// New returns a ring making keys for algorithm. Private keys are stored
+// sealed with secret, so reading the repository isn't enough to forge
+// tokens.
+func New(secret string, algorithm string, rotateEvery time.Duration, overlap time.Duration) (*Ring, error) {
+	if _, ok := methods[algorithm]; !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	if rotateEvery <= 0 || overlap <= 0 {
+		return nil, errors.New("key rotation period and overlap must be positive")
+	}
+
+	sum := sha256.Sum256([]byte("svb signing keys:" + secret))
+	block, err := aes.NewCipher(sum[:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	sealer, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ring{
+		Algorithm:   algorithm,
+		RotateEvery: rotateEvery,
+		Overlap:     overlap,
+		Now:         time.Now,
+		sealer:      sealer,
+	}, nil
+}
+
+// Sign signs claims with the active key, naming it in the kid header. It
+// makes the first key, and each next one once the active key is within
+// Overlap of retiring.
+func (r *Ring) Sign(ctx context.Context, claims jwt.Claims) (string, error) {
+	active, err := r.activeKey(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(active.method, claims)
+	token.Header["kid"] = active.Id
+
+	return token.SignedString(active.private)
+}
+
+// Keyfunc verifies tokens signed by the ring. It finds the key named by the
+// kid header and turns the token away unless it is signed with the
+// algorithm of that key.
+func (r *Ring) Keyfunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+		published, err := r.publishedKey(ctx, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if token.Method.Alg() != published.method.Alg() {
+			return nil, ErrUnknownKey
+		}
+
+		return published.public, nil
+	}
+}
+
+// JWKS returns the public halves of the keys verifiers should accept now.
+func (r *Ring) JWKS(ctx context.Context) ([]JWK, error) {
+	if _, err := r.activeKey(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.Now().UTC()
+	var jwks = []JWK{}
+
+	for _, published := range r.keys {
+		if now.Before(published.ExpiresAt) {
+			jwks = append(jwks, published.jwk())
+		}
+	}
+
+	return jwks, nil
+}
+
+func (r *Ring) activeKey(ctx context.Context) (*key, error) {
+	var private interface{}
+
+	for {
+		active, missing, err := r.activeOrMissing(ctx, private)
+
+		if err != nil || !missing {
+			return active, err
+		}
+
+		// Making an RSA key takes long enough that verifiers shouldn't wait
+		// on it, so it is made without the mutex and whether it is still
+		// needed is checked again after.
+		if private, err = generate(r.Algorithm); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// activeOrMissing returns the active key, or reports that a key has to be
+// made first. It stores private as the key missing, if it is given one.
+func (r *Ring) activeOrMissing(ctx context.Context, private interface{}) (*key, bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.Now().UTC()
+
+	if now.Sub(r.loadedAt) >= reloadEvery {
+		if err := r.load(ctx, now); err != nil {
+			return nil, false, err
+		}
+	}
+
+	active, hasNext := r.pick(now)
+
+	// Another instance may have made the keys missing here since they were
+	// read, so read them again before making any.
+	if (active == nil || !hasNext) && !r.loadedAt.Equal(now) {
+		if err := r.load(ctx, now); err != nil {
+			return nil, false, err
+		}
+
+		active, hasNext = r.pick(now)
+	}
+
+	if active == nil {
+		if private == nil {
+			return nil, true, nil
+		}
+
+		if _, err := r.create(ctx, now, now, private); err != nil {
+			return nil, false, err
+		}
+
+		private = nil
+		active, hasNext = r.pick(now)
+	}
+
+	if !hasNext {
+		if private == nil {
+			return nil, true, nil
+		}
+
+		if _, err := r.create(ctx, now, active.ActiveUntil, private); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return active, false, nil
+}
+
+// pick returns the key active at now, the latest one should several be, and
+// whether the one after it is needed and there. The caller must hold the
+// mutex.
+func (r *Ring) pick(now time.Time) (*key, bool) {
+	var active *key
+
+	for _, candidate := range r.keys {
+		if candidate.Active(now) {
+			active = candidate
+		}
+	}
+
+	if active == nil {
+		return nil, false
+	}
+
+	if active.ActiveUntil.Sub(now) > r.Overlap {
+		return active, true
+	}
+
+	for _, candidate := range r.keys {
+		if !candidate.ActiveFrom.Before(active.ActiveUntil) {
+			return active, true
+		}
+	}
+
+	return active, false
+}
+
+func (r *Ring) publishedKey(ctx context.Context, id string) (*key, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.Now().UTC()
+
+	if now.Sub(r.loadedAt) >= reloadEvery {
+		if err := r.load(ctx, now); err != nil {
+			return nil, err
+		}
+	}
+
+	published := r.find(id, now)
+
+	if published == nil && now.Sub(r.loadedAt) >= reloadUnknownEvery {
+		if err := r.load(ctx, now); err != nil {
+			return nil, err
+		}
+
+		published = r.find(id, now)
+	}
+
+	if published == nil {
+		return nil, ErrUnknownKey
+	}
+
+	return published, nil
+}
+
+// find returns the key named id unless it has expired. The caller must hold
+// the mutex.
+func (r *Ring) find(id string, now time.Time) *key {
+	for _, candidate := range r.keys {
+		if candidate.Id == id && now.Before(candidate.ExpiresAt) {
+			return candidate
+		}
+	}
+
+	return nil
+}
+
+// load reads the keys that have not expired. Keys that don't open, such as
+// ones sealed with another secret, are left out rather than failing the
+// ring, so it signs with the newest key it can. The caller must hold the
+// mutex.
+func (r *Ring) load(ctx context.Context, now time.Time) error {
+	records, err := repositories.GetSigningKeys(ctx, now)
+
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*key, 0, len(records))
+
+	for _, record := range records {
+		opened, err := r.open(record)
+
+		if err != nil {
+			log.Printf("skipping signing key %s: %v", record.Id, err)
+
+			continue
+		}
+
+		keys = append(keys, opened)
+	}
+
+	r.keys = keys
+	r.loadedAt = now
+
+	return nil
+}
+
+// create stores private as a key that becomes active at from. The caller
+// must hold the mutex.
+func (r *Ring) create(ctx context.Context, now time.Time, from time.Time, private interface{}) (*key, error) {
+	id, err := ksuid.NewRandom()
+
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, r.sealer.NonceSize())
+
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	record := &models.SigningKey{
+		Id:          id.String(),
+		Algorithm:   r.Algorithm,
+		PrivateKey:  base64.StdEncoding.EncodeToString(r.sealer.Seal(nonce, nonce, der, []byte(id.String()))),
+		CreatedAt:   now,
+		ActiveFrom:  from,
+		ActiveUntil: from.Add(r.RotateEvery),
+		ExpiresAt:   from.Add(r.RotateEvery + r.Overlap),
+	}
+
+	if err = repositories.CreateSigningKey(ctx, record); err != nil {
+		return nil, err
+	}
+
+	created, err := newKey(record, private)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.keys = append(r.keys, created)
+
+	sort.Slice(r.keys, func(i, j int) bool {
+		if !r.keys[i].ActiveFrom.Equal(r.keys[j].ActiveFrom) {
+			return r.keys[i].ActiveFrom.Before(r.keys[j].ActiveFrom)
+		}
+
+		return r.keys[i].Id < r.keys[j].Id
+	})
+
+	return created, nil
+}
+
+// open unseals the private key of record. The key id is sealed with it, so
+// a key moved to another row doesn't open.
+func (r *Ring) open(record *models.SigningKey) (*key, error) {
+	sealed, err := base64.StdEncoding.DecodeString(record.PrivateKey)
+
+	if err != nil || len(sealed) < r.sealer.NonceSize() {
+		return nil, ErrSealedKey
+	}
+
+	nonce, ciphertext := sealed[:r.sealer.NonceSize()], sealed[r.sealer.NonceSize():]
+	der, err := r.sealer.Open(nil, nonce, ciphertext, []byte(record.Id))
+
+	if err != nil {
+		return nil, ErrSealedKey
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(der)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(record, private)
+}
+
+func newKey(record *models.SigningKey, private interface{}) (*key, error) {
+	method, ok := methods[record.Algorithm]
+
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	var public interface{}
+
+	switch private := private.(type) {
+	case ed25519.PrivateKey:
+		public = private.Public()
+	case *rsa.PrivateKey:
+		public = &private.PublicKey
+	}
+
+	if public == nil || !matches(record.Algorithm, public) {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	return &key{SigningKey: record, private: private, public: public, method: method}, nil
+}
+
+func matches(algorithm string, public interface{}) bool {
+	switch public.(type) {
+	case ed25519.PublicKey:
+		return algorithm == EdDSA
+	case *rsa.PublicKey:
+		return algorithm == RS256
+	default:
+		return false
+	}
+}
+
+func generate(algorithm string) (interface{}, error) {
+	switch algorithm {
+	case EdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+
+		return private, err
+	case RS256:
+		return rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+func (k *key) jwk() JWK {
+	jwk := JWK{Kid: k.Id, Alg: k.method.Alg(), Use: "sig"}
+
+	switch public := k.public.(type) {
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+
+	return jwk
+}
@@ -0,0 +1,210 @@
+package keyring
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/pipeline1987/SVB/database/memory"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+func newRing(t *testing.T, algorithm string, now *time.Time) *Ring {
+	t.Helper()
+
+	ring, err := New("secret", algorithm, 7*24*time.Hour, 24*time.Hour)
+
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ring.Now = func() time.Time { return *now }
+
+	return ring
+}
+
+func sign(t *testing.T, ring *Ring) string {
+	t.Helper()
+
+	token, err := ring.Sign(context.Background(), jwt.StandardClaims{Subject: "ada"})
+
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	return token
+}
+
+func kid(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+
+	id, _ := parsed.Header["kid"].(string)
+
+	return id
+}
+
+// verify parses token with the ring and returns what its key function
+// reported, which jwt wraps without unwrapping.
+func verify(ring *Ring, token string) error {
+	_, err := jwt.Parse(token, ring.Keyfunc(context.Background()))
+
+	var validationErr *jwt.ValidationError
+
+	if errors.As(err, &validationErr) && validationErr.Inner != nil {
+		return validationErr.Inner
+	}
+
+	return err
+}
+
+func kids(t *testing.T, ring *Ring) []string {
+	t.Helper()
+
+	jwks, err := ring.JWKS(context.Background())
+
+	if err != nil {
+		t.Fatalf("JWKS: %v", err)
+	}
+
+	var ids []string
+
+	for _, jwk := range jwks {
+		ids = append(ids, jwk.Kid)
+	}
+
+	return ids
+}
+
+func TestRotation(t *testing.T) {
+	for _, algorithm := range []string{EdDSA, RS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			repositories.SetRepository(memory.NewRepository())
+
+			now := time.Unix(1700000000, 0)
+			ring := newRing(t, algorithm, &now)
+			first := sign(t, ring)
+
+			if err := verify(ring, first); err != nil {
+				t.Fatalf("verifying a fresh token: %v", err)
+			}
+
+			if ids := kids(t, ring); len(ids) != 1 {
+				t.Fatalf("JWKS before the overlap = %v, want the active key alone", ids)
+			}
+
+			// A day before the first key retires, the next one is published
+			// without signing anything yet.
+			now = now.Add(6*24*time.Hour + time.Minute)
+
+			if token := sign(t, ring); kid(t, token) != kid(t, first) {
+				t.Errorf("signing during the overlap used key %s, want the first one", kid(t, token))
+			}
+
+			if ids := kids(t, ring); len(ids) != 2 {
+				t.Fatalf("JWKS during the overlap = %v, want the active and the next key", ids)
+			}
+
+			// Another instance reading the same repository signs with the same
+			// keys and verifies tokens of this one.
+			other := newRing(t, algorithm, &now)
+
+			if err := verify(other, first); err != nil {
+				t.Errorf("verifying on another instance: %v", err)
+			}
+
+			now = now.Add(24 * time.Hour)
+			second := sign(t, ring)
+
+			if kid(t, second) == kid(t, first) {
+				t.Errorf("signing after the rotation still used the first key")
+			}
+
+			if err := verify(ring, first); err != nil {
+				t.Errorf("verifying a token of the retired key within the overlap: %v", err)
+			}
+
+			now = now.Add(24 * time.Hour)
+
+			if err := verify(ring, first); !errors.Is(err, ErrUnknownKey) {
+				t.Errorf("verifying a token of an expired key: got %v, want ErrUnknownKey", err)
+			}
+
+			if err := verify(ring, second); err != nil {
+				t.Errorf("verifying a token of the active key: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeyfuncRejectsOtherAlgorithms(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	now := time.Now()
+	ring := newRing(t, EdDSA, &now)
+	id := kid(t, sign(t, ring))
+
+	// A token signed with HS256 under the public key as secret must not pass
+	// for one signed with the key itself.
+	published, err := ring.publishedKey(context.Background(), id)
+
+	if err != nil {
+		t.Fatalf("publishedKey: %v", err)
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "mallory"})
+	forged.Header["kid"] = id
+	signed, err := forged.SignedString([]byte(published.public.(ed25519.PublicKey)))
+
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	if err = verify(ring, signed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("verifying an HS256 token: got %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestSealedKeys(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	now := time.Now()
+	ring := newRing(t, EdDSA, &now)
+	token := sign(t, ring)
+
+	other, err := New("another secret", EdDSA, time.Hour, time.Hour)
+
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// Keys sealed with another secret are skipped, so each ring signs with
+	// the newest key it can open.
+	otherToken := sign(t, other)
+
+	if kid(t, otherToken) == kid(t, token) {
+		t.Errorf("a ring with another secret signed with a key it can't open")
+	}
+
+	ring.loadedAt = time.Time{}
+
+	if got := kid(t, sign(t, ring)); got != kid(t, token) {
+		t.Errorf("Sign after another secret's key was stored: kid %s, want %s", got, kid(t, token))
+	}
+
+	if err = verify(ring, token); err != nil {
+		t.Errorf("verifying the ring's own token: %v", err)
+	}
+
+	if err = verify(ring, otherToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("verifying a token signed under another secret: got %v, want ErrUnknownKey", err)
+	}
+}
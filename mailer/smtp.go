@@ -0,0 +1,73 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SMTP delivers mail through an SMTP server. net/smtp upgrades to TLS when
+// the server offers STARTTLS and refuses to send credentials in the clear to
+// anything but localhost.
+type SMTP struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func newSMTP(parsed *url.URL, from string) *SMTP {
+	mailer := &SMTP{
+		addr: parsed.Host,
+		from: from,
+	}
+
+	if _, _, err := net.SplitHostPort(mailer.addr); err != nil {
+		mailer.addr = net.JoinHostPort(mailer.addr, "25")
+	}
+
+	if parsed.User != nil {
+		password, _ := parsed.User.Password()
+		mailer.auth = smtp.PlainAuth("", parsed.User.Username(), password, parsed.Hostname())
+	}
+
+	return mailer
+}
+
+func (m *SMTP) Send(ctx context.Context, message Message) error {
+	return smtp.SendMail(m.addr, m.auth, address(m.from), []string{message.To}, format(m.from, message, time.Now()))
+}
+
+// format renders message with the headers every mail server expects. Line
+// breaks are dropped from header values, so they cannot smuggle in headers
+// of their own.
+func format(from string, message Message, date time.Time) []byte {
+	var builder strings.Builder
+
+	header := func(name string, value string) {
+		builder.WriteString(name + ": " + strings.NewReplacer("\r", "", "\n", "").Replace(value) + "\r\n")
+	}
+
+	header("From", from)
+	header("To", message.To)
+	header("Subject", message.Subject)
+	header("Date", date.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=UTF-8")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return []byte(builder.String())
+}
+
+// address is the bare address of from, which may carry a display name as in
+// "SVB <no-reply@example.com>".
+func address(from string) string {
+	if start, end := strings.LastIndex(from, "<"), strings.LastIndex(from, ">"); start >= 0 && end > start {
+		return from[start+1 : end]
+	}
+
+	return from
+}
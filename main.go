@@ -10,6 +10,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	"github.com/pipeline1987/SVB/handlers"
+	"github.com/pipeline1987/SVB/models"
 	"github.com/pipeline1987/SVB/server"
 )
 
@@ -25,13 +26,49 @@ func main() {
 	DB_HOST := os.Getenv("DB_HOST")
 	HASH_COST := os.Getenv("HASH_COST")
 	SIGN_EXPIRE_HOURS := os.Getenv("SIGN_EXPIRE_HOURS")
+	ACCESS_EXPIRE_MINUTES := os.Getenv("ACCESS_EXPIRE_MINUTES")
+	MAILER_URL := os.Getenv("MAILER_URL")
+	MAIL_FROM := os.Getenv("MAIL_FROM")
+	JWT_ALGORITHM := os.Getenv("JWT_ALGORITHM")
+	JWT_ROTATE_HOURS := os.Getenv("JWT_ROTATE_HOURS")
+	JWT_OVERLAP_HOURS := os.Getenv("JWT_OVERLAP_HOURS")
+	PASSWORD_HASHER := os.Getenv("PASSWORD_HASHER")
+	ARGON2_MEMORY_KIB := os.Getenv("ARGON2_MEMORY_KIB")
+	ARGON2_ITERATIONS := os.Getenv("ARGON2_ITERATIONS")
+	ARGON2_PARALLELISM := os.Getenv("ARGON2_PARALLELISM")
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if migrateErr := Migrate(context.Background(), DB_HOST, os.Args[2:]); migrateErr != nil {
+			log.Fatal(migrateErr)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "users" {
+		if usersErr := Users(context.Background(), DB_HOST, os.Args[2:]); usersErr != nil {
+			log.Fatal(usersErr)
+		}
+
+		return
+	}
 
 	s, serverErr := server.NewServer(context.Background(), &server.Config{
-		PORT:              PORT,
-		JWT_SECRET:        JWT_SECRET,
-		DB_HOST:           DB_HOST,
-		HASH_COST:         HASH_COST,
-		SIGN_EXPIRE_HOURS: SIGN_EXPIRE_HOURS,
+		PORT:                  PORT,
+		JWT_SECRET:            JWT_SECRET,
+		DB_HOST:               DB_HOST,
+		HASH_COST:             HASH_COST,
+		SIGN_EXPIRE_HOURS:     SIGN_EXPIRE_HOURS,
+		ACCESS_EXPIRE_MINUTES: ACCESS_EXPIRE_MINUTES,
+		MAILER_URL:            MAILER_URL,
+		MAIL_FROM:             MAIL_FROM,
+		JWT_ALGORITHM:         JWT_ALGORITHM,
+		JWT_ROTATE_HOURS:      JWT_ROTATE_HOURS,
+		JWT_OVERLAP_HOURS:     JWT_OVERLAP_HOURS,
+		PASSWORD_HASHER:       PASSWORD_HASHER,
+		ARGON2_MEMORY_KIB:     ARGON2_MEMORY_KIB,
+		ARGON2_ITERATIONS:     ARGON2_ITERATIONS,
+		ARGON2_PARALLELISM:    ARGON2_PARALLELISM,
 	})
 
 	if serverErr != nil {
@@ -42,20 +79,73 @@ func main() {
 }
 
 func BindRoutes(s server.Server, r *mux.Router) {
+	r.HandleFunc("/.well-known/jwks.json", handlers.JwksHandler(s)).Methods(http.MethodGet)
+
 	api := r.PathPrefix("/api").Subrouter()
 
 	api.Use(middlewares.AuthMiddleware(s))
 
-	api.HandleFunc("", handlers.HomeHandler(s)).Methods(http.MethodGet)
-	api.HandleFunc("/users/sign-up", handlers.SignUpHandler(s)).Methods(http.MethodPost)
-	api.HandleFunc("/users/sign-in", handlers.SignInHandler(s)).Methods(http.MethodPost)
-	api.HandleFunc("/users/me", handlers.GetUserHandler(s)).Methods(http.MethodGet)
+	// Every route says who may call it. AuthMiddleware refuses the ones
+	// that don't, so a route can't be left open by mistake.
+	var (
+		public        = middlewares.Public
+		authenticated = middlewares.Authenticated
+		read          = middlewares.Scoped(models.ScopeAccountsRead)
+		write         = middlewares.Scoped(models.ScopeAccountsWrite)
+		transfers     = middlewares.Scoped(models.ScopeTransfersWrite)
+	)
 
-	api.HandleFunc("/bank-accounts", handlers.CreateBankAccountHandler(s)).Methods(http.MethodPost)
-	api.HandleFunc("/bank-accounts/{id}", handlers.GetBankAccountByIdHandler(s)).Methods(http.MethodGet)
-	api.HandleFunc("/bank-accounts/{id}", handlers.UpdateBankAccountByIdHandler(s)).Methods(http.MethodPut)
-	api.HandleFunc("/bank-accounts/{id}", handlers.DeleteBankAccountByIdHandler(s)).Methods(http.MethodDelete)
-	api.HandleFunc("/bank-accounts", handlers.GetAllBankAccountByUserIdHandler(s)).Methods(http.MethodGet)
+	handle := func(router *mux.Router, method string, path string, access middlewares.Access, handler http.Handler) {
+		router.Handle(path, middlewares.Declare(access, handler)).Methods(method)
+	}
+
+	handle(api, http.MethodGet, "", public, handlers.HomeHandler(s))
+	handle(api, http.MethodPost, "/users/sign-up", public, handlers.SignUpHandler(s))
+	handle(api, http.MethodPost, "/users/sign-in", public, handlers.SignInHandler(s))
+	handle(api, http.MethodPost, "/users/sign-in/mfa", public, handlers.MfaSignInHandler(s))
+	handle(api, http.MethodPost, "/users/token/refresh", public, handlers.RefreshTokenHandler(s))
+	handle(api, http.MethodPost, "/users/verify-email", public, handlers.VerifyEmailHandler(s))
+	handle(api, http.MethodPost, "/users/password-reset/request", public, handlers.RequestPasswordResetHandler(s))
+	handle(api, http.MethodPost, "/users/password-reset", public, handlers.ResetPasswordHandler(s))
+	handle(api, http.MethodPost, "/users/sign-out", authenticated, handlers.SignOutHandler(s))
+	handle(api, http.MethodPost, "/users/sign-out/all", authenticated, handlers.SignOutAllHandler(s))
+	handle(api, http.MethodGet, "/users/me", authenticated, handlers.GetUserHandler(s))
+	handle(api, http.MethodPost, "/users/me/verify-email", authenticated, handlers.RequestEmailVerificationHandler(s))
+	handle(api, http.MethodPost, "/users/me/mfa/totp", authenticated, handlers.EnrollTotpHandler(s))
+	handle(api, http.MethodDelete, "/users/me/mfa/totp", authenticated, handlers.DisableTotpHandler(s))
+	handle(api, http.MethodPost, "/users/me/mfa/totp/confirm", authenticated, handlers.ConfirmTotpHandler(s))
+	handle(api, http.MethodGet, "/users/me/sessions", authenticated, handlers.GetSessionsHandler(s))
+	handle(api, http.MethodDelete, "/users/me/sessions/{id}", authenticated, handlers.DeleteSessionHandler(s))
+	handle(api, http.MethodPost, "/users/me/api-keys", authenticated, handlers.CreateApiKeyHandler(s))
+	handle(api, http.MethodGet, "/users/me/api-keys", authenticated, handlers.GetApiKeysHandler(s))
+	handle(api, http.MethodGet, "/users/me/api-keys/{id}", authenticated, handlers.GetApiKeyHandler(s))
+	handle(api, http.MethodPatch, "/users/me/api-keys/{id}", authenticated, handlers.UpdateApiKeyHandler(s))
+	handle(api, http.MethodDelete, "/users/me/api-keys/{id}", authenticated, handlers.DeleteApiKeyHandler(s))
+
+	handle(api, http.MethodPost, "/bank-accounts", write, handlers.CreateBankAccountHandler(s))
+	handle(api, http.MethodGet, "/bank-accounts/{id}", read, handlers.GetBankAccountByIdHandler(s))
+	handle(api, http.MethodPut, "/bank-accounts/{id}", write, handlers.UpdateBankAccountByIdHandler(s))
+	handle(api, http.MethodDelete, "/bank-accounts/{id}", write, handlers.DeleteBankAccountByIdHandler(s))
+	handle(api, http.MethodGet, "/bank-accounts", read, handlers.GetAllBankAccountByUserIdHandler(s))
+	handle(api, http.MethodPost, "/bank-accounts/{id}/transitions", write, handlers.CreateBankAccountTransitionHandler(s))
+	handle(api, http.MethodGet, "/bank-accounts/{id}/transitions", read, handlers.GetBankAccountTransitionsHandler(s))
+	handle(api, http.MethodPost, "/bank-accounts/{id}/close", write, handlers.CloseBankAccountHandler(s))
+	handle(api, http.MethodGet, "/bank-accounts/{id}/transactions", read, handlers.GetBankAccountTransactionsHandler(s))
+	handle(api, http.MethodPost, "/bank-accounts/{id}/deposits", transfers, handlers.CreateDepositHandler(s))
+	handle(api, http.MethodPost, "/bank-accounts/{id}/withdrawals", transfers, handlers.CreateWithdrawalHandler(s))
+
+	handle(api, http.MethodPost, "/transfers", transfers, handlers.CreateTransferHandler(s))
+
+	handle(api, http.MethodGet, "/ws", authenticated, http.HandlerFunc(s.Hub().HandleWebSocket))
+
+	admin := api.PathPrefix("/admin").Subrouter()
+	allow := func(permission models.Permission, handler http.HandlerFunc) http.Handler {
+		return middlewares.RequirePermission(permission)(handler)
+	}
 
-	api.HandleFunc("/ws", s.Hub().HandleWebSocket)
+	handle(admin, http.MethodGet, "/users", authenticated, allow(models.PermissionReadUsers, handlers.ListUsersHandler(s)))
+	handle(admin, http.MethodPost, "/users/{id}/unlock", authenticated, allow(models.PermissionUnlockUsers, handlers.UnlockUserHandler(s)))
+	handle(admin, http.MethodGet, "/bank-accounts/{id}", authenticated, allow(models.PermissionReadBankAccounts, handlers.GetAnyBankAccountHandler(s)))
+	handle(admin, http.MethodPost, "/bank-accounts/{id}/freeze", authenticated, allow(models.PermissionFreezeBankAccounts, handlers.FreezeBankAccountHandler(s)))
+	handle(admin, http.MethodPost, "/bank-accounts/{id}/unfreeze", authenticated, allow(models.PermissionFreezeBankAccounts, handlers.UnfreezeBankAccountHandler(s)))
 }
@@ -0,0 +1,78 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+// ApiKeyHeader is where clients send an API key instead of a token.
+const ApiKeyHeader = "X-API-Key"
+
+// authenticateApiKey signs r in as the owner of key when the key is live,
+// its owner still has the token version it was made with, and it grants the
+// scope access asks for. Otherwise it writes the problem and returns false.
+func authenticateApiKey(w http.ResponseWriter, r *http.Request, key string, access Access) (*http.Request, bool) {
+	apiKey, repoErr := repositories.UseApiKey(r.Context(), models.HashApiKey(key), time.Now().UTC())
+
+	if errors.Is(repoErr, repositories.ErrNotFound) {
+		problems.Write(w, r, http.StatusUnauthorized, "invalid API key")
+
+		return nil, false
+	}
+
+	if repoErr != nil {
+		problems.WriteError(w, r, repoErr)
+
+		return nil, false
+	}
+
+	if usableErr := apiKey.CheckUsable(time.Now().UTC()); usableErr != nil {
+		problems.WriteError(w, r, usableErr)
+
+		return nil, false
+	}
+
+	user, userErr := repositories.ReadUser(r.Context(), apiKey.UserId)
+
+	if errors.Is(userErr, repositories.ErrNotFound) {
+		problems.Write(w, r, http.StatusUnauthorized, "invalid API key")
+
+		return nil, false
+	}
+
+	if userErr != nil {
+		problems.WriteError(w, r, userErr)
+
+		return nil, false
+	}
+
+	if apiKey.TokenVersion != user.TokenVersion {
+		problems.WriteError(w, r, models.ErrApiKeyRevoked)
+
+		return nil, false
+	}
+
+	if access.scope == "" {
+		problems.Write(w, r, http.StatusForbidden, "this can't be done with an API key")
+
+		return nil, false
+	}
+
+	if !apiKey.Can(access.scope) {
+		problems.WriteError(w, r, models.ErrScopeNotGranted)
+
+		return nil, false
+	}
+
+	ctx := context.WithValue(r.Context(), ContextUserId, apiKey.UserId)
+	ctx = context.WithValue(ctx, ContextRole, user.Role)
+	ctx = context.WithValue(ctx, ContextApiKey, apiKey)
+
+	return r.WithContext(ctx), true
+}
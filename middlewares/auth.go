@@ -2,72 +2,178 @@ package middlewares
 
 import (
 	"context"
-	"github.com/pipeline1987/SVB/repositories"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/repositories"
 	"github.com/pipeline1987/SVB/server"
 )
 
+// Access is who may call a route. Every route behind AuthMiddleware
+// declares one with Declare, and routes that don't are never served.
+type Access struct {
+	public bool
+	scope  models.Scope
+}
+
 var (
-	NO_AUTH_NEEDED = []string{
-		"api",
-		"api/sign-up",
-		"api/sign-in",
-	}
+	// Public routes are served to anyone. Credentials sent to them are
+	// ignored.
+	Public = Access{public: true}
+	// Authenticated routes need a signed in user. API keys can't call them.
+	Authenticated = Access{}
 )
 
-func shouldCheckToken(route string) bool {
-	for _, p := range NO_AUTH_NEEDED {
-		if strings.Contains(route, p) {
-			return false
-		}
+// Scoped routes need a signed in user, who holds every scope, or an API key
+// granted scope.
+func Scoped(scope models.Scope) Access {
+	return Access{scope: scope}
+}
+
+type declared struct {
+	access Access
+	http.Handler
+}
+
+// Declare marks handler as callable with access.
+func Declare(access Access, handler http.Handler) http.Handler {
+	return declared{access: access, Handler: handler}
+}
+
+// routeAccess returns the access declared by the route r matched.
+func routeAccess(r *http.Request) (Access, bool) {
+	route := mux.CurrentRoute(r)
+
+	if route == nil {
+		return Access{}, false
 	}
 
-	return true
+	handler, ok := route.GetHandler().(declared)
+
+	return handler.access, ok
 }
 
+// AuthMiddleware signs requests in with the Bearer token in the
+// Authorization header, or the API key in the X-API-Key header, as the
+// access their route declares asks for.
 func AuthMiddleware(s server.Server) func(h http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !shouldCheckToken(r.URL.Path) {
-				next.ServeHTTP(w, r)
+			access, ok := routeAccess(r)
+
+			if !ok {
+				problems.Write(w, r, http.StatusInternalServerError, "route does not declare who may call it")
 
 				return
 			}
 
-			tokenString := strings.TrimSpace(strings.Split(r.Header.Get("Authorization"), "Bearer")[1])
+			if access.public {
+				next.ServeHTTP(w, r)
 
-			parsedToken, jwtErr := jwt.ParseWithClaims(tokenString, &server.AppClaims{}, func(token *jwt.Token) (interface{}, error) {
-				return []byte(s.Config().JWT_SECRET), nil
-			})
+				return
+			}
 
-			if jwtErr != nil {
-				http.Error(w, jwtErr.Error(), http.StatusUnauthorized)
+			var signedIn *http.Request
 
-				return
+			if key := r.Header.Get(ApiKeyHeader); key != "" {
+				signedIn, ok = authenticateApiKey(w, r, key, access)
+			} else {
+				signedIn, ok = authenticateToken(w, r, s)
+			}
+
+			if ok {
+				next.ServeHTTP(w, signedIn)
 			}
+		})
+	}
+}
 
-			if claims, ok := parsedToken.Claims.(*server.AppClaims); ok && parsedToken.Valid {
-				if claims.UserId == "" {
-					http.Error(w, "unauthorized", http.StatusUnauthorized)
+// authenticateToken signs r in as the user its access token was issued to
+// when the token and its session are live. Otherwise it writes the problem
+// and returns false.
+func authenticateToken(w http.ResponseWriter, r *http.Request, s server.Server) (*http.Request, bool) {
+	scheme, tokenString, found := strings.Cut(r.Header.Get("Authorization"), " ")
 
-					return
-				}
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(tokenString) == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		problems.Write(w, r, http.StatusUnauthorized, "unauthorized")
 
-				_, repoErr := repositories.ReadUser(r.Context(), claims.UserId)
+		return nil, false
+	}
 
-				if repoErr != nil {
-					http.Error(w, repoErr.Error(), http.StatusInternalServerError)
-				}
+	parsedToken, jwtErr := jwt.ParseWithClaims(strings.TrimSpace(tokenString), &server.AppClaims{}, s.Keys().Keyfunc(r.Context()))
 
-				ctx := context.WithValue(r.Context(), ContextUserId, claims.UserId)
+	if jwtErr != nil {
+		problems.Write(w, r, http.StatusUnauthorized, jwtErr.Error())
 
-				r = r.WithContext(ctx)
-			}
+		return nil, false
+	}
 
-			next.ServeHTTP(w, r)
-		})
+	claims, ok := parsedToken.Claims.(*server.AppClaims)
+
+	if !ok || !parsedToken.Valid || claims.UserId == "" {
+		problems.Write(w, r, http.StatusUnauthorized, "unauthorized")
+
+		return nil, false
+	}
+
+	user, repoErr := repositories.ReadUser(r.Context(), claims.UserId)
+
+	if errors.Is(repoErr, repositories.ErrNotFound) {
+		problems.Write(w, r, http.StatusUnauthorized, "unauthorized")
+
+		return nil, false
+	}
+
+	if repoErr != nil {
+		problems.WriteError(w, r, repoErr)
+
+		return nil, false
+	}
+
+	if claims.Id == "" || claims.SessionId == "" || claims.TokenVersion != user.TokenVersion {
+		problems.Write(w, r, http.StatusUnauthorized, "token has been revoked")
+
+		return nil, false
+	}
+
+	revoked, revokedErr := repositories.IsAccessTokenRevoked(r.Context(), claims.Id)
+
+	if revokedErr != nil {
+		problems.WriteError(w, r, revokedErr)
+
+		return nil, false
+	}
+
+	if revoked {
+		problems.Write(w, r, http.StatusUnauthorized, "token has been revoked")
+
+		return nil, false
+	}
+
+	session, sessionErr := repositories.TouchSession(r.Context(), claims.SessionId, claims.UserId, time.Now().UTC())
+
+	if errors.Is(sessionErr, repositories.ErrNotFound) || (sessionErr == nil && session.RevokedAt != nil) {
+		problems.Write(w, r, http.StatusUnauthorized, "session has been revoked")
+
+		return nil, false
 	}
+
+	if sessionErr != nil {
+		problems.WriteError(w, r, sessionErr)
+
+		return nil, false
+	}
+
+	ctx := context.WithValue(r.Context(), ContextUserId, claims.UserId)
+	ctx = context.WithValue(ctx, ContextRole, user.Role)
+	ctx = context.WithValue(ctx, ContextClaims, claims)
+
+	return r.WithContext(ctx), true
 }
@@ -0,0 +1,192 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"github.com/pipeline1987/SVB/database/memory"
+	"github.com/pipeline1987/SVB/keyring"
+	"github.com/pipeline1987/SVB/mailer"
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/passwords"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/pipeline1987/SVB/websocket"
+)
+
+// testServer is a server.Server with nothing but a key ring.
+type testServer struct {
+	keys *keyring.Ring
+}
+
+func (s *testServer) Config() *server.Config {
+	return &server.Config{}
+}
+
+func (s *testServer) Hub() *websocket.Hub {
+	return websocket.NewHub()
+}
+
+func (s *testServer) Mailer() mailer.Mailer {
+	return nil
+}
+
+func (s *testServer) Keys() *keyring.Ring {
+	return s.keys
+}
+
+// Passwords hashes with the cheapest argon2id there is, to keep tests fast.
+func (s *testServer) Passwords() *passwords.Hasher {
+	return passwords.New(
+		passwords.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		passwords.Bcrypt{Cost: 4},
+	)
+}
+
+func TestRouteAccess(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	ctx := context.Background()
+	keys, err := keyring.New("secret", keyring.EdDSA, time.Hour, time.Hour)
+
+	if err != nil {
+		t.Fatalf("keyring.New: %v", err)
+	}
+
+	s := &testServer{keys: keys}
+	now := time.Now().UTC()
+	user := &models.User{Id: "ada", Email: "ada@example.com", Role: models.RoleCustomer}
+
+	if _, err = repositories.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if err = repositories.CreateSession(ctx, &models.Session{Id: "laptop", UserId: user.Id, CreatedAt: now, LastSeenAt: now}); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	token, err := keys.Sign(ctx, &server.AppClaims{
+		UserId:    user.Id,
+		Role:      user.Role,
+		SessionId: "laptop",
+		StandardClaims: jwt.StandardClaims{
+			Id:        "token",
+			ExpiresAt: now.Add(time.Minute).Unix(),
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	// whoami answers with the user the request was signed in as.
+	whoami := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := r.Context().Value(ContextUserId).(string)
+		w.Write([]byte(userId))
+	})
+
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api").Subrouter()
+	api.Use(AuthMiddleware(s))
+	api.Handle("", Declare(Public, whoami))
+	api.Handle("/users/me", Declare(Authenticated, whoami))
+	api.Handle("/bank-accounts", Declare(Scoped(models.ScopeAccountsRead), whoami))
+	api.Handle("/forgotten", whoami)
+	api.PathPrefix("/admin").Subrouter().Handle("/users", Declare(Authenticated, whoami))
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+		wantUser      string
+	}{
+		{"public route", "/api", "", http.StatusOK, ""},
+		{"public route ignores credentials", "/api", "Bearer nonsense", http.StatusOK, ""},
+		{"undeclared route", "/api/forgotten", "Bearer " + token, http.StatusInternalServerError, ""},
+		{"no Authorization header", "/api/users/me", "", http.StatusUnauthorized, ""},
+		{"no token", "/api/users/me", "Bearer", http.StatusUnauthorized, ""},
+		{"another scheme", "/api/users/me", "Basic " + token, http.StatusUnauthorized, ""},
+		{"forged token", "/api/users/me", "Bearer nonsense", http.StatusUnauthorized, ""},
+		{"signed in", "/api/users/me", "Bearer " + token, http.StatusOK, user.Id},
+		{"signed in with any scope", "/api/bank-accounts", "bearer " + token, http.StatusOK, user.Id},
+		{"nested router", "/api/admin/users", "", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if tt.wantStatus == http.StatusOK && w.Body.String() != tt.wantUser {
+				t.Errorf("signed in as %q, want %q", w.Body, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestApiKeyOwner(t *testing.T) {
+	repositories.SetRepository(memory.NewRepository())
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	user := &models.User{Id: "ada", Email: "ada@example.com", Role: models.RoleCustomer}
+
+	if _, err := repositories.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	for _, key := range []*models.ApiKey{
+		{Id: "live", UserId: user.Id, KeyHash: models.HashApiKey("svb_live"), Scopes: []models.Scope{models.ScopeAccountsRead}, CreatedAt: now},
+		{Id: "orphan", UserId: "grace", KeyHash: models.HashApiKey("svb_orphan"), Scopes: []models.Scope{models.ScopeAccountsRead}, CreatedAt: now},
+	} {
+		if err := repositories.CreateApiKey(ctx, key, nil); err != nil {
+			t.Fatalf("CreateApiKey: %v", err)
+		}
+	}
+
+	router := mux.NewRouter()
+	router.Use(AuthMiddleware(&testServer{}))
+	router.Handle("/bank-accounts", Declare(Scoped(models.ScopeAccountsRead), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	call := func(key string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/bank-accounts", nil)
+		r.Header.Set(ApiKeyHeader, key)
+		router.ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	if code := call("svb_live"); code != http.StatusOK {
+		t.Errorf("key of a signed in user: status %d, want %d", code, http.StatusOK)
+	}
+
+	if code := call("svb_orphan"); code != http.StatusUnauthorized {
+		t.Errorf("key of a missing user: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// A role change bumps the token version, which revokes the key.
+	if err := repositories.SetUserRole(ctx, user.Id, models.RoleSupport, nil); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+
+	if code := call("svb_live"); code != http.StatusUnauthorized {
+		t.Errorf("key made before a role change: status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
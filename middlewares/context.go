@@ -2,4 +2,14 @@ package middlewares
 
 type ContextKey string
 
-const ContextUserId ContextKey = "userId"
+const (
+	ContextUserId ContextKey = "userId"
+	// ContextRole holds the models.Role the signed in user has now, read
+	// with them rather than taken from their token or API key.
+	ContextRole ContextKey = "role"
+	// ContextClaims holds the *server.AppClaims of the access token.
+	ContextClaims ContextKey = "claims"
+	// ContextApiKey holds the *models.ApiKey a request was made with. It is
+	// only set for requests made with an API key instead of a token.
+	ContextApiKey ContextKey = "apiKey"
+)
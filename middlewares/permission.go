@@ -0,0 +1,36 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/problems"
+	"github.com/pipeline1987/SVB/server"
+)
+
+// RequirePermission lets a request through only when the role the signed in
+// user has now grants permission. It goes on routes AuthMiddleware signs
+// in, and turns away requests made without an access token, such as ones
+// made with an API key.
+func RequirePermission(permission models.Permission) func(h http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, signedIn := r.Context().Value(ContextClaims).(*server.AppClaims)
+			role, ok := r.Context().Value(ContextRole).(models.Role)
+
+			if !signedIn || !ok {
+				problems.Write(w, r, http.StatusUnauthorized, "unauthorized")
+
+				return
+			}
+
+			if !role.Can(permission) {
+				problems.WriteError(w, r, models.ErrPermissionNotGranted)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/pipeline1987/SVB/database"
+	"github.com/pipeline1987/SVB/database/migrations"
+)
+
+const migrateUsage = "usage: svb migrate up|down|status"
+
+// Migrate runs the migrate subcommand against the database at dbHost.
+func Migrate(ctx context.Context, dbHost string, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	db, openErr := database.Open(dbHost)
+
+	if openErr != nil {
+		return openErr
+	}
+
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx, db)
+
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+
+		return err
+	case "down":
+		migration, err := migrations.Down(ctx, db)
+
+		if err != nil {
+			return err
+		}
+
+		if migration == nil {
+			fmt.Println("nothing to roll back")
+
+			return nil
+		}
+
+		fmt.Printf("rolled back %04d_%s\n", migration.Version, migration.Name)
+
+		return nil
+	case "status":
+		statuses, err := migrations.GetStatus(ctx, db)
+
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
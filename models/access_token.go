@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// RevokedAccessToken keeps a signed out access token from being accepted
+// until it expires on its own. Id is the jti claim of the token.
+type RevokedAccessToken struct {
+	Id        string
+	UserId    string
+	ExpiresAt time.Time
+	RevokedAt time.Time
+}
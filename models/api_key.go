@@ -0,0 +1,95 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Scope is what an API key may be used for. Access tokens of a signed in
+// user carry every scope.
+type Scope string
+
+const (
+	ScopeAccountsRead   Scope = "accounts:read"
+	ScopeAccountsWrite  Scope = "accounts:write"
+	ScopeTransfersWrite Scope = "transfers:write"
+)
+
+var scopes = []Scope{ScopeAccountsRead, ScopeAccountsWrite, ScopeTransfersWrite}
+
+const (
+	// ApiKeyPrefix starts every API key, so a leaked one is easy to spot.
+	ApiKeyPrefix = "svb_"
+	// ApiKeyTouchInterval is how stale LastUsedAt may get before a request
+	// updates it, so that not every request writes to the key.
+	ApiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidScope    = errors.New("scope must be accounts:read, accounts:write or transfers:write")
+	ErrApiKeyExpired   = errors.New("API key has expired")
+	ErrApiKeyRevoked   = errors.New("API key has been revoked")
+	ErrScopeNotGranted = errors.New("API key is not granted the scope this needs")
+)
+
+func (s Scope) IsValid() bool {
+	for _, scope := range scopes {
+		if scope == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ApiKey lets a program call SVB on behalf of the user who made it, within
+// its Scopes. Only a hash of the key is kept. Prefix is the start of the
+// key, shown so its owner can tell keys apart. TokenVersion is the one of
+// its owner when it was made, so signing them out everywhere or changing
+// their role revokes the key as it does their tokens.
+type ApiKey struct {
+	Id           string
+	UserId       string
+	Name         string
+	Prefix       string
+	KeyHash      string
+	Scopes       []Scope
+	TokenVersion int
+	CreatedAt    time.Time
+	ExpiresAt    *time.Time
+	LastUsedAt   *time.Time
+	RevokedAt    *time.Time
+}
+
+// HashApiKey is how API keys are stored and looked up. The keys are random
+// enough that a plain SHA-256 cannot be reversed.
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Can tells whether the key is granted scope.
+func (k *ApiKey) Can(scope Scope) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckUsable tells whether the key can still be used at now.
+func (k *ApiKey) CheckUsable(now time.Time) error {
+	switch {
+	case k.RevokedAt != nil:
+		return ErrApiKeyRevoked
+	case k.ExpiresAt != nil && !now.Before(*k.ExpiresAt):
+		return ErrApiKeyExpired
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Actions recorded in the audit log.
+const (
+	AuditLoginLockedOut      = "login.locked_out"
+	AuditLoginUnlocked       = "login.unlocked"
+	AuditRoleChanged         = "user.role_changed"
+	AuditApiKeyCreated       = "api_key.created"
+	AuditApiKeyRevoked       = "api_key.revoked"
+	AuditUsersListed         = "admin.users_listed"
+	AuditBankAccountViewed   = "admin.bank_account_viewed"
+	AuditBankAccountFrozen   = "admin.bank_account_frozen"
+	AuditBankAccountUnfrozen = "admin.bank_account_unfrozen"
+)
+
+// AuditEvent records something done to an account that an operator may need
+// to look into later. ActorId is the user who did it, empty when SVB did it
+// on its own, and Subject is what it was done to.
+type AuditEvent struct {
+	Id        string
+	Action    string
+	ActorId   string
+	Subject   string
+	IpAddress string
+	CreatedAt time.Time
+}
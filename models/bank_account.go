@@ -1,9 +1,159 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// BankAccountState is a step of the bank account lifecycle:
+//
+//	pending -> active -> frozen | dormant -> closed
+//
+// Frozen and dormant accounts can go back to active, and any account that is
+// not closed yet can be closed. Closed is final.
+type BankAccountState string
+
+const (
+	BankAccountStatePending BankAccountState = "pending"
+	BankAccountStateActive  BankAccountState = "active"
+	BankAccountStateFrozen  BankAccountState = "frozen"
+	BankAccountStateDormant BankAccountState = "dormant"
+	BankAccountStateClosed  BankAccountState = "closed"
+)
+
+var bankAccountTransitions = map[BankAccountState][]BankAccountState{
+	BankAccountStatePending: {BankAccountStateActive, BankAccountStateClosed},
+	BankAccountStateActive:  {BankAccountStateFrozen, BankAccountStateDormant, BankAccountStateClosed},
+	BankAccountStateFrozen:  {BankAccountStateActive, BankAccountStateClosed},
+	BankAccountStateDormant: {BankAccountStateActive, BankAccountStateClosed},
+	BankAccountStateClosed:  {},
+}
+
+var (
+	ErrInvalidBankAccountState = errors.New("invalid bank account state")
+	ErrIllegalTransition       = errors.New("illegal bank account state transition")
+	ErrTransitionReason        = errors.New("a bank account state transition needs a reason")
+	ErrCreditNotAllowed        = errors.New("bank account does not accept credits")
+	ErrDebitNotAllowed         = errors.New("bank account does not accept debits")
+	ErrBankAccountNotEmpty     = errors.New("bank account still holds funds")
+	ErrBankAccountHasHistory   = errors.New("bank account has ledger history and can only be closed")
+	ErrInvalidSweepTarget      = errors.New("a closing bank account must be swept into another customer account")
+	ErrInvalidBankAccountSort  = errors.New("bank accounts can be sorted by created, name or balance")
+)
+
+const (
+	SortBankAccountsByCreated = "created"
+	SortBankAccountsByName    = "name"
+	SortBankAccountsByBalance = "balance"
+)
+
 type BankAccount struct {
-	Id      string
-	UserId  string
-	Name    string
-	Balance float64
-	State   string
+	Id       string
+	UserId   string
+	Name     string
+	Currency string
+	// Balance is derived from the ledger entries posted to the account and is
+	// never written directly.
+	Balance Money
+	State   BankAccountState
+}
+
+// BankAccountTransition records a single move of a bank account through its
+// lifecycle: who made it, when and why.
+type BankAccountTransition struct {
+	Id            string
+	BankAccountId string
+	From          BankAccountState
+	To            BankAccountState
+	Reason        string
+	ActorId       string
+	CreatedAt     time.Time
+}
+
+// BankAccountClosure describes how a bank account gets closed. When the
+// account still holds money, Sweep moves it elsewhere; its entry amounts are
+// set to the balance at closing time.
+type BankAccountClosure struct {
+	Transition *BankAccountTransition
+	Sweep      *Transaction
+}
+
+// BankAccountFilter narrows and orders a list of bank accounts. An empty
+// State lists every state; Sort defaults to creation order.
+type BankAccountFilter struct {
+	State      BankAccountState
+	Sort       string
+	Descending bool
+}
+
+// SortKey returns the value the account is ordered by under the given sort,
+// as stored in page cursors. Creation order is the ksuid order of the id, so
+// it needs no key of its own.
+func (b *BankAccount) SortKey(sort string) string {
+	switch sort {
+	case SortBankAccountsByName:
+		return b.Name
+	case SortBankAccountsByBalance:
+		return strconv.FormatInt(b.Balance.Amount, 10)
+	default:
+		return ""
+	}
+}
+
+func (s BankAccountState) IsValid() bool {
+	_, ok := bankAccountTransitions[s]
+
+	return ok
+}
+
+func (s BankAccountState) CanTransitionTo(to BankAccountState) error {
+	if !s.IsValid() || !to.IsValid() {
+		return ErrInvalidBankAccountState
+	}
+
+	for _, next := range bankAccountTransitions[s] {
+		if next == to {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s to %s", ErrIllegalTransition, s, to)
+}
+
+// CanCredit reports whether money may be paid into an account in this state.
+// Everything but a closed account keeps receiving funds.
+func (s BankAccountState) CanCredit() error {
+	switch s {
+	case BankAccountStatePending, BankAccountStateActive, BankAccountStateFrozen, BankAccountStateDormant:
+		return nil
+	default:
+		return fmt.Errorf("%w: account is %s", ErrCreditNotAllowed, s)
+	}
+}
+
+// CanDebit reports whether money may be taken out of an account in this
+// state. Only active accounts can be debited.
+func (s BankAccountState) CanDebit() error {
+	switch s {
+	case BankAccountStateActive:
+		return nil
+	default:
+		return fmt.Errorf("%w: account is %s", ErrDebitNotAllowed, s)
+	}
+}
+
+// Validate checks that the transition is legal from its From state and that
+// it carries a reason.
+func (t *BankAccountTransition) Validate() error {
+	if err := t.From.CanTransitionTo(t.To); err != nil {
+		return err
+	}
+
+	if t.Reason == "" {
+		return ErrTransitionReason
+	}
+
+	return nil
 }
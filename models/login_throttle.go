@@ -0,0 +1,99 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrLoginThrottled = errors.New("too many failed sign in attempts, try again later")
+
+// LoginPolicy says how failed sign ins against one key slow down the next
+// ones. The first FreeFailures cost nothing, then every failure doubles the
+// wait from BaseDelay, and LockoutAfter failures lock the key for Lockout.
+// Failures are forgotten after ForgetAfter without any.
+type LoginPolicy struct {
+	FreeFailures int
+	BaseDelay    time.Duration
+	LockoutAfter int
+	Lockout      time.Duration
+	ForgetAfter  time.Duration
+}
+
+var (
+	// EmailLoginPolicy guards a single account.
+	EmailLoginPolicy = LoginPolicy{
+		FreeFailures: 3,
+		BaseDelay:    time.Second,
+		LockoutAfter: 10,
+		Lockout:      15 * time.Minute,
+		ForgetAfter:  time.Hour,
+	}
+	// IpLoginPolicy guards against one address guessing across accounts. It
+	// is looser, as many users can share an address.
+	IpLoginPolicy = LoginPolicy{
+		FreeFailures: 20,
+		BaseDelay:    time.Second,
+		LockoutAfter: 100,
+		Lockout:      15 * time.Minute,
+		ForgetAfter:  time.Hour,
+	}
+)
+
+// Delay is how long to wait after the given number of failures.
+func (p LoginPolicy) Delay(failures int) time.Duration {
+	if failures >= p.LockoutAfter {
+		return p.Lockout
+	}
+
+	if failures <= p.FreeFailures {
+		return 0
+	}
+
+	delay := p.BaseDelay << (failures - p.FreeFailures - 1)
+
+	if delay <= 0 || delay > p.Lockout {
+		return p.Lockout
+	}
+
+	return delay
+}
+
+// LoginThrottle counts the failed sign ins of a key, an email address or a
+// client address.
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
+}
+
+func EmailLoginKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IpLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Fail records a failed sign in at at.
+func (t *LoginThrottle) Fail(at time.Time, policy LoginPolicy) {
+	if at.Sub(t.LastFailureAt) > policy.ForgetAfter {
+		t.Failures = 0
+	}
+
+	t.Failures++
+	t.LastFailureAt = at
+	t.BlockedUntil = at.Add(policy.Delay(t.Failures))
+}
+
+// Blocked tells whether a sign in must wait at now.
+func (t *LoginThrottle) Blocked(now time.Time) bool {
+	return now.Before(t.BlockedUntil)
+}
+
+// LockedOut tells whether the last failure locked the key, as opposed to
+// only delaying the next attempt.
+func (t *LoginThrottle) LockedOut(policy LoginPolicy) bool {
+	return t.Failures >= policy.LockoutAfter
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// MaxMfaChallengeAttempts is how many codes can be tried against one sign in
+// challenge before the password has to be entered again.
+const MaxMfaChallengeAttempts = 5
+
+var (
+	ErrTotpAlreadyEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTotpCodeReused        = errors.New("code was already used")
+	ErrMfaChallengeExpired   = errors.New("sign in challenge has expired")
+	ErrMfaChallengeCompleted = errors.New("sign in challenge was already completed")
+	ErrMfaChallengeLocked    = errors.New("too many attempts for this sign in challenge")
+)
+
+// TotpCredential is the authenticator app enrolled by a user. It guards sign
+// in only once it is confirmed with a first code. LastUsedStep is the time
+// step of the last accepted code, which can't be accepted again.
+type TotpCredential struct {
+	UserId       string
+	Secret       string
+	CreatedAt    time.Time
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+}
+
+func (c *TotpCredential) Enabled() bool {
+	return c.ConfirmedAt != nil
+}
+
+// RecoveryCode stands in for a TOTP code once, for users who lost their
+// authenticator. Only a hash of the code is kept.
+type RecoveryCode struct {
+	Id       string
+	UserId   string
+	CodeHash string
+	UsedAt   *time.Time
+}
+
+// MfaChallenge is the second step of signing in with two-factor
+// authentication enabled, handed out once the password checked out.
+type MfaChallenge struct {
+	Id          string
+	UserId      string
+	TokenHash   string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	Attempts    int
+	CompletedAt *time.Time
+}
+
+// CheckUsable tells whether another code can be tried against the challenge
+// at now.
+func (c *MfaChallenge) CheckUsable(now time.Time) error {
+	switch {
+	case c.CompletedAt != nil:
+		return ErrMfaChallengeCompleted
+	case !now.Before(c.ExpiresAt):
+		return ErrMfaChallengeExpired
+	case c.Attempts >= MaxMfaChallengeAttempts:
+		return ErrMfaChallengeLocked
+	default:
+		return nil
+	}
+}
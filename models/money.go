@@ -0,0 +1,251 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const DefaultCurrency = "USD"
+
+var (
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	ErrCurrencyMismatch    = errors.New("cannot mix amounts in different currencies")
+	ErrInvalidAmount       = errors.New("invalid amount")
+	ErrRoundingNecessary   = errors.New("amount has more decimals than its currency allows")
+	ErrMoneyOverflow       = errors.New("amount is out of range")
+)
+
+// currencyExponents holds the number of minor unit digits of every ISO 4217
+// currency SVB accepts.
+var currencyExponents = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CAD": 2,
+	"MXN": 2,
+	"COP": 2,
+	"PEN": 2,
+	"ARS": 2,
+	"BRL": 2,
+	"CHF": 2,
+	"JPY": 0,
+	"CLP": 0,
+	"KWD": 3,
+	"BHD": 3,
+}
+
+// RoundingMode tells ParseMoney what to do with digits beyond the minor unit
+// of the currency.
+type RoundingMode int
+
+const (
+	// RoundUnnecessary rejects amounts that would need rounding. It is the
+	// mode used for anything a client sends us.
+	RoundUnnecessary RoundingMode = iota
+	// RoundHalfEven rounds to the nearest minor unit and ties to the even
+	// one (banker's rounding).
+	RoundHalfEven
+	// RoundHalfUp rounds to the nearest minor unit and ties away from zero.
+	RoundHalfUp
+	// RoundDown truncates towards zero.
+	RoundDown
+)
+
+// Money is an exact amount expressed in the minor units (cents) of an ISO
+// 4217 currency. Arithmetic between different currencies is refused.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func CurrencyExponent(currency string) (int, error) {
+	exponent, ok := currencyExponents[currency]
+
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+
+	return exponent, nil
+}
+
+func NewMoney(amount int64, currency string) (Money, error) {
+	if _, err := CurrencyExponent(currency); err != nil {
+		return Money{}, err
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// ParseMoney reads a decimal string in major units, such as "10.50", and
+// converts it to minor units applying the given rounding mode.
+func ParseMoney(value string, currency string, mode RoundingMode) (Money, error) {
+	exponent, err := CurrencyExponent(currency)
+
+	if err != nil {
+		return Money{}, err
+	}
+
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(value, ".")
+
+	if whole == "" && fraction == "" || !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+
+	var rest string
+
+	if len(fraction) > exponent {
+		rest = fraction[exponent:]
+		fraction = fraction[:exponent]
+	}
+
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	digits := strings.TrimLeft(whole+fraction, "0")
+
+	if digits == "" {
+		digits = "0"
+	}
+
+	amount, err := strconv.ParseInt(digits, 10, 64)
+
+	if err != nil {
+		return Money{}, ErrMoneyOverflow
+	}
+
+	if strings.Trim(rest, "0") != "" {
+		switch mode {
+		case RoundUnnecessary:
+			return Money{}, ErrRoundingNecessary
+		case RoundHalfEven:
+			if rest[0] > '5' || rest[0] == '5' && (strings.Trim(rest[1:], "0") != "" || amount%2 == 1) {
+				amount++
+			}
+		case RoundHalfUp:
+			if rest[0] >= '5' {
+				amount++
+			}
+		case RoundDown:
+		}
+
+		if amount < 0 {
+			return Money{}, ErrMoneyOverflow
+		}
+	}
+
+	if negative {
+		amount = -amount
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+
+	if other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount ||
+		other.Amount < 0 && m.Amount < math.MinInt64-other.Amount {
+		return Money{}, ErrMoneyOverflow
+	}
+
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(other.Neg())
+}
+
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// String formats the amount in major units followed by the currency code,
+// for example "-10.50 USD".
+func (m Money) String() string {
+	exponent := currencyExponents[m.Currency]
+
+	sign := ""
+	amount := uint64(m.Amount)
+
+	if m.Amount < 0 {
+		sign = "-"
+		amount = uint64(-m.Amount)
+	}
+
+	digits := strconv.FormatUint(amount, 10)
+
+	if exponent > 0 {
+		if len(digits) <= exponent {
+			digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+		}
+
+		digits = digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+	}
+
+	return strings.TrimSpace(sign + digits + " " + m.Currency)
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON writes the amount as a decimal string in major units so that
+// clients never have to go through a float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	amount, _, _ := strings.Cut(m.String(), " ")
+
+	return json.Marshal(moneyJSON{
+		Amount:   amount,
+		Currency: m.Currency,
+	})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw moneyJSON
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	parsed, err := ParseMoney(raw.Amount, raw.Currency, RoundUnnecessary)
+
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		mode     RoundingMode
+		want     int64
+		wantErr  error
+	}{
+		{"10.50", "USD", RoundUnnecessary, 1050, nil},
+		{"10.5", "USD", RoundUnnecessary, 1050, nil},
+		{"10", "USD", RoundUnnecessary, 1000, nil},
+		{"10.", "USD", RoundUnnecessary, 1000, nil},
+		{".05", "USD", RoundUnnecessary, 5, nil},
+		{" +7.25 ", "USD", RoundUnnecessary, 725, nil},
+		{"-10.50", "USD", RoundUnnecessary, -1050, nil},
+		{"-0", "USD", RoundUnnecessary, 0, nil},
+		{"1000", "JPY", RoundUnnecessary, 1000, nil},
+		{"1.234", "KWD", RoundUnnecessary, 1234, nil},
+		{"10.500", "USD", RoundUnnecessary, 1050, nil},
+
+		// Too many fraction digits.
+		{"10.505", "USD", RoundUnnecessary, 0, ErrRoundingNecessary},
+		{"1.5", "JPY", RoundUnnecessary, 0, ErrRoundingNecessary},
+		{"10.505", "USD", RoundDown, 1050, nil},
+		{"10.509", "USD", RoundDown, 1050, nil},
+		{"-10.509", "USD", RoundDown, -1050, nil},
+
+		// Rounding to the minor unit.
+		{"10.505", "USD", RoundHalfUp, 1051, nil},
+		{"10.504", "USD", RoundHalfUp, 1050, nil},
+		{"-10.505", "USD", RoundHalfUp, -1051, nil},
+		{"10.505", "USD", RoundHalfEven, 1050, nil},
+		{"10.515", "USD", RoundHalfEven, 1052, nil},
+		{"10.5051", "USD", RoundHalfEven, 1051, nil},
+		{"10.506", "USD", RoundHalfEven, 1051, nil},
+		{"-10.515", "USD", RoundHalfEven, -1052, nil},
+		{"2.5", "JPY", RoundHalfEven, 2, nil},
+		{"3.5", "JPY", RoundHalfEven, 4, nil},
+
+		// int64 overflow.
+		{"92233720368547758.07", "USD", RoundUnnecessary, 9223372036854775807, nil},
+		{"-92233720368547758.07", "USD", RoundUnnecessary, -9223372036854775807, nil},
+		{"92233720368547758.08", "USD", RoundUnnecessary, 0, ErrMoneyOverflow},
+		{"92233720368547758.075", "USD", RoundHalfUp, 0, ErrMoneyOverflow},
+		{"9223372036854775808", "JPY", RoundUnnecessary, 0, ErrMoneyOverflow},
+
+		// Malformed amounts and currencies.
+		{"", "USD", RoundUnnecessary, 0, ErrInvalidAmount},
+		{".", "USD", RoundUnnecessary, 0, ErrInvalidAmount},
+		{"-", "USD", RoundUnnecessary, 0, ErrInvalidAmount},
+		{"1,000.00", "USD", RoundUnnecessary, 0, ErrInvalidAmount},
+		{"1e3", "USD", RoundUnnecessary, 0, ErrInvalidAmount},
+		{"--1", "USD", RoundUnnecessary, 0, ErrInvalidAmount},
+		{"1.2.3", "USD", RoundUnnecessary, 0, ErrInvalidAmount},
+		{"10", "XXX", RoundUnnecessary, 0, ErrUnsupportedCurrency},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value, tt.currency, tt.mode)
+
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ParseMoney(%q, %s, %d): got %v, want %v", tt.value, tt.currency, tt.mode, err, tt.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil || got.Amount != tt.want || got.Currency != tt.currency {
+			t.Errorf("ParseMoney(%q, %s, %d) = %+v, %v, want %d", tt.value, tt.currency, tt.mode, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{Money{1050, "USD"}, "10.50 USD"},
+		{Money{5, "USD"}, "0.05 USD"},
+		{Money{0, "USD"}, "0.00 USD"},
+		{Money{-1050, "USD"}, "-10.50 USD"},
+		{Money{-5, "USD"}, "-0.05 USD"},
+		{Money{1000, "JPY"}, "1000 JPY"},
+		{Money{-1000, "JPY"}, "-1000 JPY"},
+		{Money{1234, "KWD"}, "1.234 KWD"},
+		{Money{9223372036854775807, "USD"}, "92233720368547758.07 USD"},
+		{Money{-9223372036854775808, "USD"}, "-92233720368547758.08 USD"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.money, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyRoundTrip(t *testing.T) {
+	for _, amount := range []int64{0, 1, -1, 99, 100, -12345, 9223372036854775807, -9223372036854775807} {
+		money := Money{Amount: amount, Currency: "USD"}
+		text := money.String()
+		parsed, err := ParseMoney(text[:len(text)-len(" USD")], "USD", RoundUnnecessary)
+
+		if err != nil || parsed != money {
+			t.Errorf("ParseMoney(%q) = %+v, %v, want %+v", text, parsed, err, money)
+		}
+	}
+}
+
+func TestMoneyAdd(t *testing.T) {
+	tests := []struct {
+		a, b    Money
+		want    int64
+		wantErr error
+	}{
+		{Money{100, "USD"}, Money{-250, "USD"}, -150, nil},
+		{Money{9223372036854775807, "USD"}, Money{1, "USD"}, 0, ErrMoneyOverflow},
+		{Money{-9223372036854775807, "USD"}, Money{-2, "USD"}, 0, ErrMoneyOverflow},
+		{Money{100, "USD"}, Money{100, "EUR"}, 0, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.a.Add(tt.b)
+
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%v.Add(%v): got %v, want %v", tt.a, tt.b, err, tt.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil || got.Amount != tt.want {
+			t.Errorf("%v.Add(%v) = %v, %v, want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+var (
+	ErrInvalidCursor    = errors.New("invalid cursor")
+	ErrInvalidPageLimit = errors.New("limit must be between 1 and 100")
+)
+
+// Cursor points at the item a page starts after or, when Backward is set,
+// the item it ends before. Key holds the sort key of that item when a list
+// is not sorted by id alone; Id always breaks ties so the order is stable.
+type Cursor struct {
+	Backward bool   `json:"b,omitempty"`
+	Key      string `json:"k,omitempty"`
+	Id       string `json:"i"`
+}
+
+// PageRequest asks for at most Limit items from Cursor onwards. A nil Cursor
+// asks for the first page.
+type PageRequest struct {
+	Cursor *Cursor
+	Limit  int
+}
+
+// Page carries the opaque cursors of the pages around the one returned.
+// They are empty when there is nothing in that direction.
+type Page struct {
+	NextCursor string
+	PrevCursor string
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(value string) (*Cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+
+	if err = json.Unmarshal(data, &cursor); err != nil || cursor.Id == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+func NewPageRequest(cursor string, limit int) (PageRequest, error) {
+	decoded, err := DecodeCursor(cursor)
+
+	if err != nil {
+		return PageRequest{}, err
+	}
+
+	if limit == 0 {
+		limit = DefaultPageLimit
+	}
+
+	if limit < 1 || limit > MaxPageLimit {
+		return PageRequest{}, ErrInvalidPageLimit
+	}
+
+	return PageRequest{Cursor: decoded, Limit: limit}, nil
+}
+
+// Backward reports whether the page has to be read in reverse sort order.
+func (p PageRequest) Backward() bool {
+	return p.Cursor != nil && p.Cursor.Backward
+}
+
+// Paginate turns the rows a backend fetched for a page request into the page
+// itself. Backends read up to Limit+1 rows past the cursor, in reverse sort
+// order when the request goes backward; the extra row only tells whether
+// there is more to come. cursorOf returns the forward cursor of an item.
+func Paginate[T any](items []T, request PageRequest, cursorOf func(T) Cursor) ([]T, Page) {
+	hasMore := len(items) > request.Limit
+
+	if hasMore {
+		items = items[:request.Limit]
+	}
+
+	if request.Backward() {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	var page Page
+
+	if len(items) == 0 {
+		return items, page
+	}
+
+	first := cursorOf(items[0])
+	first.Backward = true
+	last := cursorOf(items[len(items)-1])
+
+	if request.Backward() {
+		page.NextCursor = last.Encode()
+
+		if hasMore {
+			page.PrevCursor = first.Encode()
+		}
+
+		return items, page
+	}
+
+	if hasMore {
+		page.NextCursor = last.Encode()
+	}
+
+	if request.Cursor != nil {
+		page.PrevCursor = first.Encode()
+	}
+
+	return items, page
+}
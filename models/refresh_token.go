@@ -0,0 +1,41 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenRevoked = errors.New("refresh token has been revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used")
+)
+
+// RefreshToken is the server side record of an opaque refresh token. Only a
+// hash of the token is kept. Every token is used once: refreshing rotates it
+// into the next token of the same family, which starts at sign in.
+type RefreshToken struct {
+	Id        string
+	FamilyId  string
+	UserId    string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// CheckUsable tells whether the token can still be rotated at now. A token
+// that was already used has leaked, and the caller has to revoke its family.
+func (t *RefreshToken) CheckUsable(now time.Time) error {
+	switch {
+	case t.RevokedAt != nil:
+		return ErrRefreshTokenRevoked
+	case t.UsedAt != nil:
+		return ErrRefreshTokenReused
+	case !now.Before(t.ExpiresAt):
+		return ErrRefreshTokenExpired
+	default:
+		return nil
+	}
+}
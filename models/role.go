@@ -0,0 +1,63 @@
+package models
+
+import "errors"
+
+// Role is what a user may do in SVB. Customers manage their own accounts,
+// support staff look into and unlock anybody's, and admins also freeze
+// accounts.
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleSupport  Role = "support"
+	RoleAdmin    Role = "admin"
+)
+
+// Permission is something beyond a customer's own accounts that a role may
+// be granted.
+type Permission string
+
+const (
+	PermissionReadUsers          Permission = "users:read"
+	PermissionUnlockUsers        Permission = "users:unlock"
+	PermissionReadBankAccounts   Permission = "bank_accounts:read"
+	PermissionFreezeBankAccounts Permission = "bank_accounts:freeze"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleCustomer: {},
+	RoleSupport: {
+		PermissionReadUsers,
+		PermissionUnlockUsers,
+		PermissionReadBankAccounts,
+	},
+	RoleAdmin: {
+		PermissionReadUsers,
+		PermissionUnlockUsers,
+		PermissionReadBankAccounts,
+		PermissionFreezeBankAccounts,
+	},
+}
+
+var (
+	ErrInvalidRole          = errors.New("role must be customer, support or admin")
+	ErrFrozenByStaff        = errors.New("bank account was frozen by SVB staff and only they can unfreeze it")
+	ErrPermissionNotGranted = errors.New("your role does not allow this")
+)
+
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+
+	return ok
+}
+
+// Can tells whether the role grants permission.
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// SessionTouchInterval is how stale LastSeenAt may get before a request
+// updates it, so that not every request writes to the session.
+const SessionTouchInterval = time.Minute
+
+// Session is a signed in device. It starts at sign in and its Id is the family
+// of the refresh tokens it is kept alive with, so revoking a session revokes
+// that family as well.
+type Session struct {
+	Id         string
+	UserId     string
+	UserAgent  string
+	IpAddress  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	RevokedAt  *time.Time
+}
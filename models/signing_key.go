@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// SigningKey is one key of the ring access tokens are signed with. It signs
+// from ActiveFrom until ActiveUntil, and verifiers are offered it from
+// CreatedAt until ExpiresAt: ahead of its first token, so they learn of it in
+// time, and after its last, so the tokens it signed can be checked until
+// they expire. PrivateKey is sealed with the server secret.
+type SigningKey struct {
+	Id          string
+	Algorithm   string
+	PrivateKey  string
+	CreatedAt   time.Time
+	ActiveFrom  time.Time
+	ActiveUntil time.Time
+	ExpiresAt   time.Time
+}
+
+// Active tells whether the key signs tokens at at.
+func (k *SigningKey) Active(at time.Time) bool {
+	return !at.Before(k.ActiveFrom) && at.Before(k.ActiveUntil)
+}
@@ -0,0 +1,164 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	DebitEntry  = "debit"
+	CreditEntry = "credit"
+)
+
+const (
+	TransferTransaction   = "transfer"
+	DepositTransaction    = "deposit"
+	WithdrawalTransaction = "withdrawal"
+	SweepTransaction      = "sweep"
+)
+
+// ExternalBankAccountId is the settlement account that stands for money
+// entering or leaving SVB. It is the counterpart of every deposit and
+// withdrawal and, unlike customer accounts, it has no bank_accounts row and
+// may run a negative balance.
+const ExternalBankAccountId = "external"
+
+var (
+	ErrEmptyTransaction      = errors.New("a transaction needs at least one debit and one credit entry")
+	ErrInvalidEntryAmount    = errors.New("ledger entry amounts must be positive")
+	ErrInvalidEntryDirection = errors.New("ledger entry direction must be debit or credit")
+	ErrUnbalancedTransaction = errors.New("transaction debits and credits do not balance")
+	ErrInsufficientFunds     = errors.New("insufficient funds")
+)
+
+// LedgerEntry is one side of a Transaction. Amount is always positive and
+// Direction says which way it moves. For customer bank accounts a credit
+// increases the balance and a debit decreases it.
+type LedgerEntry struct {
+	Id            string
+	TransactionId string
+	BankAccountId string
+	Direction     string
+	Amount        Money
+}
+
+// Transaction groups the ledger entries of a single balance change. It is
+// only valid when all its entries share a currency and the sum of its debits
+// equals the sum of its credits.
+type Transaction struct {
+	Id          string
+	Kind        string
+	Description string
+	CreatedAt   time.Time
+	Entries     []*LedgerEntry
+}
+
+func (t *Transaction) Validate() error {
+	if len(t.Entries) == 0 {
+		return ErrEmptyTransaction
+	}
+
+	currency := t.Entries[0].Amount.Currency
+
+	debits := Money{Currency: currency}
+	credits := Money{Currency: currency}
+
+	for _, entry := range t.Entries {
+		if !entry.Amount.IsPositive() {
+			return ErrInvalidEntryAmount
+		}
+
+		var err error
+
+		switch entry.Direction {
+		case DebitEntry:
+			debits, err = debits.Add(entry.Amount)
+		case CreditEntry:
+			credits, err = credits.Add(entry.Amount)
+		default:
+			return ErrInvalidEntryDirection
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if debits.IsZero() || credits.IsZero() {
+		return ErrEmptyTransaction
+	}
+
+	if debits != credits {
+		return ErrUnbalancedTransaction
+	}
+
+	return nil
+}
+
+// BankAccountIds returns the distinct bank accounts the transaction touches,
+// sorted so that callers can lock them in a stable order.
+func (t *Transaction) BankAccountIds() []string {
+	var ids []string
+
+	seen := map[string]bool{}
+
+	for _, entry := range t.Entries {
+		if !seen[entry.BankAccountId] {
+			seen[entry.BankAccountId] = true
+			ids = append(ids, entry.BankAccountId)
+		}
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// NetAmount returns the effect of the transaction on the balance of the given
+// bank account. It assumes the transaction has been validated.
+func (t *Transaction) NetAmount(bankAccountId string) Money {
+	var net = Money{Currency: t.Entries[0].Amount.Currency}
+
+	for _, entry := range t.Entries {
+		if entry.BankAccountId == bankAccountId {
+			net.Amount += entry.SignedAmount().Amount
+		}
+	}
+
+	return net
+}
+
+// SignedAmount returns the effect of the entry on the balance of its bank
+// account.
+func (e *LedgerEntry) SignedAmount() Money {
+	if e.Direction == DebitEntry {
+		return e.Amount.Neg()
+	}
+
+	return e.Amount
+}
+
+// AccountTransaction is a transaction as seen from one of the bank accounts
+// it touches: the entry posted to that account plus the transaction details.
+type AccountTransaction struct {
+	EntryId       string
+	TransactionId string
+	BankAccountId string
+	Kind          string
+	Description   string
+	Direction     string
+	Amount        Money
+	CreatedAt     time.Time
+}
+
+// TransactionFilter narrows the transaction history of a bank account. Zero
+// values mean no filter; To is exclusive.
+type TransactionFilter struct {
+	BankAccountId string
+	From          time.Time
+	To            time.Time
+	MinAmount     *Money
+	MaxAmount     *Money
+	Direction     string
+}
@@ -1,8 +1,15 @@
 package models
 
+import "time"
+
 type User struct {
 	Id       string `json:"id"`
 	Email    string `json:"email"`
 	FullName string `json:"full_name"`
 	Password string `json:"password"`
+	Role     Role   `json:"role"`
+	// TokenVersion is signed into every access token. Bumping it signs the
+	// user out of every session at once.
+	TokenVersion    int        `json:"token_version"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
 }
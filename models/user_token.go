@@ -0,0 +1,46 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// UserTokenPurpose is what a UserToken can be used for. A token only works
+// for the purpose it was issued for.
+type UserTokenPurpose string
+
+const (
+	UserTokenVerifyEmail   UserTokenPurpose = "verify_email"
+	UserTokenResetPassword UserTokenPurpose = "reset_password"
+)
+
+var (
+	ErrUserTokenExpired     = errors.New("token has expired")
+	ErrUserTokenUsed        = errors.New("token was already used")
+	ErrEmailNotVerified     = errors.New("email address is not verified")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+)
+
+// UserToken is a single-use token sent to the email address of a user, to
+// prove they can read mail sent there. Only a hash of the token is kept.
+type UserToken struct {
+	Id        string
+	UserId    string
+	Purpose   UserTokenPurpose
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// CheckUsable tells whether the token can still be used at now.
+func (t *UserToken) CheckUsable(now time.Time) error {
+	switch {
+	case t.UsedAt != nil:
+		return ErrUserTokenUsed
+	case !now.Before(t.ExpiresAt):
+		return ErrUserTokenExpired
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,125 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// Argon2id hashes with argon2id, the variant RFC 9106 recommends, into
+// $argon2id$v=19$m=<Memory>,t=<Iterations>,p=<Parallelism>$<salt>$<key>
+// with salt and key in unpadded base64.
+type Argon2id struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id is the smallest argon2id configuration OWASP recommends,
+// 19 MiB of memory over two passes.
+var DefaultArgon2id = Argon2id{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// argon2idHash is a decoded argon2id hash.
+type argon2idHash struct {
+	Argon2id
+	salt []byte
+	key  []byte
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		a.Memory,
+		a.Iterations,
+		a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a Argon2id) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+func (a Argon2id) Verify(password string, hash string) error {
+	decoded, err := decodeArgon2id(hash)
+
+	if err != nil {
+		return err
+	}
+
+	key := argon2.IDKey([]byte(password), decoded.salt, decoded.Iterations, decoded.Memory, decoded.Parallelism, decoded.KeyLength)
+
+	if subtle.ConstantTimeCompare(key, decoded.key) != 1 {
+		return ErrMismatch
+	}
+
+	return nil
+}
+
+func (a Argon2id) Current(hash string) bool {
+	decoded, err := decodeArgon2id(hash)
+
+	return err == nil && decoded.Argon2id == a
+}
+
+func decodeArgon2id(hash string) (*argon2idHash, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrMalformedHash
+	}
+
+	var version int
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrMalformedHash
+	}
+
+	var decoded argon2idHash
+
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &decoded.Memory, &decoded.Iterations, &decoded.Parallelism)
+
+	if err != nil || decoded.Iterations == 0 || decoded.Parallelism == 0 {
+		return nil, ErrMalformedHash
+	}
+
+	if decoded.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrMalformedHash
+	}
+
+	if decoded.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(decoded.key) == 0 {
+		return nil, ErrMalformedHash
+	}
+
+	decoded.SaltLength = uint32(len(decoded.salt))
+	decoded.KeyLength = uint32(len(decoded.key))
+
+	return &decoded, nil
+}
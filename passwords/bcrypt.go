@@ -0,0 +1,63 @@
+package passwords
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptMaxBytes is as much of a password as bcrypt looks at. It ignores
+// the rest.
+const bcryptMaxBytes = 72
+
+// Bcrypt hashes with bcrypt at Cost, in its own $2a$ format.
+type Bcrypt struct {
+	Cost int
+}
+
+// Hash refuses passwords longer than bcrypt looks at, rather than hashing
+// a shorter password than was given.
+func (b Bcrypt) Hash(password string) (string, error) {
+	if len(password) > bcryptMaxBytes {
+		return "", ErrTooLong
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashed), nil
+}
+
+func (b Bcrypt) Recognizes(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b Bcrypt) Verify(password string, hash string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	}
+
+	if err != nil {
+		return ErrMalformedHash
+	}
+
+	return nil
+}
+
+func (b Bcrypt) Current(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+
+	return err == nil && cost == b.Cost
+}
@@ -0,0 +1,82 @@
+// Package passwords hashes passwords for storage and checks passwords
+// against stored hashes. New hashes are made with one preferred scheme, and
+// hashes of the others are still checked, so the scheme or its parameters
+// can change without locking anybody out.
+package passwords
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrMismatch      = errors.New("password does not match")
+	ErrUnknownScheme = errors.New("password hash was not made by a known scheme")
+	ErrMalformedHash = errors.New("password hash is malformed")
+	ErrTooLong       = errors.New("password is too long")
+)
+
+// Scheme is one way of hashing passwords. Hashes are strings in the PHC
+// string format, $id$params$salt$hash, or the older format of the scheme
+// where it has its own, as bcrypt does.
+type Scheme interface {
+	Hash(password string) (string, error)
+	// Recognizes tells whether hash was made by the scheme.
+	Recognizes(hash string) bool
+	// Verify returns nil when password matches hash and ErrMismatch when it
+	// doesn't.
+	Verify(password string, hash string) error
+	// Current tells whether hash was made with the parameters the scheme
+	// hashes with now.
+	Current(hash string) bool
+}
+
+// Hasher hashes passwords with its preferred scheme and verifies hashes of
+// any of its schemes.
+type Hasher struct {
+	schemes []Scheme
+
+	dummyOnce sync.Once
+	dummy     string
+}
+
+// New returns a hasher making hashes with preferred. Hashes of others are
+// verified too, and reported for rehashing.
+func New(preferred Scheme, others ...Scheme) *Hasher {
+	return &Hasher{schemes: append([]Scheme{preferred}, others...)}
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.schemes[0].Hash(password)
+}
+
+// Verify checks password against hash. When it matches, rehash tells
+// whether hash should be replaced with a new hash of the password, because
+// it was made by another scheme than the preferred one or with other
+// parameters.
+func (h *Hasher) Verify(password string, hash string) (rehash bool, err error) {
+	for i, scheme := range h.schemes {
+		if !scheme.Recognizes(hash) {
+			continue
+		}
+
+		if err = scheme.Verify(password, hash); err != nil {
+			return false, err
+		}
+
+		return i > 0 || !scheme.Current(hash), nil
+	}
+
+	return false, ErrUnknownScheme
+}
+
+// VerifyDummy takes as long as verifying password against a hash of the
+// preferred scheme, and fails. It is for when there is no hash to verify,
+// so that a missing user takes as long to refuse as a wrong password.
+func (h *Hasher) VerifyDummy(password string) {
+	h.dummyOnce.Do(func() {
+		h.dummy, _ = h.Hash("dummy password")
+	})
+
+	h.Verify(password, h.dummy)
+}
@@ -0,0 +1,87 @@
+package passwords
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap keeps the tests fast. Real configurations use far more memory.
+var cheap = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2id(t *testing.T) {
+	hasher := New(cheap)
+	hash, err := hasher.Hash("correct horse")
+
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash = %q, want a PHC string of the parameters", hash)
+	}
+
+	if other, _ := hasher.Hash("correct horse"); other == hash {
+		t.Errorf("hashing twice gave the same hash, want a fresh salt each time")
+	}
+
+	if rehash, err := hasher.Verify("correct horse", hash); err != nil || rehash {
+		t.Errorf("Verify = %v, %v, want a match without rehash", rehash, err)
+	}
+
+	if _, err = hasher.Verify("battery staple", hash); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify of a wrong password: got %v, want ErrMismatch", err)
+	}
+
+	stronger := cheap
+	stronger.Iterations = 2
+
+	if rehash, err := New(stronger).Verify("correct horse", hash); err != nil || !rehash {
+		t.Errorf("Verify after raising the iterations = %v, %v, want a match to rehash", rehash, err)
+	}
+}
+
+func TestBcryptHashesAreUpgraded(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+
+	hasher := New(cheap, Bcrypt{Cost: bcrypt.MinCost})
+
+	if rehash, err := hasher.Verify("correct horse", string(legacy)); err != nil || !rehash {
+		t.Errorf("Verify of a bcrypt hash = %v, %v, want a match to rehash", rehash, err)
+	}
+
+	if _, err = hasher.Verify("battery staple", string(legacy)); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify of a wrong password: got %v, want ErrMismatch", err)
+	}
+
+	if _, err = New(cheap).Verify("correct horse", string(legacy)); !errors.Is(err, ErrUnknownScheme) {
+		t.Errorf("Verify without bcrypt: got %v, want ErrUnknownScheme", err)
+	}
+}
+
+func TestBcryptRefusesLongPasswords(t *testing.T) {
+	if _, err := (Bcrypt{Cost: bcrypt.MinCost}).Hash(strings.Repeat("a", 73)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Hash of 73 bytes: got %v, want ErrTooLong", err)
+	}
+}
+
+func TestMalformedHashes(t *testing.T) {
+	hasher := New(cheap)
+
+	for _, hash := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+	} {
+		if _, err := hasher.Verify("correct horse", hash); !errors.Is(err, ErrMalformedHash) {
+			t.Errorf("Verify against %q: got %v, want ErrMalformedHash", hash, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package problems writes error responses as RFC 7807 problem details, so
+// every failure has the same JSON shape whichever handler or middleware
+// produced it.
+package problems
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/passwords"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/validation"
+)
+
+const ContentType = "application/problem+json"
+
+// Problem is the body of every error response. Errors lists the rejected
+// fields of a request that failed validation.
+type Problem struct {
+	Type     string                  `json:"type"`
+	Title    string                  `json:"title"`
+	Status   int                     `json:"status"`
+	Detail   string                  `json:"detail,omitempty"`
+	Instance string                  `json:"instance,omitempty"`
+	Errors   []validation.FieldError `json:"errors,omitempty"`
+}
+
+// statuses maps known errors to the status they are reported with. The first
+// match wins, so the broad repository kinds come after the specific errors
+// that are kinds of them.
+var statuses = []struct {
+	err    error
+	status int
+}{
+	{repositories.ErrNotFound, http.StatusNotFound},
+	{models.ErrEmailNotVerified, http.StatusForbidden},
+	{repositories.ErrForbidden, http.StatusForbidden},
+	{models.ErrPermissionNotGranted, http.StatusForbidden},
+	{models.ErrFrozenByStaff, http.StatusForbidden},
+	{models.ErrScopeNotGranted, http.StatusForbidden},
+
+	{models.ErrRefreshTokenExpired, http.StatusUnauthorized},
+	{models.ErrRefreshTokenRevoked, http.StatusUnauthorized},
+	{models.ErrRefreshTokenReused, http.StatusUnauthorized},
+	{models.ErrMfaChallengeExpired, http.StatusUnauthorized},
+	{models.ErrMfaChallengeCompleted, http.StatusUnauthorized},
+	{models.ErrMfaChallengeLocked, http.StatusUnauthorized},
+	{models.ErrApiKeyExpired, http.StatusUnauthorized},
+	{models.ErrApiKeyRevoked, http.StatusUnauthorized},
+
+	{models.ErrIllegalTransition, http.StatusConflict},
+	{models.ErrTotpAlreadyEnabled, http.StatusConflict},
+	{models.ErrEmailAlreadyVerified, http.StatusConflict},
+	{models.ErrBankAccountNotEmpty, http.StatusConflict},
+	{models.ErrBankAccountHasHistory, http.StatusConflict},
+	{models.ErrCreditNotAllowed, http.StatusConflict},
+	{models.ErrDebitNotAllowed, http.StatusConflict},
+	{repositories.ErrConflict, http.StatusConflict},
+
+	{models.ErrInsufficientFunds, http.StatusUnprocessableEntity},
+	{models.ErrCurrencyMismatch, http.StatusUnprocessableEntity},
+	{models.ErrUnsupportedCurrency, http.StatusUnprocessableEntity},
+	{models.ErrInvalidAmount, http.StatusUnprocessableEntity},
+	{passwords.ErrTooLong, http.StatusUnprocessableEntity},
+	{models.ErrRoundingNecessary, http.StatusUnprocessableEntity},
+	{models.ErrMoneyOverflow, http.StatusUnprocessableEntity},
+	{models.ErrEmptyTransaction, http.StatusUnprocessableEntity},
+	{models.ErrInvalidEntryAmount, http.StatusUnprocessableEntity},
+	{models.ErrInvalidEntryDirection, http.StatusUnprocessableEntity},
+	{models.ErrUnbalancedTransaction, http.StatusUnprocessableEntity},
+	{models.ErrInvalidBankAccountState, http.StatusUnprocessableEntity},
+	{models.ErrTransitionReason, http.StatusUnprocessableEntity},
+	{models.ErrInvalidSweepTarget, http.StatusUnprocessableEntity},
+	{repositories.ErrValidation, http.StatusUnprocessableEntity},
+
+	{models.ErrUserTokenExpired, http.StatusBadRequest},
+	{models.ErrUserTokenUsed, http.StatusBadRequest},
+	{models.ErrInvalidCursor, http.StatusBadRequest},
+	{models.ErrInvalidPageLimit, http.StatusBadRequest},
+	{models.ErrInvalidBankAccountSort, http.StatusBadRequest},
+
+	{models.ErrLoginThrottled, http.StatusTooManyRequests},
+}
+
+// Status returns the HTTP status err should be reported with. Anything not
+// known is a 500.
+func Status(err error) int {
+	for _, known := range statuses {
+		if errors.Is(err, known.err) {
+			return known.status
+		}
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Write sends a problem with the given status and detail.
+func Write(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	write(w, newProblem(r, status, detail))
+}
+
+func newProblem(r *http.Request, status int, detail string) Problem {
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	}
+}
+
+func write(w http.ResponseWriter, problem Problem) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// WriteError reports err with the status Status picks. The text of unknown
+// errors can come straight from a driver, so it is logged and never sent.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	status := Status(err)
+
+	if status == http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		Write(w, r, status, "")
+
+		return
+	}
+
+	problem := newProblem(r, status, err.Error())
+
+	var validationErr *validation.Error
+
+	if errors.As(err, &validationErr) {
+		problem.Detail = "the request has invalid fields"
+		problem.Errors = validationErr.Fields
+	}
+
+	write(w, problem)
+}
@@ -0,0 +1,88 @@
+package problems
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/validation"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{repositories.ErrNotFound, http.StatusNotFound},
+		{fmt.Errorf("reading account: %w", repositories.ErrNotFound), http.StatusNotFound},
+		{repositories.ErrDuplicateEmail, http.StatusConflict},
+		{repositories.ErrDuplicateBankAccountName, http.StatusConflict},
+		{repositories.ErrForbidden, http.StatusForbidden},
+		{repositories.ErrValidation, http.StatusUnprocessableEntity},
+		{models.ErrInsufficientFunds, http.StatusUnprocessableEntity},
+		{fmt.Errorf("%w: active to pending", models.ErrIllegalTransition), http.StatusConflict},
+		{models.ErrInvalidCursor, http.StatusBadRequest},
+		{errors.New("pq: connection refused"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := Status(tt.err); got != tt.want {
+			t.Errorf("Status(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWriteErrorHidesInternalErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	WriteError(w, httptest.NewRequest(http.MethodGet, "/api/users/me", nil), errors.New("pq: password authentication failed"))
+
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("got status %d and content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var problem Problem
+
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Problem{Type: "about:blank", Title: "Internal Server Error", Status: 500, Instance: "/api/users/me"}
+
+	if !reflect.DeepEqual(problem, want) {
+		t.Errorf("got %+v, want %+v", problem, want)
+	}
+}
+
+func TestWriteErrorListsInvalidFields(t *testing.T) {
+	var v validation.Validator
+
+	v.Required("", "name")
+	v.MaxLength("too long", 3, "name")
+	v.Email("not-an-email", "email")
+
+	w := httptest.NewRecorder()
+
+	WriteError(w, httptest.NewRequest(http.MethodPost, "/api/users/sign-up", nil), v.Err())
+
+	var problem Problem
+
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []validation.FieldError{
+		{Field: "name", Message: "is required"},
+		{Field: "email", Message: "must be a valid email address"},
+	}
+
+	if w.Code != http.StatusUnprocessableEntity || !reflect.DeepEqual(problem.Errors, want) {
+		t.Errorf("got status %d and errors %+v, want 422 and %+v", w.Code, problem.Errors, want)
+	}
+}
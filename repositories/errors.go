@@ -0,0 +1,37 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// The broad kinds of failure a repository reports. Handlers map them to HTTP
+// statuses without knowing which backend is in use.
+var (
+	// ErrNotFound is returned for lookups that find nothing, including rows
+	// owned by another user. It also matches sql.ErrNoRows.
+	ErrNotFound   = &kindError{message: "not found", kind: sql.ErrNoRows}
+	ErrConflict   = errors.New("conflict")
+	ErrForbidden  = errors.New("forbidden")
+	ErrValidation = errors.New("validation failed")
+)
+
+var (
+	ErrDuplicateEmail           = &kindError{message: "there are a user with this email", kind: ErrConflict}
+	ErrDuplicateBankAccountName = &kindError{message: "there are a bank account with this user_id and name", kind: ErrConflict}
+)
+
+// kindError keeps its own message and matches, through errors.Is, the broader
+// error it is a kind of.
+type kindError struct {
+	message string
+	kind    error
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
@@ -2,24 +2,189 @@ package repositories
 
 import (
 	"context"
+	"time"
 
 	"github.com/pipeline1987/SVB/models"
 )
 
+// Implementations report lookups that find nothing, including rows owned by
+// another user, with ErrNotFound and duplicates with an ErrConflict.
+
 type Repository interface {
 	CreateUser(ctx context.Context, user *models.User) (*models.User, error)
 	ReadUser(ctx context.Context, id string) (*models.User, error)
 	ReadUserByEmail(ctx context.Context, email string) (*models.User, error)
-	CreateBankAccount(ctx context.Context, bankAccount *models.BankAccount) (*models.BankAccount, error)
+	// VerifyEmail marks the email address of the user verified. Verifying it
+	// again keeps the first time.
+	VerifyEmail(ctx context.Context, userId string, at time.Time) error
+	// ResetPassword replaces the password hash of the user and, in the same
+	// transaction, revokes everything that signs them in without it: their
+	// tokens and sessions, as RevokeAllTokens does, and their API keys.
+	ResetPassword(ctx context.Context, userId string, password string, at time.Time) error
+	// RehashPassword replaces the password hash of the user with password
+	// only while it is still current, so that a password changed meanwhile
+	// is kept. Otherwise it fails with ErrConflict.
+	RehashPassword(ctx context.Context, userId string, current string, password string) error
+	// SetUserRole changes the role of the user and bumps their token version,
+	// as access tokens carry the role. The change is audited as event, if
+	// any, in the same transaction.
+	SetUserRole(ctx context.Context, userId string, role models.Role, event *models.AuditEvent) error
+	// ListUsers lists every user in the order they signed up, audited as
+	// event, if any, in the same transaction.
+	ListUsers(ctx context.Context, page models.PageRequest, event *models.AuditEvent) ([]*models.User, models.Page, error)
+	CreateUserToken(ctx context.Context, token *models.UserToken) error
+	// UseUserToken uses up the token stored under tokenHash for purpose and
+	// returns it. Every other token the user holds for that purpose is used
+	// up with it, so an older email can't be acted on afterwards.
+	UseUserToken(
+		ctx context.Context,
+		purpose models.UserTokenPurpose,
+		tokenHash string,
+		at time.Time,
+	) (*models.UserToken, error)
+	// CreateBankAccount stores a pending bank account and, in the same
+	// transaction, moves it on with opening, if any.
+	CreateBankAccount(
+		ctx context.Context,
+		bankAccount *models.BankAccount,
+		opening *models.BankAccountTransition,
+	) (*models.BankAccount, error)
 	GetBankAccountById(ctx context.Context, id string, userId string) (*models.BankAccount, error)
+	// ReadBankAccount reads a bank account whoever owns it, audited as event,
+	// if any, in the same transaction. Only staff requests may use it.
+	ReadBankAccount(ctx context.Context, id string, event *models.AuditEvent) (*models.BankAccount, error)
+	// UpdateBankAccountById renames the bank account to bankAccount.Name,
+	// unless it is empty, and applies transition, if any, in the same
+	// transaction.
 	UpdateBankAccountById(
 		ctx context.Context,
 		id string,
 		userId string,
 		bankAccount *models.BankAccount,
+		transition *models.BankAccountTransition,
+	) (*models.BankAccount, error)
+	// TransitionBankAccount moves a bank account to transition.To, audited as
+	// event, if any, in the same transaction. Owners can't move an account
+	// staff froze out of frozen: that fails with models.ErrFrozenByStaff,
+	// here and wherever else an account changes state.
+	TransitionBankAccount(
+		ctx context.Context,
+		userId string,
+		transition *models.BankAccountTransition,
+		event *models.AuditEvent,
 	) (*models.BankAccount, error)
+	GetBankAccountTransitions(ctx context.Context, id string, userId string) ([]*models.BankAccountTransition, error)
+	CloseBankAccount(ctx context.Context, userId string, closure *models.BankAccountClosure) (*models.BankAccount, error)
 	DeleteBankAccountById(ctx context.Context, id string, userId string) error
-	GetAllBankAccountsByUserId(ctx context.Context, userId string) ([]*models.BankAccount, error)
+	GetAllBankAccountsByUserId(
+		ctx context.Context,
+		userId string,
+		filter *models.BankAccountFilter,
+		page models.PageRequest,
+	) ([]*models.BankAccount, models.Page, error)
+	CreateTransaction(ctx context.Context, transaction *models.Transaction) (*models.Transaction, error)
+	CreateTransactionForUser(
+		ctx context.Context,
+		userId string,
+		transaction *models.Transaction,
+	) (*models.Transaction, error)
+	GetBankAccountTransactions(
+		ctx context.Context,
+		userId string,
+		filter *models.TransactionFilter,
+		page models.PageRequest,
+	) ([]*models.AccountTransaction, models.Page, error)
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	// RotateRefreshToken uses up the token stored under tokenHash and saves
+	// next in its place, in the same family and for the same user. Using a
+	// token twice revokes its whole family and fails with
+	// models.ErrRefreshTokenReused.
+	RotateRefreshToken(ctx context.Context, tokenHash string, next *models.RefreshToken) (*models.RefreshToken, error)
+	CreateSession(ctx context.Context, session *models.Session) error
+	// GetSessionsByUserId lists the sessions of the user that are not
+	// revoked, most recently seen first.
+	GetSessionsByUserId(ctx context.Context, userId string) ([]*models.Session, error)
+	// TouchSession returns the session, moving its LastSeenAt to at when it
+	// is live and older than models.SessionTouchInterval.
+	TouchSession(ctx context.Context, id string, userId string, at time.Time) (*models.Session, error)
+	// RevokeSession revokes the session and its refresh tokens. Revoking a
+	// session twice is not an error.
+	RevokeSession(ctx context.Context, id string, userId string, at time.Time) error
+	// RevokeAccessToken denylists an access token until it expires. Revoking
+	// a token twice is not an error.
+	RevokeAccessToken(ctx context.Context, token *models.RevokedAccessToken) error
+	IsAccessTokenRevoked(ctx context.Context, id string) (bool, error)
+	// RevokeAllTokens bumps the token version of the user, which invalidates
+	// every access token issued so far, and revokes all of their sessions and
+	// refresh tokens.
+	RevokeAllTokens(ctx context.Context, userId string, at time.Time) error
+	// SaveTotpCredential stores a credential that is not confirmed yet, in
+	// place of any earlier unconfirmed one. It fails with
+	// models.ErrTotpAlreadyEnabled once the user has confirmed one.
+	SaveTotpCredential(ctx context.Context, credential *models.TotpCredential) error
+	GetTotpCredential(ctx context.Context, userId string) (*models.TotpCredential, error)
+	// ConfirmTotpCredential enables the credential of the user with the code
+	// of step and replaces their recovery codes with codes.
+	ConfirmTotpCredential(
+		ctx context.Context,
+		userId string,
+		step int64,
+		at time.Time,
+		codes []*models.RecoveryCode,
+	) error
+	// UseTotpCode records that the code of step was accepted for the enabled
+	// credential of the user. Codes of that step or earlier fail with
+	// models.ErrTotpCodeReused from then on.
+	UseTotpCode(ctx context.Context, userId string, step int64) error
+	// UseRecoveryCode uses up an unused recovery code of the user, or fails
+	// with ErrNotFound.
+	UseRecoveryCode(ctx context.Context, userId string, codeHash string, at time.Time) error
+	// DeleteTotpCredential disables two-factor authentication for the user
+	// and drops their recovery codes.
+	DeleteTotpCredential(ctx context.Context, userId string) error
+	CreateMfaChallenge(ctx context.Context, challenge *models.MfaChallenge) error
+	// AttemptMfaChallenge counts an attempt against the challenge stored
+	// under tokenHash and returns it, or the reason it can't be attempted.
+	AttemptMfaChallenge(ctx context.Context, tokenHash string, at time.Time) (*models.MfaChallenge, error)
+	// CompleteMfaChallenge marks the challenge done. Only one completion
+	// succeeds, later ones fail with models.ErrMfaChallengeCompleted.
+	CompleteMfaChallenge(ctx context.Context, id string, at time.Time) error
+	GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error)
+	// RecordLoginFailure counts a failed sign in against key at at under
+	// policy and returns the throttle as it stands after it. When the failure
+	// leaves the key locked out, lockout, if any, is audited in the same
+	// transaction.
+	RecordLoginFailure(
+		ctx context.Context,
+		key string,
+		at time.Time,
+		policy models.LoginPolicy,
+		lockout *models.AuditEvent,
+	) (*models.LoginThrottle, error)
+	// ClearLoginThrottle forgets the failures of key, audited as event, if
+	// any, in the same transaction. Clearing a key without any is not an
+	// error.
+	ClearLoginThrottle(ctx context.Context, key string, event *models.AuditEvent) error
+	CreateAuditEvent(ctx context.Context, event *models.AuditEvent) error
+	CreateSigningKey(ctx context.Context, key *models.SigningKey) error
+	// GetSigningKeys lists the signing keys that have not expired at at, in
+	// the order they become active.
+	GetSigningKeys(ctx context.Context, at time.Time) ([]*models.SigningKey, error)
+	// CreateApiKey stores key, audited as event, if any, in the same
+	// transaction.
+	CreateApiKey(ctx context.Context, key *models.ApiKey, event *models.AuditEvent) error
+	// GetApiKeysByUserId lists the API keys of the user that are not
+	// revoked, newest first. Expired keys are listed until they are revoked.
+	GetApiKeysByUserId(ctx context.Context, userId string) ([]*models.ApiKey, error)
+	GetApiKey(ctx context.Context, id string, userId string) (*models.ApiKey, error)
+	RenameApiKey(ctx context.Context, id string, userId string, name string) (*models.ApiKey, error)
+	// RevokeApiKey stops the key from working, audited as event, if any, in
+	// the same transaction. Revoking a revoked key is not an error.
+	RevokeApiKey(ctx context.Context, id string, userId string, at time.Time, event *models.AuditEvent) error
+	// UseApiKey returns the key stored under keyHash, revoked or not, moving
+	// its LastUsedAt to at when it is live and older than
+	// models.ApiKeyTouchInterval.
+	UseApiKey(ctx context.Context, keyHash string, at time.Time) (*models.ApiKey, error)
 	Close() error
 }
 
@@ -41,24 +206,246 @@ func ReadUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	return implementation.ReadUserByEmail(ctx, email)
 }
 
-func CreateBankAccount(ctx context.Context, bankAccount *models.BankAccount) (*models.BankAccount, error) {
-	return implementation.CreateBankAccount(ctx, bankAccount)
+func VerifyEmail(ctx context.Context, userId string, at time.Time) error {
+	return implementation.VerifyEmail(ctx, userId, at)
+}
+
+func ResetPassword(ctx context.Context, userId string, password string, at time.Time) error {
+	return implementation.ResetPassword(ctx, userId, password, at)
+}
+
+func RehashPassword(ctx context.Context, userId string, current string, password string) error {
+	return implementation.RehashPassword(ctx, userId, current, password)
+}
+
+func SetUserRole(ctx context.Context, userId string, role models.Role, event *models.AuditEvent) error {
+	return implementation.SetUserRole(ctx, userId, role, event)
+}
+
+func ListUsers(ctx context.Context, page models.PageRequest, event *models.AuditEvent) ([]*models.User, models.Page, error) {
+	return implementation.ListUsers(ctx, page, event)
+}
+
+func CreateUserToken(ctx context.Context, token *models.UserToken) error {
+	return implementation.CreateUserToken(ctx, token)
+}
+
+func UseUserToken(
+	ctx context.Context,
+	purpose models.UserTokenPurpose,
+	tokenHash string,
+	at time.Time,
+) (*models.UserToken, error) {
+	return implementation.UseUserToken(ctx, purpose, tokenHash, at)
+}
+
+func CreateBankAccount(
+	ctx context.Context,
+	bankAccount *models.BankAccount,
+	opening *models.BankAccountTransition,
+) (*models.BankAccount, error) {
+	return implementation.CreateBankAccount(ctx, bankAccount, opening)
 }
 
 func GetBankAccountById(ctx context.Context, id string, userId string) (*models.BankAccount, error) {
 	return implementation.GetBankAccountById(ctx, id, userId)
 }
 
-func UpdateBankAccountById(ctx context.Context, id string, userId string, bankAccount *models.BankAccount) (*models.BankAccount, error) {
-	return implementation.UpdateBankAccountById(ctx, id, userId, bankAccount)
+func ReadBankAccount(ctx context.Context, id string, event *models.AuditEvent) (*models.BankAccount, error) {
+	return implementation.ReadBankAccount(ctx, id, event)
+}
+
+func UpdateBankAccountById(
+	ctx context.Context,
+	id string,
+	userId string,
+	bankAccount *models.BankAccount,
+	transition *models.BankAccountTransition,
+) (*models.BankAccount, error) {
+	return implementation.UpdateBankAccountById(ctx, id, userId, bankAccount, transition)
+}
+
+func TransitionBankAccount(
+	ctx context.Context,
+	userId string,
+	transition *models.BankAccountTransition,
+	event *models.AuditEvent,
+) (*models.BankAccount, error) {
+	return implementation.TransitionBankAccount(ctx, userId, transition, event)
+}
+
+func GetBankAccountTransitions(ctx context.Context, id string, userId string) ([]*models.BankAccountTransition, error) {
+	return implementation.GetBankAccountTransitions(ctx, id, userId)
+}
+
+func CloseBankAccount(ctx context.Context, userId string, closure *models.BankAccountClosure) (*models.BankAccount, error) {
+	return implementation.CloseBankAccount(ctx, userId, closure)
 }
 
 func DeleteBankAccountById(ctx context.Context, id string, userId string) error {
 	return implementation.DeleteBankAccountById(ctx, id, userId)
 }
 
-func GetAllBankAccountsByUserId(ctx context.Context, userId string) ([]*models.BankAccount, error) {
-	return implementation.GetAllBankAccountsByUserId(ctx, userId)
+func GetAllBankAccountsByUserId(
+	ctx context.Context,
+	userId string,
+	filter *models.BankAccountFilter,
+	page models.PageRequest,
+) ([]*models.BankAccount, models.Page, error) {
+	return implementation.GetAllBankAccountsByUserId(ctx, userId, filter, page)
+}
+
+func CreateTransaction(ctx context.Context, transaction *models.Transaction) (*models.Transaction, error) {
+	return implementation.CreateTransaction(ctx, transaction)
+}
+
+func CreateTransactionForUser(
+	ctx context.Context,
+	userId string,
+	transaction *models.Transaction,
+) (*models.Transaction, error) {
+	return implementation.CreateTransactionForUser(ctx, userId, transaction)
+}
+
+func GetBankAccountTransactions(
+	ctx context.Context,
+	userId string,
+	filter *models.TransactionFilter,
+	page models.PageRequest,
+) ([]*models.AccountTransaction, models.Page, error) {
+	return implementation.GetBankAccountTransactions(ctx, userId, filter, page)
+}
+
+func CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	return implementation.CreateRefreshToken(ctx, token)
+}
+
+func RotateRefreshToken(ctx context.Context, tokenHash string, next *models.RefreshToken) (*models.RefreshToken, error) {
+	return implementation.RotateRefreshToken(ctx, tokenHash, next)
+}
+
+func CreateSession(ctx context.Context, session *models.Session) error {
+	return implementation.CreateSession(ctx, session)
+}
+
+func GetSessionsByUserId(ctx context.Context, userId string) ([]*models.Session, error) {
+	return implementation.GetSessionsByUserId(ctx, userId)
+}
+
+func TouchSession(ctx context.Context, id string, userId string, at time.Time) (*models.Session, error) {
+	return implementation.TouchSession(ctx, id, userId, at)
+}
+
+func RevokeSession(ctx context.Context, id string, userId string, at time.Time) error {
+	return implementation.RevokeSession(ctx, id, userId, at)
+}
+
+func RevokeAccessToken(ctx context.Context, token *models.RevokedAccessToken) error {
+	return implementation.RevokeAccessToken(ctx, token)
+}
+
+func IsAccessTokenRevoked(ctx context.Context, id string) (bool, error) {
+	return implementation.IsAccessTokenRevoked(ctx, id)
+}
+
+func RevokeAllTokens(ctx context.Context, userId string, at time.Time) error {
+	return implementation.RevokeAllTokens(ctx, userId, at)
+}
+
+func SaveTotpCredential(ctx context.Context, credential *models.TotpCredential) error {
+	return implementation.SaveTotpCredential(ctx, credential)
+}
+
+func GetTotpCredential(ctx context.Context, userId string) (*models.TotpCredential, error) {
+	return implementation.GetTotpCredential(ctx, userId)
+}
+
+func ConfirmTotpCredential(
+	ctx context.Context,
+	userId string,
+	step int64,
+	at time.Time,
+	codes []*models.RecoveryCode,
+) error {
+	return implementation.ConfirmTotpCredential(ctx, userId, step, at, codes)
+}
+
+func UseTotpCode(ctx context.Context, userId string, step int64) error {
+	return implementation.UseTotpCode(ctx, userId, step)
+}
+
+func UseRecoveryCode(ctx context.Context, userId string, codeHash string, at time.Time) error {
+	return implementation.UseRecoveryCode(ctx, userId, codeHash, at)
+}
+
+func DeleteTotpCredential(ctx context.Context, userId string) error {
+	return implementation.DeleteTotpCredential(ctx, userId)
+}
+
+func CreateMfaChallenge(ctx context.Context, challenge *models.MfaChallenge) error {
+	return implementation.CreateMfaChallenge(ctx, challenge)
+}
+
+func AttemptMfaChallenge(ctx context.Context, tokenHash string, at time.Time) (*models.MfaChallenge, error) {
+	return implementation.AttemptMfaChallenge(ctx, tokenHash, at)
+}
+
+func CompleteMfaChallenge(ctx context.Context, id string, at time.Time) error {
+	return implementation.CompleteMfaChallenge(ctx, id, at)
+}
+
+func GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	return implementation.GetLoginThrottle(ctx, key)
+}
+
+func RecordLoginFailure(
+	ctx context.Context,
+	key string,
+	at time.Time,
+	policy models.LoginPolicy,
+	lockout *models.AuditEvent,
+) (*models.LoginThrottle, error) {
+	return implementation.RecordLoginFailure(ctx, key, at, policy, lockout)
+}
+
+func ClearLoginThrottle(ctx context.Context, key string, event *models.AuditEvent) error {
+	return implementation.ClearLoginThrottle(ctx, key, event)
+}
+
+func CreateAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	return implementation.CreateAuditEvent(ctx, event)
+}
+
+func CreateSigningKey(ctx context.Context, key *models.SigningKey) error {
+	return implementation.CreateSigningKey(ctx, key)
+}
+
+func GetSigningKeys(ctx context.Context, at time.Time) ([]*models.SigningKey, error) {
+	return implementation.GetSigningKeys(ctx, at)
+}
+
+func CreateApiKey(ctx context.Context, key *models.ApiKey, event *models.AuditEvent) error {
+	return implementation.CreateApiKey(ctx, key, event)
+}
+
+func GetApiKeysByUserId(ctx context.Context, userId string) ([]*models.ApiKey, error) {
+	return implementation.GetApiKeysByUserId(ctx, userId)
+}
+
+func GetApiKey(ctx context.Context, id string, userId string) (*models.ApiKey, error) {
+	return implementation.GetApiKey(ctx, id, userId)
+}
+
+func RenameApiKey(ctx context.Context, id string, userId string, name string) (*models.ApiKey, error) {
+	return implementation.RenameApiKey(ctx, id, userId, name)
+}
+
+func RevokeApiKey(ctx context.Context, id string, userId string, at time.Time, event *models.AuditEvent) error {
+	return implementation.RevokeApiKey(ctx, id, userId, at, event)
+}
+
+func UseApiKey(ctx context.Context, keyHash string, at time.Time) (*models.ApiKey, error) {
+	return implementation.UseApiKey(ctx, keyHash, at)
 }
 
 func Close() error {
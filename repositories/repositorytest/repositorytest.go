@@ -0,0 +1,1864 @@
+// Package repositorytest holds the contract every repositories.Repository
+// has to honour. Each backend runs the same suite from its own tests, so
+// handlers behave the same whichever one DB_HOST picks.
+//
+// The suite creates its own users, with fresh ids and emails, and only looks
+// at their data. It can therefore run against a database that already holds
+// rows, such as a shared local Postgres.
+package repositorytest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/segmentio/ksuid"
+)
+
+// Factory returns an empty or at least isolated repository for one test. It
+// should register its own cleanup with t.Cleanup.
+type Factory func(t *testing.T) repositories.Repository
+
+// Run checks the repository returned by newRepository against the contract.
+func Run(t *testing.T, newRepository Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo repositories.Repository)
+	}{
+		{"CreateUser", testCreateUser},
+		{"DuplicateEmail", testDuplicateEmail},
+		{"BankAccountOwnership", testBankAccountOwnership},
+		{"DuplicateBankAccountName", testDuplicateBankAccountName},
+		{"OpenBankAccount", testOpenBankAccount},
+		{"UpdateBankAccount", testUpdateBankAccount},
+		{"DeleteBankAccount", testDeleteBankAccount},
+		{"ListBankAccounts", testListBankAccounts},
+		{"FilterBankAccountsByState", testFilterBankAccountsByState},
+		{"Transactions", testTransactions},
+		{"DebitOthersAccount", testDebitOthersAccount},
+		{"TransactionOfMissingAccount", testTransactionOfMissingAccount},
+		{"ConcurrentDebits", testConcurrentDebits},
+		{"Transitions", testTransitions},
+		{"StaffFreeze", testStaffFreeze},
+		{"CloseBankAccount", testCloseBankAccount},
+		{"RefreshTokens", testRefreshTokens},
+		{"TokenRevocation", testTokenRevocation},
+		{"Sessions", testSessions},
+		{"TotpCredentials", testTotpCredentials},
+		{"MfaChallenges", testMfaChallenges},
+		{"UserTokens", testUserTokens},
+		{"LoginThrottles", testLoginThrottles},
+		{"SigningKeys", testSigningKeys},
+		{"Roles", testRoles},
+		{"ListUsers", testListUsers},
+		{"ReadBankAccount", testReadBankAccount},
+		{"ApiKeys", testApiKeys},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepository(t))
+		})
+	}
+}
+
+func testCreateUser(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := newUser()
+	user.FullName = "Ada Lovelace"
+
+	saved, err := repo.CreateUser(ctx, user)
+
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if saved.Id != user.Id {
+		t.Errorf("CreateUser returned id %q, want %q", saved.Id, user.Id)
+	}
+
+	found, err := repo.ReadUser(ctx, user.Id)
+
+	if err != nil {
+		t.Fatalf("ReadUser: %v", err)
+	}
+
+	if found.Email != user.Email || found.FullName != user.FullName {
+		t.Errorf("ReadUser = %+v, want email %q and full name %q", found, user.Email, user.FullName)
+	}
+
+	byEmail, err := repo.ReadUserByEmail(ctx, user.Email)
+
+	if err != nil {
+		t.Fatalf("ReadUserByEmail: %v", err)
+	}
+
+	if byEmail.Id != user.Id || byEmail.Password != user.Password {
+		t.Errorf("ReadUserByEmail = %+v, want id %q and the stored password", byEmail, user.Id)
+	}
+
+	if _, err = repo.ReadUser(ctx, newId()); !errors.Is(err, repositories.ErrNotFound) || !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ReadUser of an unknown id: got %v, want ErrNotFound, which matches sql.ErrNoRows", err)
+	}
+
+	if _, err = repo.ReadUserByEmail(ctx, newId()+"@example.com"); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("ReadUserByEmail of an unknown email: got %v, want ErrNotFound", err)
+	}
+}
+
+func testDuplicateEmail(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+
+	duplicate := newUser()
+	duplicate.Email = user.Email
+
+	if _, err := repo.CreateUser(ctx, duplicate); !errors.Is(err, repositories.ErrDuplicateEmail) {
+		t.Fatalf("CreateUser with a taken email: got %v, want ErrDuplicateEmail", err)
+	}
+
+	if _, err := repo.ReadUser(ctx, duplicate.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("the rejected user was stored: ReadUser returned %v", err)
+	}
+}
+
+func testBankAccountOwnership(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	owner := createUser(t, repo)
+	stranger := createUser(t, repo)
+	bankAccount := createBankAccount(t, repo, owner.Id, "main")
+	deposit(t, repo, bankAccount.Id, 1000)
+
+	if _, err := repo.GetBankAccountById(ctx, bankAccount.Id, owner.Id); err != nil {
+		t.Fatalf("GetBankAccountById by the owner: %v", err)
+	}
+
+	if _, err := repo.GetBankAccountById(ctx, bankAccount.Id, stranger.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("GetBankAccountById by another user: got %v, want ErrNotFound", err)
+	}
+
+	_, err := repo.UpdateBankAccountById(ctx, bankAccount.Id, stranger.Id, &models.BankAccount{Name: "stolen"}, nil)
+
+	if !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("UpdateBankAccountById by another user: got %v, want ErrNotFound", err)
+	}
+
+	_, err = repo.TransitionBankAccount(ctx, stranger.Id, newTransition(bankAccount.Id, stranger.Id, models.BankAccountStateFrozen), nil)
+
+	if !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("TransitionBankAccount by another user: got %v, want ErrNotFound", err)
+	}
+
+	if err = repo.DeleteBankAccountById(ctx, bankAccount.Id, stranger.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("DeleteBankAccountById by another user: got %v, want ErrNotFound", err)
+	}
+
+	_, err = repo.CreateTransactionForUser(ctx, stranger.Id, newTransaction(
+		models.WithdrawalTransaction,
+		bankAccount.Id,
+		models.ExternalBankAccountId,
+		100,
+	))
+
+	if !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("CreateTransactionForUser debiting another user's account: got %v, want ErrNotFound", err)
+	}
+
+	bankAccounts, _, err := repo.GetAllBankAccountsByUserId(ctx, stranger.Id, &models.BankAccountFilter{}, firstPage(10))
+
+	if err != nil {
+		t.Fatalf("GetAllBankAccountsByUserId: %v", err)
+	}
+
+	if len(bankAccounts) != 0 {
+		t.Errorf("GetAllBankAccountsByUserId listed %d accounts of another user", len(bankAccounts))
+	}
+
+	transitions, err := repo.GetBankAccountTransitions(ctx, bankAccount.Id, stranger.Id)
+
+	if err != nil || len(transitions) != 0 {
+		t.Errorf("GetBankAccountTransitions by another user: got %d transitions and %v, want none", len(transitions), err)
+	}
+
+	transactions, _, err := repo.GetBankAccountTransactions(
+		ctx,
+		stranger.Id,
+		&models.TransactionFilter{BankAccountId: bankAccount.Id},
+		firstPage(10),
+	)
+
+	if err != nil || len(transactions) != 0 {
+		t.Errorf("GetBankAccountTransactions by another user: got %d transactions and %v, want none", len(transactions), err)
+	}
+
+	found, err := repo.GetBankAccountById(ctx, bankAccount.Id, owner.Id)
+
+	if err != nil {
+		t.Fatalf("GetBankAccountById by the owner: %v", err)
+	}
+
+	if found.Name != "main" || found.State != models.BankAccountStateActive || found.Balance.Amount != 1000 {
+		t.Errorf("another user changed the account: %+v", found)
+	}
+}
+
+func testDuplicateBankAccountName(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	other := createUser(t, repo)
+	createBankAccount(t, repo, user.Id, "main")
+	savings := createBankAccount(t, repo, user.Id, "savings")
+
+	if _, err := repo.CreateBankAccount(ctx, newBankAccount(user.Id, "main"), nil); !errors.Is(err, repositories.ErrDuplicateBankAccountName) {
+		t.Errorf("CreateBankAccount with a taken name: got %v, want ErrDuplicateBankAccountName", err)
+	}
+
+	_, err := repo.UpdateBankAccountById(ctx, savings.Id, user.Id, &models.BankAccount{Name: "main"}, nil)
+
+	if !errors.Is(err, repositories.ErrDuplicateBankAccountName) {
+		t.Errorf("UpdateBankAccountById to a taken name: got %v, want ErrDuplicateBankAccountName", err)
+	}
+
+	if _, err = repo.CreateBankAccount(ctx, newBankAccount(other.Id, "main"), nil); err != nil {
+		t.Errorf("CreateBankAccount with a name another user took: %v", err)
+	}
+}
+
+func testUpdateBankAccount(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	bankAccount := createBankAccount(t, repo, user.Id, "main")
+
+	updated, err := repo.UpdateBankAccountById(ctx, bankAccount.Id, user.Id, &models.BankAccount{Name: "renamed"}, nil)
+
+	if err != nil {
+		t.Fatalf("UpdateBankAccountById: %v", err)
+	}
+
+	if updated.Id != bankAccount.Id || updated.Name != "renamed" {
+		t.Errorf("UpdateBankAccountById = %+v, want the account renamed", updated)
+	}
+
+	if updated.State != models.BankAccountStateActive || updated.Currency != models.DefaultCurrency {
+		t.Errorf("UpdateBankAccountById changed more than the name: %+v", updated)
+	}
+
+	_, err = repo.UpdateBankAccountById(ctx, newId(), user.Id, &models.BankAccount{Name: "ghost"}, nil)
+
+	if !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("UpdateBankAccountById of an unknown id: got %v, want ErrNotFound", err)
+	}
+
+	frozen := newTransition(bankAccount.Id, user.Id, models.BankAccountStateFrozen)
+	updated, err = repo.UpdateBankAccountById(ctx, bankAccount.Id, user.Id, &models.BankAccount{Name: "frozen"}, frozen)
+
+	if err != nil {
+		t.Fatalf("UpdateBankAccountById with a transition: %v", err)
+	}
+
+	if updated.Name != "frozen" || updated.State != models.BankAccountStateFrozen {
+		t.Errorf("UpdateBankAccountById with a transition = %+v, want it renamed and frozen", updated)
+	}
+
+	// An illegal transition keeps the rename made with it from happening.
+	pending := newTransition(bankAccount.Id, user.Id, models.BankAccountStatePending)
+	_, err = repo.UpdateBankAccountById(ctx, bankAccount.Id, user.Id, &models.BankAccount{Name: "pending"}, pending)
+
+	if !errors.Is(err, models.ErrIllegalTransition) {
+		t.Errorf("UpdateBankAccountById back to pending: got %v, want ErrIllegalTransition", err)
+	}
+
+	if found, err := repo.GetBankAccountById(ctx, bankAccount.Id, user.Id); err != nil || found.Name != "frozen" {
+		t.Errorf("GetBankAccountById after a failed update = %+v, %v, want the name kept", found, err)
+	}
+
+	// Nor does a taken name let the transition through.
+	createBankAccount(t, repo, user.Id, "taken")
+	active := newTransition(bankAccount.Id, user.Id, models.BankAccountStateActive)
+	_, err = repo.UpdateBankAccountById(ctx, bankAccount.Id, user.Id, &models.BankAccount{Name: "taken"}, active)
+
+	if !errors.Is(err, repositories.ErrDuplicateBankAccountName) {
+		t.Errorf("UpdateBankAccountById to a taken name: got %v, want ErrDuplicateBankAccountName", err)
+	}
+
+	if found, err := repo.GetBankAccountById(ctx, bankAccount.Id, user.Id); err != nil || found.State != models.BankAccountStateFrozen {
+		t.Errorf("GetBankAccountById after a failed update = %+v, %v, want it still frozen", found, err)
+	}
+}
+
+func testOpenBankAccount(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	bankAccount := createBankAccount(t, repo, user.Id, "main")
+
+	found, err := repo.GetBankAccountById(ctx, bankAccount.Id, user.Id)
+
+	if err != nil || found.State != models.BankAccountStateActive {
+		t.Fatalf("GetBankAccountById of an opened account = %+v, %v, want it active", found, err)
+	}
+
+	transitions, err := repo.GetBankAccountTransitions(ctx, bankAccount.Id, user.Id)
+
+	if err != nil || len(transitions) != 1 || transitions[0].From != models.BankAccountStatePending {
+		t.Errorf("GetBankAccountTransitions of an opened account = %d, %v, want the opening from pending", len(transitions), err)
+	}
+
+	// An opening that can't be made leaves no account behind.
+	unopened := newBankAccount(user.Id, "unopened")
+	opening := newTransition(unopened.Id, user.Id, models.BankAccountStatePending)
+
+	if _, err = repo.CreateBankAccount(ctx, unopened, opening); !errors.Is(err, models.ErrIllegalTransition) {
+		t.Errorf("CreateBankAccount with an illegal opening: got %v, want ErrIllegalTransition", err)
+	}
+
+	if _, err = repo.GetBankAccountById(ctx, unopened.Id, user.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("GetBankAccountById of an account that failed to open: got %v, want ErrNotFound", err)
+	}
+}
+
+func testDeleteBankAccount(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	empty := createBankAccount(t, repo, user.Id, "empty")
+	used := createBankAccount(t, repo, user.Id, "used")
+	deposit(t, repo, used.Id, 500)
+
+	if err := repo.DeleteBankAccountById(ctx, newId(), user.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("DeleteBankAccountById of an unknown id: got %v, want ErrNotFound", err)
+	}
+
+	if err := repo.DeleteBankAccountById(ctx, used.Id, user.Id); !errors.Is(err, models.ErrBankAccountHasHistory) {
+		t.Errorf("DeleteBankAccountById of an account with history: got %v, want ErrBankAccountHasHistory", err)
+	}
+
+	if err := repo.DeleteBankAccountById(ctx, empty.Id, user.Id); err != nil {
+		t.Fatalf("DeleteBankAccountById: %v", err)
+	}
+
+	if _, err := repo.GetBankAccountById(ctx, empty.Id, user.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("GetBankAccountById after delete: got %v, want ErrNotFound", err)
+	}
+
+	if err := repo.DeleteBankAccountById(ctx, empty.Id, user.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("DeleteBankAccountById twice: got %v, want ErrNotFound", err)
+	}
+}
+
+// testListBankAccounts walks every sort order a page at a time, forward and
+// then back, and expects each walk to see the accounts exactly once and in
+// order. Names and balances repeat so the id has to break ties.
+func testListBankAccounts(t *testing.T, repo repositories.Repository) {
+	user := createUser(t, repo)
+
+	var bankAccounts []*models.BankAccount
+
+	for i, name := range []string{"travel", "bills", "savings", "Rent", "food"} {
+		bankAccount := createBankAccount(t, repo, user.Id, name)
+
+		if amount := int64(i%3) * 250; amount > 0 {
+			deposit(t, repo, bankAccount.Id, amount)
+			bankAccount.Balance.Amount = amount
+		}
+
+		bankAccounts = append(bankAccounts, bankAccount)
+	}
+
+	for _, sortBy := range []string{models.SortBankAccountsByCreated, models.SortBankAccountsByName, models.SortBankAccountsByBalance} {
+		for _, descending := range []bool{false, true} {
+			filter := &models.BankAccountFilter{Sort: sortBy, Descending: descending}
+
+			t.Run(sortBy+"/"+strconv.FormatBool(descending), func(t *testing.T) {
+				want := sortBankAccounts(bankAccounts, filter)
+				forward, last := walkBankAccounts(t, repo, user.Id, filter, "", true)
+
+				assertIds(t, "forward", forward, want)
+
+				backward, _ := walkBankAccounts(t, repo, user.Id, filter, last, false)
+
+				assertIds(t, "backward", backward, want)
+			})
+		}
+	}
+}
+
+func testFilterBankAccountsByState(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	createBankAccount(t, repo, user.Id, "main")
+	frozen := createBankAccount(t, repo, user.Id, "frozen")
+
+	if _, err := repo.TransitionBankAccount(ctx, user.Id, newTransition(frozen.Id, user.Id, models.BankAccountStateFrozen), nil); err != nil {
+		t.Fatalf("TransitionBankAccount: %v", err)
+	}
+
+	bankAccounts, _, err := repo.GetAllBankAccountsByUserId(
+		ctx,
+		user.Id,
+		&models.BankAccountFilter{State: models.BankAccountStateFrozen},
+		firstPage(10),
+	)
+
+	if err != nil {
+		t.Fatalf("GetAllBankAccountsByUserId: %v", err)
+	}
+
+	if len(bankAccounts) != 1 || bankAccounts[0].Id != frozen.Id {
+		t.Errorf("GetAllBankAccountsByUserId filtered by state returned %d accounts, want only the frozen one", len(bankAccounts))
+	}
+}
+
+func testTransactions(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	main := createBankAccount(t, repo, user.Id, "main")
+	savings := createBankAccount(t, repo, user.Id, "savings")
+
+	var entryIds []string
+
+	entryIds = append(entryIds, deposit(t, repo, main.Id, 1000).Entries[1].Id)
+
+	transfer := newTransaction(models.TransferTransaction, main.Id, savings.Id, 300)
+
+	if _, err := repo.CreateTransactionForUser(ctx, user.Id, transfer); err != nil {
+		t.Fatalf("CreateTransactionForUser: %v", err)
+	}
+
+	entryIds = append(entryIds, transfer.Entries[0].Id)
+
+	overdraft := newTransaction(models.WithdrawalTransaction, main.Id, models.ExternalBankAccountId, 701)
+
+	if _, err := repo.CreateTransactionForUser(ctx, user.Id, overdraft); !errors.Is(err, models.ErrInsufficientFunds) {
+		t.Errorf("CreateTransactionForUser overdrawing the account: got %v, want ErrInsufficientFunds", err)
+	}
+
+	assertBalance(t, repo, user.Id, main.Id, 700)
+	assertBalance(t, repo, user.Id, savings.Id, 300)
+
+	transactions, _, err := repo.GetBankAccountTransactions(
+		ctx,
+		user.Id,
+		&models.TransactionFilter{BankAccountId: main.Id},
+		firstPage(10),
+	)
+
+	if err != nil {
+		t.Fatalf("GetBankAccountTransactions: %v", err)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(entryIds)))
+
+	var got []string
+
+	for _, transaction := range transactions {
+		got = append(got, transaction.EntryId)
+	}
+
+	if !equal(got, entryIds) {
+		t.Errorf("GetBankAccountTransactions returned entries %v, want %v newest first", got, entryIds)
+	}
+
+	debits, _, err := repo.GetBankAccountTransactions(
+		ctx,
+		user.Id,
+		&models.TransactionFilter{BankAccountId: main.Id, Direction: models.DebitEntry},
+		firstPage(10),
+	)
+
+	if err != nil {
+		t.Fatalf("GetBankAccountTransactions: %v", err)
+	}
+
+	if len(debits) != 1 || debits[0].TransactionId != transfer.Id || debits[0].Amount.Amount != 300 {
+		t.Errorf("GetBankAccountTransactions filtered by direction returned %d entries, want the transfer only", len(debits))
+	}
+}
+
+// testDebitOthersAccount checks that debiting an account of another user
+// fails as if it didn't exist, before its state or currency can show.
+func testDebitOthersAccount(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	other := createUser(t, repo)
+	main := createBankAccount(t, repo, user.Id, "main")
+	theirs := createBankAccount(t, repo, other.Id, "main")
+	deposit(t, repo, theirs.Id, 500)
+
+	if _, err := repo.TransitionBankAccount(ctx, other.Id, newTransition(theirs.Id, other.Id, models.BankAccountStateFrozen), nil); err != nil {
+		t.Fatalf("TransitionBankAccount to frozen: %v", err)
+	}
+
+	transfer := newTransaction(models.TransferTransaction, theirs.Id, main.Id, 100)
+
+	if _, err := repo.CreateTransactionForUser(ctx, user.Id, transfer); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("CreateTransactionForUser from a frozen account of another user: got %v, want ErrNotFound", err)
+	}
+
+	assertBalance(t, repo, other.Id, theirs.Id, 500)
+}
+
+func testTransactionOfMissingAccount(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	main := createBankAccount(t, repo, user.Id, "main")
+	deposit(t, repo, main.Id, 500)
+	missing := newId()
+
+	transactions := map[string]*models.Transaction{
+		"deposit":       newTransaction(models.DepositTransaction, models.ExternalBankAccountId, missing, 100),
+		"withdrawal":    newTransaction(models.WithdrawalTransaction, missing, models.ExternalBankAccountId, 100),
+		"transfer from": newTransaction(models.TransferTransaction, missing, main.Id, 100),
+		"transfer to":   newTransaction(models.TransferTransaction, main.Id, missing, 100),
+	}
+
+	for name, transaction := range transactions {
+		if _, err := repo.CreateTransactionForUser(ctx, user.Id, transaction); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("CreateTransactionForUser, %s a missing account: got %v, want ErrNotFound", name, err)
+		}
+	}
+
+	assertBalance(t, repo, user.Id, main.Id, 500)
+}
+
+// testConcurrentDebits races withdrawals from one account. Each either goes
+// through or fails for lack of funds, and the balance ends up as the
+// opening balance less the ones that went through.
+func testConcurrentDebits(t *testing.T, repo repositories.Repository) {
+	const (
+		opening     = 1000
+		amount      = 100
+		withdrawals = 25
+	)
+
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	main := createBankAccount(t, repo, user.Id, "main")
+	deposit(t, repo, main.Id, opening)
+
+	var wait sync.WaitGroup
+	errs := make(chan error, withdrawals)
+
+	for i := 0; i < withdrawals; i++ {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			withdrawal := newTransaction(models.WithdrawalTransaction, main.Id, models.ExternalBankAccountId, amount)
+			_, err := repo.CreateTransactionForUser(ctx, user.Id, withdrawal)
+
+			errs <- err
+		}()
+	}
+
+	wait.Wait()
+	close(errs)
+
+	succeeded := 0
+
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, models.ErrInsufficientFunds):
+			t.Errorf("CreateTransactionForUser: got %v, want nil or ErrInsufficientFunds", err)
+		}
+	}
+
+	if succeeded*amount > opening {
+		t.Errorf("%d withdrawals of %d went through, overdrawing an opening balance of %d", succeeded, amount, opening)
+	}
+
+	assertBalance(t, repo, user.Id, main.Id, int64(opening-succeeded*amount))
+}
+
+func testStaffFreeze(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	staff := createUser(t, repo)
+	bankAccount := createBankAccount(t, repo, user.Id, "main")
+
+	if _, err := repo.TransitionBankAccount(ctx, user.Id, newTransition(bankAccount.Id, staff.Id, models.BankAccountStateFrozen), nil); err != nil {
+		t.Fatalf("TransitionBankAccount to frozen by staff: %v", err)
+	}
+
+	for _, to := range []models.BankAccountState{models.BankAccountStateActive, models.BankAccountStateClosed} {
+		_, err := repo.TransitionBankAccount(ctx, user.Id, newTransition(bankAccount.Id, user.Id, to), nil)
+
+		if !errors.Is(err, models.ErrFrozenByStaff) {
+			t.Errorf("TransitionBankAccount to %s by the owner: got %v, want ErrFrozenByStaff", to, err)
+		}
+	}
+
+	closure := &models.BankAccountClosure{Transition: newTransition(bankAccount.Id, user.Id, models.BankAccountStateClosed)}
+
+	if _, err := repo.CloseBankAccount(ctx, user.Id, closure); !errors.Is(err, models.ErrFrozenByStaff) {
+		t.Errorf("CloseBankAccount by the owner: got %v, want ErrFrozenByStaff", err)
+	}
+
+	if _, err := repo.TransitionBankAccount(ctx, user.Id, newTransition(bankAccount.Id, staff.Id, models.BankAccountStateActive), nil); err != nil {
+		t.Fatalf("TransitionBankAccount to active by staff: %v", err)
+	}
+
+	// A freeze of the owner's own they can lift.
+	for _, to := range []models.BankAccountState{models.BankAccountStateFrozen, models.BankAccountStateActive} {
+		if _, err := repo.TransitionBankAccount(ctx, user.Id, newTransition(bankAccount.Id, user.Id, to), nil); err != nil {
+			t.Errorf("TransitionBankAccount to %s by the owner: %v", to, err)
+		}
+	}
+}
+
+func testTransitions(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	bankAccount := createBankAccount(t, repo, user.Id, "main")
+
+	actions := map[models.BankAccountState]string{
+		models.BankAccountStateFrozen: models.AuditBankAccountFrozen,
+		models.BankAccountStateActive: models.AuditBankAccountUnfrozen,
+	}
+
+	for _, to := range []models.BankAccountState{models.BankAccountStateFrozen, models.BankAccountStateActive} {
+		event := newAuditEvent(actions[to], bankAccount.Id)
+
+		if _, err := repo.TransitionBankAccount(ctx, user.Id, newTransition(bankAccount.Id, user.Id, to), event); err != nil {
+			t.Fatalf("TransitionBankAccount to %s: %v", to, err)
+		}
+	}
+
+	_, err := repo.TransitionBankAccount(ctx, user.Id, newTransition(bankAccount.Id, user.Id, models.BankAccountStatePending), nil)
+
+	if !errors.Is(err, models.ErrIllegalTransition) {
+		t.Errorf("TransitionBankAccount back to pending: got %v, want ErrIllegalTransition", err)
+	}
+
+	transitions, err := repo.GetBankAccountTransitions(ctx, bankAccount.Id, user.Id)
+
+	if err != nil {
+		t.Fatalf("GetBankAccountTransitions: %v", err)
+	}
+
+	want := []models.BankAccountState{
+		models.BankAccountStatePending,
+		models.BankAccountStateActive,
+		models.BankAccountStateFrozen,
+		models.BankAccountStateActive,
+	}
+
+	if len(transitions) != len(want)-1 {
+		t.Fatalf("GetBankAccountTransitions returned %d transitions, want %d", len(transitions), len(want)-1)
+	}
+
+	for i, transition := range transitions {
+		if transition.From != want[i] || transition.To != want[i+1] {
+			t.Errorf("transition %d went from %s to %s, want %s to %s", i, transition.From, transition.To, want[i], want[i+1])
+		}
+	}
+}
+
+func testCloseBankAccount(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	main := createBankAccount(t, repo, user.Id, "main")
+	savings := createBankAccount(t, repo, user.Id, "savings")
+	deposit(t, repo, savings.Id, 450)
+
+	_, err := repo.CloseBankAccount(ctx, user.Id, &models.BankAccountClosure{
+		Transition: newTransition(savings.Id, user.Id, models.BankAccountStateClosed),
+	})
+
+	if !errors.Is(err, models.ErrBankAccountNotEmpty) {
+		t.Errorf("CloseBankAccount of a funded account without a sweep: got %v, want ErrBankAccountNotEmpty", err)
+	}
+
+	closed, err := repo.CloseBankAccount(ctx, user.Id, &models.BankAccountClosure{
+		Transition: newTransition(savings.Id, user.Id, models.BankAccountStateClosed),
+		Sweep:      newTransaction(models.SweepTransaction, savings.Id, main.Id, 1),
+	})
+
+	if err != nil {
+		t.Fatalf("CloseBankAccount: %v", err)
+	}
+
+	if closed.State != models.BankAccountStateClosed || !closed.Balance.IsZero() {
+		t.Errorf("CloseBankAccount = %+v, want a closed and empty account", closed)
+	}
+
+	assertBalance(t, repo, user.Id, main.Id, 450)
+}
+
+func testRefreshTokens(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	first := &models.RefreshToken{
+		Id:        newId(),
+		FamilyId:  newId(),
+		UserId:    user.Id,
+		TokenHash: newId(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+
+	if err := repo.CreateRefreshToken(ctx, first); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	second, err := repo.RotateRefreshToken(ctx, first.TokenHash, newRefreshToken(now.Add(time.Minute)))
+
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+
+	if second.UserId != user.Id || second.FamilyId != first.FamilyId {
+		t.Errorf("RotateRefreshToken = %+v, want the user and family of the rotated token", second)
+	}
+
+	third, err := repo.RotateRefreshToken(ctx, second.TokenHash, newRefreshToken(now.Add(2*time.Minute)))
+
+	if err != nil {
+		t.Fatalf("RotateRefreshToken of the rotated token: %v", err)
+	}
+
+	_, err = repo.RotateRefreshToken(ctx, first.TokenHash, newRefreshToken(now.Add(3*time.Minute)))
+
+	if !errors.Is(err, models.ErrRefreshTokenReused) {
+		t.Errorf("RotateRefreshToken of a used token: got %v, want ErrRefreshTokenReused", err)
+	}
+
+	_, err = repo.RotateRefreshToken(ctx, third.TokenHash, newRefreshToken(now.Add(4*time.Minute)))
+
+	if !errors.Is(err, models.ErrRefreshTokenRevoked) {
+		t.Errorf("RotateRefreshToken after a reuse: got %v, want ErrRefreshTokenRevoked for the whole family", err)
+	}
+
+	_, err = repo.RotateRefreshToken(ctx, newId(), newRefreshToken(now))
+
+	if !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("RotateRefreshToken of an unknown token: got %v, want ErrNotFound", err)
+	}
+
+	expired := &models.RefreshToken{
+		Id:        newId(),
+		FamilyId:  newId(),
+		UserId:    user.Id,
+		TokenHash: newId(),
+		CreatedAt: now.Add(-2 * time.Hour),
+		ExpiresAt: now.Add(-time.Hour),
+	}
+
+	if err = repo.CreateRefreshToken(ctx, expired); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	_, err = repo.RotateRefreshToken(ctx, expired.TokenHash, newRefreshToken(now))
+
+	if !errors.Is(err, models.ErrRefreshTokenExpired) {
+		t.Errorf("RotateRefreshToken of an expired token: got %v, want ErrRefreshTokenExpired", err)
+	}
+}
+
+func testTokenRevocation(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	revoked := &models.RevokedAccessToken{
+		Id:        newId(),
+		UserId:    user.Id,
+		ExpiresAt: now.Add(time.Hour),
+		RevokedAt: now,
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := repo.RevokeAccessToken(ctx, revoked); err != nil {
+			t.Fatalf("RevokeAccessToken #%d: %v", i+1, err)
+		}
+	}
+
+	if ok, err := repo.IsAccessTokenRevoked(ctx, revoked.Id); err != nil || !ok {
+		t.Errorf("IsAccessTokenRevoked of a revoked token = %v, %v, want true", ok, err)
+	}
+
+	if ok, err := repo.IsAccessTokenRevoked(ctx, newId()); err != nil || ok {
+		t.Errorf("IsAccessTokenRevoked of another token = %v, %v, want false", ok, err)
+	}
+
+	session, token := createSession(t, repo, user.Id, now)
+
+	if err := repo.RevokeAllTokens(ctx, user.Id, now); err != nil {
+		t.Fatalf("RevokeAllTokens: %v", err)
+	}
+
+	read, err := repo.ReadUser(ctx, user.Id)
+
+	if err != nil {
+		t.Fatalf("ReadUser: %v", err)
+	}
+
+	if read.TokenVersion != user.TokenVersion+1 {
+		t.Errorf("TokenVersion after RevokeAllTokens = %d, want %d", read.TokenVersion, user.TokenVersion+1)
+	}
+
+	_, err = repo.RotateRefreshToken(ctx, token.TokenHash, newRefreshToken(now))
+
+	if !errors.Is(err, models.ErrRefreshTokenRevoked) {
+		t.Errorf("RotateRefreshToken after RevokeAllTokens: got %v, want ErrRefreshTokenRevoked", err)
+	}
+
+	if touched, err := repo.TouchSession(ctx, session.Id, user.Id, now); err != nil || touched.RevokedAt == nil {
+		t.Errorf("TouchSession after RevokeAllTokens = %+v, %v, want a revoked session", touched, err)
+	}
+
+	if err = repo.RevokeAllTokens(ctx, newId(), now); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("RevokeAllTokens of an unknown user: got %v, want ErrNotFound", err)
+	}
+}
+
+func testSessions(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	other := createUser(t, repo)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	first, firstToken := createSession(t, repo, user.Id, now.Add(-time.Hour))
+	second, _ := createSession(t, repo, user.Id, now.Add(-time.Hour))
+	createSession(t, repo, other.Id, now)
+
+	sessions, err := repo.GetSessionsByUserId(ctx, user.Id)
+
+	if err != nil {
+		t.Fatalf("GetSessionsByUserId: %v", err)
+	}
+
+	if len(sessions) != 2 {
+		t.Fatalf("GetSessionsByUserId returned %d sessions, want the 2 of the user", len(sessions))
+	}
+
+	for _, session := range sessions {
+		if session.Id == first.Id && (session.UserAgent != first.UserAgent || session.IpAddress != first.IpAddress) {
+			t.Errorf("GetSessionsByUserId = %+v, want the user agent and address it was created with", session)
+		}
+	}
+
+	touched, err := repo.TouchSession(ctx, second.Id, user.Id, now)
+
+	if err != nil {
+		t.Fatalf("TouchSession: %v", err)
+	}
+
+	if !touched.LastSeenAt.Equal(now) {
+		t.Errorf("TouchSession LastSeenAt = %v, want %v", touched.LastSeenAt, now)
+	}
+
+	touched, err = repo.TouchSession(ctx, second.Id, user.Id, now.Add(time.Second))
+
+	if err != nil {
+		t.Fatalf("TouchSession: %v", err)
+	}
+
+	if !touched.LastSeenAt.Equal(now) {
+		t.Errorf("TouchSession within the touch interval moved LastSeenAt to %v, want %v", touched.LastSeenAt, now)
+	}
+
+	sessions, err = repo.GetSessionsByUserId(ctx, user.Id)
+
+	if err != nil {
+		t.Fatalf("GetSessionsByUserId: %v", err)
+	}
+
+	if len(sessions) != 2 || sessions[0].Id != second.Id {
+		t.Errorf("GetSessionsByUserId does not list the most recently seen session first")
+	}
+
+	if _, err = repo.TouchSession(ctx, first.Id, other.Id, now); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("TouchSession of another user: got %v, want ErrNotFound", err)
+	}
+
+	if err = repo.RevokeSession(ctx, first.Id, other.Id, now); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("RevokeSession of another user: got %v, want ErrNotFound", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = repo.RevokeSession(ctx, first.Id, user.Id, now); err != nil {
+			t.Fatalf("RevokeSession #%d: %v", i+1, err)
+		}
+	}
+
+	_, err = repo.RotateRefreshToken(ctx, firstToken.TokenHash, newRefreshToken(now))
+
+	if !errors.Is(err, models.ErrRefreshTokenRevoked) {
+		t.Errorf("RotateRefreshToken of a revoked session: got %v, want ErrRefreshTokenRevoked", err)
+	}
+
+	touched, err = repo.TouchSession(ctx, first.Id, user.Id, now)
+
+	if err != nil || touched.RevokedAt == nil {
+		t.Errorf("TouchSession of a revoked session = %+v, %v, want it revoked", touched, err)
+	}
+
+	sessions, err = repo.GetSessionsByUserId(ctx, user.Id)
+
+	if err != nil {
+		t.Fatalf("GetSessionsByUserId: %v", err)
+	}
+
+	if len(sessions) != 1 || sessions[0].Id != second.Id {
+		t.Errorf("GetSessionsByUserId still lists the revoked session")
+	}
+}
+
+func testTotpCredentials(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	if _, err := repo.GetTotpCredential(ctx, user.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("GetTotpCredential before enrolling: got %v, want ErrNotFound", err)
+	}
+
+	for _, secret := range []string{"FIRSTSECRET", "SECONDSECRET"} {
+		err := repo.SaveTotpCredential(ctx, &models.TotpCredential{UserId: user.Id, Secret: secret, CreatedAt: now})
+
+		if err != nil {
+			t.Fatalf("SaveTotpCredential: %v", err)
+		}
+	}
+
+	credential, err := repo.GetTotpCredential(ctx, user.Id)
+
+	if err != nil {
+		t.Fatalf("GetTotpCredential: %v", err)
+	}
+
+	if credential.Secret != "SECONDSECRET" || credential.Enabled() {
+		t.Errorf("GetTotpCredential = %+v, want the last unconfirmed secret", credential)
+	}
+
+	if err = repo.UseTotpCode(ctx, user.Id, 100); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("UseTotpCode before confirming: got %v, want ErrNotFound", err)
+	}
+
+	codes := []*models.RecoveryCode{{Id: newId(), CodeHash: "first"}, {Id: newId(), CodeHash: "second"}}
+
+	if err = repo.ConfirmTotpCredential(ctx, user.Id, 100, now, codes); err != nil {
+		t.Fatalf("ConfirmTotpCredential: %v", err)
+	}
+
+	if err = repo.ConfirmTotpCredential(ctx, user.Id, 101, now, codes); !errors.Is(err, models.ErrTotpAlreadyEnabled) {
+		t.Errorf("ConfirmTotpCredential twice: got %v, want ErrTotpAlreadyEnabled", err)
+	}
+
+	err = repo.SaveTotpCredential(ctx, &models.TotpCredential{UserId: user.Id, Secret: "THIRDSECRET", CreatedAt: now})
+
+	if !errors.Is(err, models.ErrTotpAlreadyEnabled) {
+		t.Errorf("SaveTotpCredential once enabled: got %v, want ErrTotpAlreadyEnabled", err)
+	}
+
+	if err = repo.UseTotpCode(ctx, user.Id, 100); !errors.Is(err, models.ErrTotpCodeReused) {
+		t.Errorf("UseTotpCode of the confirming step: got %v, want ErrTotpCodeReused", err)
+	}
+
+	if err = repo.UseTotpCode(ctx, user.Id, 101); err != nil {
+		t.Errorf("UseTotpCode of a later step: %v", err)
+	}
+
+	if err = repo.UseTotpCode(ctx, user.Id, 101); !errors.Is(err, models.ErrTotpCodeReused) {
+		t.Errorf("UseTotpCode of a used step: got %v, want ErrTotpCodeReused", err)
+	}
+
+	other := createUser(t, repo)
+
+	if err = repo.UseRecoveryCode(ctx, other.Id, "first", now); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("UseRecoveryCode of another user: got %v, want ErrNotFound", err)
+	}
+
+	if err = repo.UseRecoveryCode(ctx, user.Id, "first", now); err != nil {
+		t.Errorf("UseRecoveryCode: %v", err)
+	}
+
+	if err = repo.UseRecoveryCode(ctx, user.Id, "first", now); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("UseRecoveryCode twice: got %v, want ErrNotFound", err)
+	}
+
+	if err = repo.DeleteTotpCredential(ctx, user.Id); err != nil {
+		t.Fatalf("DeleteTotpCredential: %v", err)
+	}
+
+	if err = repo.UseRecoveryCode(ctx, user.Id, "second", now); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("UseRecoveryCode after disabling: got %v, want ErrNotFound", err)
+	}
+
+	if err = repo.DeleteTotpCredential(ctx, user.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("DeleteTotpCredential twice: got %v, want ErrNotFound", err)
+	}
+}
+
+func testMfaChallenges(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	newChallenge := func(expiresAt time.Time) *models.MfaChallenge {
+		challenge := &models.MfaChallenge{
+			Id:        newId(),
+			UserId:    user.Id,
+			TokenHash: newId(),
+			CreatedAt: now,
+			ExpiresAt: expiresAt,
+		}
+
+		if err := repo.CreateMfaChallenge(ctx, challenge); err != nil {
+			t.Fatalf("CreateMfaChallenge: %v", err)
+		}
+
+		return challenge
+	}
+
+	challenge := newChallenge(now.Add(time.Minute))
+
+	for i := 1; i <= models.MaxMfaChallengeAttempts; i++ {
+		attempted, err := repo.AttemptMfaChallenge(ctx, challenge.TokenHash, now)
+
+		if err != nil {
+			t.Fatalf("AttemptMfaChallenge #%d: %v", i, err)
+		}
+
+		if attempted.Attempts != i || attempted.UserId != user.Id {
+			t.Errorf("AttemptMfaChallenge #%d = %+v, want %d attempts for the user", i, attempted, i)
+		}
+	}
+
+	_, err := repo.AttemptMfaChallenge(ctx, challenge.TokenHash, now)
+
+	if !errors.Is(err, models.ErrMfaChallengeLocked) {
+		t.Errorf("AttemptMfaChallenge past the limit: got %v, want ErrMfaChallengeLocked", err)
+	}
+
+	challenge = newChallenge(now.Add(time.Minute))
+
+	if err = repo.CompleteMfaChallenge(ctx, challenge.Id, now); err != nil {
+		t.Fatalf("CompleteMfaChallenge: %v", err)
+	}
+
+	if err = repo.CompleteMfaChallenge(ctx, challenge.Id, now); !errors.Is(err, models.ErrMfaChallengeCompleted) {
+		t.Errorf("CompleteMfaChallenge twice: got %v, want ErrMfaChallengeCompleted", err)
+	}
+
+	_, err = repo.AttemptMfaChallenge(ctx, challenge.TokenHash, now)
+
+	if !errors.Is(err, models.ErrMfaChallengeCompleted) {
+		t.Errorf("AttemptMfaChallenge once completed: got %v, want ErrMfaChallengeCompleted", err)
+	}
+
+	challenge = newChallenge(now.Add(-time.Second))
+	_, err = repo.AttemptMfaChallenge(ctx, challenge.TokenHash, now)
+
+	if !errors.Is(err, models.ErrMfaChallengeExpired) {
+		t.Errorf("AttemptMfaChallenge once expired: got %v, want ErrMfaChallengeExpired", err)
+	}
+
+	if _, err = repo.AttemptMfaChallenge(ctx, newId(), now); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("AttemptMfaChallenge of an unknown token: got %v, want ErrNotFound", err)
+	}
+}
+
+func testUserTokens(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	newToken := func(purpose models.UserTokenPurpose, expiresAt time.Time) *models.UserToken {
+		token := &models.UserToken{
+			Id:        newId(),
+			UserId:    user.Id,
+			Purpose:   purpose,
+			TokenHash: newId(),
+			CreatedAt: now,
+			ExpiresAt: expiresAt,
+		}
+
+		if err := repo.CreateUserToken(ctx, token); err != nil {
+			t.Fatalf("CreateUserToken: %v", err)
+		}
+
+		return token
+	}
+
+	older := newToken(models.UserTokenVerifyEmail, now.Add(time.Hour))
+	latest := newToken(models.UserTokenVerifyEmail, now.Add(time.Hour))
+	reset := newToken(models.UserTokenResetPassword, now.Add(time.Hour))
+
+	_, err := repo.UseUserToken(ctx, models.UserTokenResetPassword, latest.TokenHash, now)
+
+	if !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("UseUserToken for another purpose: got %v, want ErrNotFound", err)
+	}
+
+	used, err := repo.UseUserToken(ctx, models.UserTokenVerifyEmail, latest.TokenHash, now)
+
+	if err != nil {
+		t.Fatalf("UseUserToken: %v", err)
+	}
+
+	if used.UserId != user.Id || used.UsedAt == nil {
+		t.Errorf("UseUserToken = %+v, want the used token of the user", used)
+	}
+
+	_, err = repo.UseUserToken(ctx, models.UserTokenVerifyEmail, latest.TokenHash, now)
+
+	if !errors.Is(err, models.ErrUserTokenUsed) {
+		t.Errorf("UseUserToken twice: got %v, want ErrUserTokenUsed", err)
+	}
+
+	_, err = repo.UseUserToken(ctx, models.UserTokenVerifyEmail, older.TokenHash, now)
+
+	if !errors.Is(err, models.ErrUserTokenUsed) {
+		t.Errorf("UseUserToken of an older token for the same purpose: got %v, want ErrUserTokenUsed", err)
+	}
+
+	if _, err = repo.UseUserToken(ctx, models.UserTokenResetPassword, reset.TokenHash, now); err != nil {
+		t.Errorf("UseUserToken of a token for another purpose: %v", err)
+	}
+
+	expired := newToken(models.UserTokenResetPassword, now.Add(-time.Second))
+	_, err = repo.UseUserToken(ctx, models.UserTokenResetPassword, expired.TokenHash, now)
+
+	if !errors.Is(err, models.ErrUserTokenExpired) {
+		t.Errorf("UseUserToken once expired: got %v, want ErrUserTokenExpired", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = repo.VerifyEmail(ctx, user.Id, now.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("VerifyEmail #%d: %v", i+1, err)
+		}
+	}
+
+	if err = repo.ResetPassword(ctx, user.Id, "new hash", now); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+
+	read, err := repo.ReadUserByEmail(ctx, user.Email)
+
+	if err != nil {
+		t.Fatalf("ReadUserByEmail: %v", err)
+	}
+
+	if read.EmailVerifiedAt == nil || !read.EmailVerifiedAt.Equal(now) {
+		t.Errorf("EmailVerifiedAt = %v, want the first verification at %v", read.EmailVerifiedAt, now)
+	}
+
+	if read.Password != "new hash" {
+		t.Errorf("Password = %q, want the updated hash", read.Password)
+	}
+
+	if err = repo.VerifyEmail(ctx, newId(), now); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("VerifyEmail of an unknown user: got %v, want ErrNotFound", err)
+	}
+
+	if err = repo.ResetPassword(ctx, newId(), "hash", now); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("ResetPassword of an unknown user: got %v, want ErrNotFound", err)
+	}
+
+	if err = repo.RehashPassword(ctx, user.Id, "new hash", "rehashed"); err != nil {
+		t.Errorf("RehashPassword of the current hash: %v", err)
+	}
+
+	if err = repo.RehashPassword(ctx, user.Id, "new hash", "stale"); !errors.Is(err, repositories.ErrConflict) {
+		t.Errorf("RehashPassword of a replaced hash: got %v, want ErrConflict", err)
+	}
+
+	if read, err = repo.ReadUserByEmail(ctx, user.Email); err != nil || read.Password != "rehashed" {
+		t.Errorf("ReadUserByEmail after rehashing = %+v, %v, want the rehashed password kept", read, err)
+	}
+}
+
+func testLoginThrottles(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	key := models.EmailLoginKey(newId() + "@example.com")
+	now := time.Now().UTC().Truncate(time.Second)
+	policy := models.LoginPolicy{
+		FreeFailures: 1,
+		BaseDelay:    time.Second,
+		LockoutAfter: 3,
+		Lockout:      time.Minute,
+		ForgetAfter:  time.Hour,
+	}
+
+	if _, err := repo.GetLoginThrottle(ctx, key); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("GetLoginThrottle of a new key: got %v, want ErrNotFound", err)
+	}
+
+	wants := []time.Time{now, now.Add(time.Second), now.Add(time.Minute)}
+
+	for i, want := range wants {
+		throttle, err := repo.RecordLoginFailure(ctx, key, now, policy, newAuditEvent(models.AuditLoginLockedOut, key))
+
+		if err != nil {
+			t.Fatalf("RecordLoginFailure #%d: %v", i+1, err)
+		}
+
+		if throttle.Failures != i+1 || !throttle.BlockedUntil.Equal(want) {
+			t.Errorf("RecordLoginFailure #%d = %+v, want %d failures blocked until %v", i+1, throttle, i+1, want)
+		}
+	}
+
+	throttle, err := repo.GetLoginThrottle(ctx, key)
+
+	if err != nil {
+		t.Fatalf("GetLoginThrottle: %v", err)
+	}
+
+	if throttle.Key != key || !throttle.LockedOut(policy) || !throttle.Blocked(now) {
+		t.Errorf("GetLoginThrottle = %+v, want the key locked out", throttle)
+	}
+
+	throttle, err = repo.RecordLoginFailure(ctx, key, now.Add(2*time.Hour), policy, nil)
+
+	if err != nil {
+		t.Fatalf("RecordLoginFailure after a quiet hour: %v", err)
+	}
+
+	if throttle.Failures != 1 {
+		t.Errorf("Failures after a quiet hour = %d, want the count to start over", throttle.Failures)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = repo.ClearLoginThrottle(ctx, key, newAuditEvent(models.AuditLoginUnlocked, key)); err != nil {
+			t.Fatalf("ClearLoginThrottle #%d: %v", i+1, err)
+		}
+	}
+
+	if _, err = repo.GetLoginThrottle(ctx, key); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("GetLoginThrottle once cleared: got %v, want ErrNotFound", err)
+	}
+
+	if err = repo.CreateAuditEvent(ctx, newAuditEvent(models.AuditLoginLockedOut, key)); err != nil {
+		t.Errorf("CreateAuditEvent: %v", err)
+	}
+}
+
+func testSigningKeys(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	newKey := func(activeFrom time.Time) *models.SigningKey {
+		key := &models.SigningKey{
+			Id:          newId(),
+			Algorithm:   "EdDSA",
+			PrivateKey:  "sealed " + newId(),
+			CreatedAt:   now,
+			ActiveFrom:  activeFrom,
+			ActiveUntil: activeFrom.Add(time.Hour),
+			ExpiresAt:   activeFrom.Add(2 * time.Hour),
+		}
+
+		if err := repo.CreateSigningKey(ctx, key); err != nil {
+			t.Fatalf("CreateSigningKey: %v", err)
+		}
+
+		return key
+	}
+
+	next := newKey(now.Add(time.Hour))
+	newKey(now.Add(-2 * time.Hour))
+	active := newKey(now.Add(-30 * time.Minute))
+
+	keys, err := repo.GetSigningKeys(ctx, now)
+
+	if err != nil {
+		t.Fatalf("GetSigningKeys: %v", err)
+	}
+
+	if len(keys) != 2 || keys[0].Id != active.Id || keys[1].Id != next.Id {
+		t.Fatalf("GetSigningKeys = %d keys, want the active and the next key in order", len(keys))
+	}
+
+	read := keys[1]
+
+	if read.Algorithm != next.Algorithm || read.PrivateKey != next.PrivateKey ||
+		!read.ActiveFrom.Equal(next.ActiveFrom) || !read.ActiveUntil.Equal(next.ActiveUntil) ||
+		!read.ExpiresAt.Equal(next.ExpiresAt) {
+		t.Errorf("GetSigningKeys returned %+v, want %+v", read, next)
+	}
+}
+
+func testRoles(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+
+	if err := repo.SetUserRole(ctx, user.Id, models.RoleSupport, newAuditEvent(models.AuditRoleChanged, user.Id)); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+
+	read, err := repo.ReadUser(ctx, user.Id)
+
+	if err != nil {
+		t.Fatalf("ReadUser: %v", err)
+	}
+
+	if read.Role != models.RoleSupport || read.TokenVersion != user.TokenVersion+1 {
+		t.Errorf("ReadUser = %+v, want role support and the token version bumped", read)
+	}
+
+	byEmail, err := repo.ReadUserByEmail(ctx, user.Email)
+
+	if err != nil {
+		t.Fatalf("ReadUserByEmail: %v", err)
+	}
+
+	if byEmail.Role != models.RoleSupport {
+		t.Errorf("ReadUserByEmail role = %q, want support", byEmail.Role)
+	}
+
+	if err = repo.SetUserRole(ctx, newId(), models.RoleAdmin, nil); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("SetUserRole of an unknown user: got %v, want ErrNotFound", err)
+	}
+}
+
+// testListUsers pages through every user, as the repository may hold users
+// of other tests, and checks its own come back in sign up order.
+func testListUsers(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	created := map[string]bool{}
+
+	for i := 0; i < 3; i++ {
+		created[createUser(t, repo).Id] = true
+	}
+
+	var listed []string
+	var firstPage []*models.User
+	var secondCursor string
+
+	page := models.PageRequest{Limit: 2}
+
+	for {
+		users, currentPage, err := repo.ListUsers(ctx, page, newAuditEvent(models.AuditUsersListed, ""))
+
+		if err != nil {
+			t.Fatalf("ListUsers: %v", err)
+		}
+
+		if firstPage == nil {
+			firstPage, secondCursor = users, currentPage.NextCursor
+		}
+
+		for _, user := range users {
+			if user.Password != "" {
+				t.Errorf("ListUsers returned the password of %s", user.Id)
+			}
+
+			listed = append(listed, user.Id)
+		}
+
+		if currentPage.NextCursor == "" {
+			break
+		}
+
+		cursor, err := models.DecodeCursor(currentPage.NextCursor)
+
+		if err != nil {
+			t.Fatalf("DecodeCursor: %v", err)
+		}
+
+		page.Cursor = cursor
+	}
+
+	found := 0
+
+	for i, id := range listed {
+		if i > 0 && id <= listed[i-1] {
+			t.Fatalf("ListUsers order = %v, want ascending ids", listed)
+		}
+
+		if created[id] {
+			found++
+		}
+	}
+
+	if found != len(created) {
+		t.Errorf("ListUsers listed %d of the %d users created", found, len(created))
+	}
+
+	cursor, err := models.DecodeCursor(secondCursor)
+
+	if err != nil {
+		t.Fatalf("DecodeCursor: %v", err)
+	}
+
+	users, _, err := repo.ListUsers(ctx, models.PageRequest{Cursor: cursor, Limit: 2}, nil)
+
+	if err != nil {
+		t.Fatalf("ListUsers of the second page: %v", err)
+	}
+
+	prev, _, err := repo.ListUsers(ctx, models.PageRequest{Cursor: &models.Cursor{Backward: true, Id: users[0].Id}, Limit: 2}, nil)
+
+	if err != nil {
+		t.Fatalf("ListUsers backwards: %v", err)
+	}
+
+	if len(prev) != len(firstPage) || prev[0].Id != firstPage[0].Id || prev[1].Id != firstPage[1].Id {
+		t.Errorf("paging back from the second page did not return the first")
+	}
+}
+
+func testReadBankAccount(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	owner := createUser(t, repo)
+	bankAccount := createBankAccount(t, repo, owner.Id, "Savings")
+
+	read, err := repo.ReadBankAccount(ctx, bankAccount.Id, newAuditEvent(models.AuditBankAccountViewed, bankAccount.Id))
+
+	if err != nil {
+		t.Fatalf("ReadBankAccount: %v", err)
+	}
+
+	if read.UserId != owner.Id || read.Name != "Savings" || read.State != models.BankAccountStateActive {
+		t.Errorf("ReadBankAccount = %+v, want the active account of its owner", read)
+	}
+
+	if _, err = repo.ReadBankAccount(ctx, newId(), nil); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("ReadBankAccount of an unknown id: got %v, want ErrNotFound", err)
+	}
+}
+
+func testApiKeys(t *testing.T, repo repositories.Repository) {
+	ctx := context.Background()
+
+	user := createUser(t, repo)
+	other := createUser(t, repo)
+	now := time.Now().UTC().Truncate(time.Second)
+	expiresAt := now.Add(24 * time.Hour)
+
+	first := &models.ApiKey{
+		Id:           newId(),
+		UserId:       user.Id,
+		Name:         "nightly sweep",
+		Prefix:       "svb_first",
+		KeyHash:      "hash-" + newId(),
+		Scopes:       []models.Scope{models.ScopeAccountsRead, models.ScopeTransfersWrite},
+		TokenVersion: 3,
+		CreatedAt:    now.Add(-time.Hour),
+		ExpiresAt:    &expiresAt,
+	}
+	second := &models.ApiKey{
+		Id:        newId(),
+		UserId:    user.Id,
+		Name:      "reports",
+		Prefix:    "svb_second",
+		KeyHash:   "hash-" + newId(),
+		Scopes:    []models.Scope{models.ScopeAccountsRead},
+		CreatedAt: now,
+	}
+
+	for _, key := range []*models.ApiKey{first, second} {
+		if err := repo.CreateApiKey(ctx, key, newAuditEvent(models.AuditApiKeyCreated, key.Id)); err != nil {
+			t.Fatalf("CreateApiKey: %v", err)
+		}
+	}
+
+	keys, err := repo.GetApiKeysByUserId(ctx, user.Id)
+
+	if err != nil {
+		t.Fatalf("GetApiKeysByUserId: %v", err)
+	}
+
+	if len(keys) != 2 || keys[0].Id != second.Id {
+		t.Fatalf("GetApiKeysByUserId = %d keys, want the 2 of the user newest first", len(keys))
+	}
+
+	got, err := repo.GetApiKey(ctx, first.Id, user.Id)
+
+	if err != nil {
+		t.Fatalf("GetApiKey: %v", err)
+	}
+
+	if got.Prefix != first.Prefix || len(got.Scopes) != 2 || !got.Can(models.ScopeTransfersWrite) || got.TokenVersion != 3 ||
+		got.ExpiresAt == nil || !got.ExpiresAt.Equal(expiresAt) || got.LastUsedAt != nil {
+		t.Errorf("GetApiKey = %+v, want the key as it was created", got)
+	}
+
+	if _, err = repo.GetApiKey(ctx, first.Id, other.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("GetApiKey of another user: got %v, want ErrNotFound", err)
+	}
+
+	renamed, err := repo.RenameApiKey(ctx, first.Id, user.Id, "weekly sweep")
+
+	if err != nil || renamed.Name != "weekly sweep" {
+		t.Errorf("RenameApiKey = %+v, %v, want the new name", renamed, err)
+	}
+
+	if _, err = repo.RenameApiKey(ctx, first.Id, other.Id, "stolen"); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("RenameApiKey of another user: got %v, want ErrNotFound", err)
+	}
+
+	used, err := repo.UseApiKey(ctx, first.KeyHash, now)
+
+	if err != nil {
+		t.Fatalf("UseApiKey: %v", err)
+	}
+
+	if used.Id != first.Id || used.LastUsedAt == nil || !used.LastUsedAt.Equal(now) {
+		t.Errorf("UseApiKey = %+v, want the key last used at %v", used, now)
+	}
+
+	used, err = repo.UseApiKey(ctx, first.KeyHash, now.Add(time.Second))
+
+	if err != nil || !used.LastUsedAt.Equal(now) {
+		t.Errorf("UseApiKey within the touch interval = %+v, %v, want LastUsedAt left at %v", used, err, now)
+	}
+
+	if _, err = repo.UseApiKey(ctx, "hash-"+newId(), now); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("UseApiKey of an unknown key: got %v, want ErrNotFound", err)
+	}
+
+	if err = repo.RevokeApiKey(ctx, first.Id, other.Id, now, nil); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("RevokeApiKey of another user: got %v, want ErrNotFound", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = repo.RevokeApiKey(ctx, first.Id, user.Id, now, newAuditEvent(models.AuditApiKeyRevoked, first.Id)); err != nil {
+			t.Fatalf("RevokeApiKey #%d: %v", i+1, err)
+		}
+	}
+
+	used, err = repo.UseApiKey(ctx, first.KeyHash, now)
+
+	if err != nil || used.RevokedAt == nil {
+		t.Errorf("UseApiKey of a revoked key = %+v, %v, want it revoked", used, err)
+	}
+
+	if _, err = repo.GetApiKey(ctx, first.Id, user.Id); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("GetApiKey of a revoked key: got %v, want ErrNotFound", err)
+	}
+
+	keys, err = repo.GetApiKeysByUserId(ctx, user.Id)
+
+	if err != nil {
+		t.Fatalf("GetApiKeysByUserId: %v", err)
+	}
+
+	if len(keys) != 1 || keys[0].Id != second.Id {
+		t.Errorf("GetApiKeysByUserId still lists the revoked key")
+	}
+
+	if err = repo.ResetPassword(ctx, user.Id, "new hash", now); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+
+	if used, err = repo.UseApiKey(ctx, second.KeyHash, now); err != nil || used.RevokedAt == nil {
+		t.Errorf("UseApiKey after ResetPassword = %+v, %v, want the key revoked", used, err)
+	}
+}
+
+// createSession signs userId in at createdAt the way the handlers do: a
+// session and the first refresh token of its family.
+func createSession(
+	t *testing.T,
+	repo repositories.Repository,
+	userId string,
+	createdAt time.Time,
+) (*models.Session, *models.RefreshToken) {
+	t.Helper()
+
+	session := &models.Session{
+		Id:         newId(),
+		UserId:     userId,
+		UserAgent:  "agent " + newId(),
+		IpAddress:  "192.0.2.1",
+		CreatedAt:  createdAt,
+		LastSeenAt: createdAt,
+	}
+
+	if err := repo.CreateSession(context.Background(), session); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	token := newRefreshToken(createdAt)
+	token.FamilyId = session.Id
+	token.UserId = userId
+
+	if err := repo.CreateRefreshToken(context.Background(), token); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	return session, token
+}
+
+func newRefreshToken(createdAt time.Time) *models.RefreshToken {
+	return &models.RefreshToken{
+		Id:        newId(),
+		TokenHash: newId(),
+		CreatedAt: createdAt,
+		ExpiresAt: createdAt.Add(time.Hour),
+	}
+}
+
+func newId() string {
+	return ksuid.New().String()
+}
+
+func newUser() *models.User {
+	id := newId()
+
+	return &models.User{
+		Id:       id,
+		Email:    id + "@example.com",
+		Password: "hashed-" + id,
+		Role:     models.RoleCustomer,
+	}
+}
+
+func createUser(t *testing.T, repo repositories.Repository) *models.User {
+	t.Helper()
+
+	user := newUser()
+
+	if _, err := repo.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	return user
+}
+
+func newBankAccount(userId string, name string) *models.BankAccount {
+	return &models.BankAccount{
+		Id:       newId(),
+		UserId:   userId,
+		Name:     name,
+		Currency: models.DefaultCurrency,
+		Balance:  models.Money{Currency: models.DefaultCurrency},
+		State:    models.BankAccountStatePending,
+	}
+}
+
+// createBankAccount opens an active account the way the handlers do:
+// created pending and moved to active in the same call.
+func createBankAccount(t *testing.T, repo repositories.Repository, userId string, name string) *models.BankAccount {
+	t.Helper()
+
+	ctx := context.Background()
+	bankAccount := newBankAccount(userId, name)
+	opening := newTransition(bankAccount.Id, userId, models.BankAccountStateActive)
+
+	if _, err := repo.CreateBankAccount(ctx, bankAccount, opening); err != nil {
+		t.Fatalf("CreateBankAccount: %v", err)
+	}
+
+	bankAccount.State = models.BankAccountStateActive
+
+	return bankAccount
+}
+
+func newTransition(bankAccountId string, actorId string, to models.BankAccountState) *models.BankAccountTransition {
+	return &models.BankAccountTransition{
+		Id:            newId(),
+		BankAccountId: bankAccountId,
+		To:            to,
+		Reason:        "conformance test",
+		ActorId:       actorId,
+	}
+}
+
+func newAuditEvent(action string, subject string) *models.AuditEvent {
+	return &models.AuditEvent{
+		Id:        newId(),
+		Action:    action,
+		Subject:   subject,
+		IpAddress: "192.0.2.1",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// newTransaction moves amount minor units of the default currency from one
+// account to another.
+func newTransaction(kind string, fromId string, toId string, amount int64) *models.Transaction {
+	money := models.Money{Amount: amount, Currency: models.DefaultCurrency}
+
+	return &models.Transaction{
+		Id:   newId(),
+		Kind: kind,
+		Entries: []*models.LedgerEntry{
+			{Id: newId(), BankAccountId: fromId, Direction: models.DebitEntry, Amount: money},
+			{Id: newId(), BankAccountId: toId, Direction: models.CreditEntry, Amount: money},
+		},
+	}
+}
+
+func deposit(t *testing.T, repo repositories.Repository, bankAccountId string, amount int64) *models.Transaction {
+	t.Helper()
+
+	transaction := newTransaction(models.DepositTransaction, models.ExternalBankAccountId, bankAccountId, amount)
+
+	if _, err := repo.CreateTransaction(context.Background(), transaction); err != nil {
+		t.Fatalf("CreateTransaction: %v", err)
+	}
+
+	return transaction
+}
+
+func assertBalance(t *testing.T, repo repositories.Repository, userId string, bankAccountId string, want int64) {
+	t.Helper()
+
+	bankAccount, err := repo.GetBankAccountById(context.Background(), bankAccountId, userId)
+
+	if err != nil {
+		t.Fatalf("GetBankAccountById: %v", err)
+	}
+
+	if bankAccount.Balance.Amount != want || bankAccount.Balance.Currency != models.DefaultCurrency {
+		t.Errorf("balance of %s = %s, want %d minor units", bankAccountId, bankAccount.Balance, want)
+	}
+}
+
+const walkPageLimit = 2
+
+func firstPage(limit int) models.PageRequest {
+	return models.PageRequest{Limit: limit}
+}
+
+// walkBankAccounts reads the page at cursor and follows NextCursor, or
+// PrevCursor when forward is false, until the list runs out. It returns the
+// accounts seen in list order and the cursor of the last page it read.
+func walkBankAccounts(
+	t *testing.T,
+	repo repositories.Repository,
+	userId string,
+	filter *models.BankAccountFilter,
+	cursor string,
+	forward bool,
+) ([]*models.BankAccount, string) {
+	t.Helper()
+
+	var seen []*models.BankAccount
+	var current string
+
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("pagination does not end")
+		}
+
+		page, err := models.NewPageRequest(cursor, walkPageLimit)
+
+		if err != nil {
+			t.Fatalf("NewPageRequest: %v", err)
+		}
+
+		bankAccounts, currentPage, err := repo.GetAllBankAccountsByUserId(context.Background(), userId, filter, page)
+
+		if err != nil {
+			t.Fatalf("GetAllBankAccountsByUserId: %v", err)
+		}
+
+		current = cursor
+
+		if forward {
+			seen = append(seen, bankAccounts...)
+			cursor = currentPage.NextCursor
+		} else {
+			seen = append(bankAccounts, seen...)
+			cursor = currentPage.PrevCursor
+		}
+
+		if cursor == "" {
+			return seen, current
+		}
+	}
+}
+
+func sortBankAccounts(bankAccounts []*models.BankAccount, filter *models.BankAccountFilter) []string {
+	sorted := append([]*models.BankAccount(nil), bankAccounts...)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+
+		if filter.Descending {
+			a, b = b, a
+		}
+
+		switch filter.Sort {
+		case models.SortBankAccountsByName:
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+		case models.SortBankAccountsByBalance:
+			if a.Balance.Amount != b.Balance.Amount {
+				return a.Balance.Amount < b.Balance.Amount
+			}
+		}
+
+		return a.Id < b.Id
+	})
+
+	var ids []string
+
+	for _, bankAccount := range sorted {
+		ids = append(ids, bankAccount.Id)
+	}
+
+	return ids
+}
+
+func assertIds(t *testing.T, walk string, bankAccounts []*models.BankAccount, want []string) {
+	t.Helper()
+
+	var got []string
+
+	for _, bankAccount := range bankAccounts {
+		got = append(got, bankAccount.Id)
+	}
+
+	if !equal(got, want) {
+		t.Errorf("%s walk listed %v, want %v", walk, got, want)
+	}
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,8 +1,19 @@
 package server
 
-import "github.com/golang-jwt/jwt"
+import (
+	"github.com/golang-jwt/jwt"
+	"github.com/pipeline1987/SVB/models"
+)
 
+// AppClaims are the claims of an access token. The jti (StandardClaims.Id)
+// lets a single token be revoked, TokenVersion must match the version stored
+// with the user and SessionId is the refresh token family the token was
+// issued for. Role is the role of the user when the token was signed;
+// changing it bumps TokenVersion.
 type AppClaims struct {
-	UserId string
+	UserId       string
+	Role         models.Role
+	TokenVersion int
+	SessionId    string
 	jwt.StandardClaims
 }
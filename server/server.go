@@ -6,31 +6,75 @@ import (
 	"github.com/rs/cors"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pipeline1987/SVB/database"
+	"github.com/pipeline1987/SVB/database/memory"
+	"github.com/pipeline1987/SVB/keyring"
+	"github.com/pipeline1987/SVB/mailer"
+	"github.com/pipeline1987/SVB/passwords"
 	"github.com/pipeline1987/SVB/repositories"
 	"github.com/pipeline1987/SVB/websocket"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// Config is read from the environment. SIGN_EXPIRE_HOURS is how long a
+// refresh token lasts and ACCESS_EXPIRE_MINUTES how long an access token
+// does, 15 minutes unless set. MAILER_URL picks how email is sent, see
+// mailer.New; it logs messages unless set. Access tokens are signed with
+// JWT_ALGORITHM, EdDSA unless set or RS256, by keys that rotate every
+// JWT_ROTATE_HOURS and overlap for JWT_OVERLAP_HOURS, see keyring.Ring.
+// JWT_SECRET seals those keys and signs emailed tokens. Passwords are hashed
+// with PASSWORD_HASHER, argon2id unless set or bcrypt, see passwords.Hasher.
+// ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and ARGON2_PARALLELISM tune argon2id
+// and HASH_COST tunes bcrypt.
 type Config struct {
-	PORT              string
-	JWT_SECRET        string
-	DB_HOST           string
-	HASH_COST         string
-	SIGN_EXPIRE_HOURS string
+	PORT                  string
+	JWT_SECRET            string
+	DB_HOST               string
+	HASH_COST             string
+	SIGN_EXPIRE_HOURS     string
+	ACCESS_EXPIRE_MINUTES string
+	MAILER_URL            string
+	MAIL_FROM             string
+	JWT_ALGORITHM         string
+	JWT_ROTATE_HOURS      string
+	JWT_OVERLAP_HOURS     string
+	PASSWORD_HASHER       string
+	ARGON2_MEMORY_KIB     string
+	ARGON2_ITERATIONS     string
+	ARGON2_PARALLELISM    string
 }
 
+const (
+	defaultAccessExpireMinutes = "15"
+	defaultMailerUrl           = "log://"
+	defaultMailFrom            = "SVB <no-reply@localhost>"
+	defaultJwtAlgorithm        = keyring.EdDSA
+	defaultJwtRotateHours      = "168"
+	defaultJwtOverlapHours     = "24"
+	defaultPasswordHasher      = "argon2id"
+	defaultHashCost            = "10"
+)
+
 type Server interface {
 	Config() *Config
 	Hub() *websocket.Hub
+	Mailer() mailer.Mailer
+	Keys() *keyring.Ring
+	Passwords() *passwords.Hasher
 }
 
 type Broker struct {
-	config *Config
-	router *mux.Router
-	hub    *websocket.Hub
+	config    *Config
+	router    *mux.Router
+	hub       *websocket.Hub
+	mailer    mailer.Mailer
+	keys      *keyring.Ring
+	passwords *passwords.Hasher
 }
 
 func (b *Broker) Config() *Config {
@@ -41,6 +85,18 @@ func (b *Broker) Hub() *websocket.Hub {
 	return b.hub
 }
 
+func (b *Broker) Mailer() mailer.Mailer {
+	return b.mailer
+}
+
+func (b *Broker) Keys() *keyring.Ring {
+	return b.keys
+}
+
+func (b *Broker) Passwords() *passwords.Hasher {
+	return b.passwords
+}
+
 func NewServer(ctx context.Context, config *Config) (*Broker, error) {
 	if config.PORT == "" {
 		return nil, errors.New("PORT is required")
@@ -54,18 +110,79 @@ func NewServer(ctx context.Context, config *Config) (*Broker, error) {
 		return nil, errors.New("DB_HOST is required")
 	}
 
+	if config.SIGN_EXPIRE_HOURS == "" {
+		return nil, errors.New("SIGN_EXPIRE_HOURS is required")
+	}
+
+	if config.ACCESS_EXPIRE_MINUTES == "" {
+		config.ACCESS_EXPIRE_MINUTES = defaultAccessExpireMinutes
+	}
+
+	if config.MAILER_URL == "" {
+		config.MAILER_URL = defaultMailerUrl
+	}
+
+	if config.MAIL_FROM == "" {
+		config.MAIL_FROM = defaultMailFrom
+	}
+
+	if config.JWT_ALGORITHM == "" {
+		config.JWT_ALGORITHM = defaultJwtAlgorithm
+	}
+
+	if config.JWT_ROTATE_HOURS == "" {
+		config.JWT_ROTATE_HOURS = defaultJwtRotateHours
+	}
+
+	if config.JWT_OVERLAP_HOURS == "" {
+		config.JWT_OVERLAP_HOURS = defaultJwtOverlapHours
+	}
+
+	if config.PASSWORD_HASHER == "" {
+		config.PASSWORD_HASHER = defaultPasswordHasher
+	}
+
 	if config.HASH_COST == "" {
-		return nil, errors.New("HASH_COST is required")
+		config.HASH_COST = defaultHashCost
 	}
 
-	if config.SIGN_EXPIRE_HOURS == "" {
-		return nil, errors.New("SIGN_EXPIRE_HOURS is required")
+	if config.ARGON2_MEMORY_KIB == "" {
+		config.ARGON2_MEMORY_KIB = strconv.Itoa(int(passwords.DefaultArgon2id.Memory))
+	}
+
+	if config.ARGON2_ITERATIONS == "" {
+		config.ARGON2_ITERATIONS = strconv.Itoa(int(passwords.DefaultArgon2id.Iterations))
+	}
+
+	if config.ARGON2_PARALLELISM == "" {
+		config.ARGON2_PARALLELISM = strconv.Itoa(int(passwords.DefaultArgon2id.Parallelism))
+	}
+
+	mail, err := mailer.New(config.MAILER_URL, config.MAIL_FROM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := newKeyRing(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hasher, err := newPasswordHasher(config)
+
+	if err != nil {
+		return nil, err
 	}
 
 	broker := &Broker{
-		config: config,
-		router: mux.NewRouter(),
-		hub:    websocket.NewHub(),
+		config:    config,
+		router:    mux.NewRouter(),
+		hub:       websocket.NewHub(),
+		mailer:    mail,
+		keys:      keys,
+		passwords: hasher,
 	}
 
 	return broker, nil
@@ -77,7 +194,7 @@ func (b *Broker) Start(binder func(server Server, router *mux.Router)) {
 
 	handler := cors.AllowAll().Handler(b.router)
 
-	repo, err := database.NewPsqlRepository(b.config.DB_HOST)
+	repo, err := NewRepository(context.Background(), b.config.DB_HOST)
 
 	if err != nil {
 		log.Fatal(err)
@@ -97,3 +214,96 @@ func (b *Broker) Start(binder func(server Server, router *mux.Router)) {
 		log.Fatal("ListenAndServe: ", err)
 	}
 }
+
+// newKeyRing makes the key ring config asks for. The overlap has to outlast
+// access tokens, or tokens signed just before a rotation would stop
+// verifying early.
+func newKeyRing(config *Config) (*keyring.Ring, error) {
+	rotateHours, err := strconv.Atoi(config.JWT_ROTATE_HOURS)
+
+	if err != nil {
+		return nil, errors.New("JWT_ROTATE_HOURS must be a number of hours")
+	}
+
+	overlapHours, err := strconv.Atoi(config.JWT_OVERLAP_HOURS)
+
+	if err != nil {
+		return nil, errors.New("JWT_OVERLAP_HOURS must be a number of hours")
+	}
+
+	accessMinutes, err := strconv.Atoi(config.ACCESS_EXPIRE_MINUTES)
+
+	if err != nil {
+		return nil, errors.New("ACCESS_EXPIRE_MINUTES must be a number of minutes")
+	}
+
+	overlap := time.Duration(overlapHours) * time.Hour
+
+	if overlap < time.Duration(accessMinutes)*time.Minute {
+		return nil, errors.New("JWT_OVERLAP_HOURS must be at least as long as ACCESS_EXPIRE_MINUTES")
+	}
+
+	return keyring.New(config.JWT_SECRET, config.JWT_ALGORITHM, time.Duration(rotateHours)*time.Hour, overlap)
+}
+
+// newPasswordHasher makes the hasher config asks for. Hashes of the other
+// scheme are still verified, and replaced as their users sign in.
+func newPasswordHasher(config *Config) (*passwords.Hasher, error) {
+	cost, err := strconv.Atoi(config.HASH_COST)
+
+	if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, errors.New("HASH_COST must be a bcrypt cost between 4 and 31")
+	}
+
+	memory, err := strconv.ParseUint(config.ARGON2_MEMORY_KIB, 10, 32)
+
+	if err != nil {
+		return nil, errors.New("ARGON2_MEMORY_KIB must be a number of KiB")
+	}
+
+	iterations, err := strconv.ParseUint(config.ARGON2_ITERATIONS, 10, 32)
+
+	if err != nil || iterations == 0 {
+		return nil, errors.New("ARGON2_ITERATIONS must be a positive number")
+	}
+
+	parallelism, err := strconv.ParseUint(config.ARGON2_PARALLELISM, 10, 8)
+
+	if err != nil || parallelism == 0 {
+		return nil, errors.New("ARGON2_PARALLELISM must be a number between 1 and 255")
+	}
+
+	if memory < 8*parallelism {
+		return nil, errors.New("ARGON2_MEMORY_KIB must be at least 8 KiB per ARGON2_PARALLELISM")
+	}
+
+	argon2id := passwords.DefaultArgon2id
+	argon2id.Memory = uint32(memory)
+	argon2id.Iterations = uint32(iterations)
+	argon2id.Parallelism = uint8(parallelism)
+
+	bcryptScheme := passwords.Bcrypt{Cost: cost}
+
+	switch config.PASSWORD_HASHER {
+	case "argon2id":
+		return passwords.New(argon2id, bcryptScheme), nil
+	case "bcrypt":
+		return passwords.New(bcryptScheme, argon2id), nil
+	default:
+		return nil, errors.New("PASSWORD_HASHER must be argon2id or bcrypt")
+	}
+}
+
+// NewRepository picks the storage backend from the scheme of DB_HOST:
+// memory:// keeps everything in process memory, sqlite:// names a SQLite
+// file (or :memory:) and anything else is a Postgres connection string.
+func NewRepository(ctx context.Context, dbHost string) (repositories.Repository, error) {
+	switch {
+	case strings.HasPrefix(dbHost, "memory://"):
+		return memory.NewRepository(), nil
+	case strings.HasPrefix(dbHost, database.SqliteScheme):
+		return database.NewSqliteRepository(ctx, strings.TrimPrefix(dbHost, database.SqliteScheme))
+	default:
+		return database.NewPsqlRepository(dbHost)
+	}
+}
@@ -0,0 +1,157 @@
+// Package totp implements the time-based one-time passwords of RFC 6238, the
+// codes authenticator apps show, with HMAC-SHA1 as those apps expect.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecretBytes is the size of generated secrets, the length of an HMAC-SHA1
+// key as RFC 4226 recommends.
+const SecretBytes = 20
+
+var ErrInvalidSecret = errors.New("totp secret is not valid base32")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP generates and verifies codes. Now is the clock codes are checked
+// against; tests replace it to drive verification to a given instant.
+type TOTP struct {
+	Digits int
+	Period time.Duration
+	// Skew is how many periods either side of now a code is still accepted
+	// for, to allow for clock drift and slow typing.
+	Skew int
+	Now  func() time.Time
+}
+
+// New returns the parameters every authenticator app supports: six digits
+// every 30 seconds, accepting the previous and next code as well.
+func New() *TOTP {
+	return &TOTP{
+		Digits: 6,
+		Period: 30 * time.Second,
+		Skew:   1,
+		Now:    time.Now,
+	}
+}
+
+// GenerateSecret returns a new random secret, base32 encoded without padding
+// as otpauth URIs carry it.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, SecretBytes)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(secret), nil
+}
+
+// Step is the time step at falls in, the counter its code is derived from.
+func (t *TOTP) Step(at time.Time) int64 {
+	return at.Unix() / int64(t.Period/time.Second)
+}
+
+// Code returns the code of secret at the given instant.
+func (t *TOTP) Code(secret string, at time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+
+	if err != nil {
+		return "", err
+	}
+
+	return t.code(key, t.Step(at)), nil
+}
+
+// Verify checks code against secret at Now. It returns the step the code
+// belongs to, which callers must remember so that a code cannot be replayed
+// while it is still valid.
+func (t *TOTP) Verify(secret string, code string) (int64, bool, error) {
+	key, err := decodeSecret(secret)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	code = strings.TrimSpace(code)
+
+	if len(code) != t.Digits {
+		return 0, false, nil
+	}
+
+	now := t.Step(t.Now())
+
+	for step := now - int64(t.Skew); step <= now+int64(t.Skew); step++ {
+		if subtle.ConstantTimeCompare([]byte(t.code(key, step)), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// URI returns the otpauth URI authenticator apps enroll from, usually shown
+// as a QR code.
+func (t *TOTP) URI(secret string, issuer string, account string) string {
+	query := url.Values{}
+
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(t.Digits))
+	query.Set("period", strconv.Itoa(int(t.Period/time.Second)))
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+
+	return uri.String()
+}
+
+// code is the HOTP value of RFC 4226 for the counter step.
+func (t *TOTP) code(key []byte, step int64) string {
+	var counter [8]byte
+
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+
+	for i := 0; i < t.Digits; i++ {
+		modulus *= 10
+	}
+
+	code := strconv.FormatUint(uint64(value%modulus), 10)
+
+	return strings.Repeat("0", t.Digits-len(code)) + code
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+
+	return key, nil
+}
@@ -0,0 +1,113 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the test vectors in appendix B of RFC 6238.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	generator := New()
+	generator.Digits = 8
+
+	for _, tt := range tests {
+		got, err := generator.Code(rfcSecret, time.Unix(tt.unix, 0))
+
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	generator := New()
+	generator.Now = func() time.Time { return now }
+
+	tests := []struct {
+		name   string
+		at     time.Time
+		wantOk bool
+	}{
+		{"current period", now, true},
+		{"previous period", now.Add(-30 * time.Second), true},
+		{"next period", now.Add(30 * time.Second), true},
+		{"two periods ago", now.Add(-60 * time.Second), false},
+		{"two periods ahead", now.Add(60 * time.Second), false},
+	}
+
+	for _, tt := range tests {
+		code, err := generator.Code(rfcSecret, tt.at)
+
+		if err != nil {
+			t.Fatalf("%s: Code: %v", tt.name, err)
+		}
+
+		step, ok, err := generator.Verify(rfcSecret, code)
+
+		if err != nil {
+			t.Fatalf("%s: Verify: %v", tt.name, err)
+		}
+
+		if ok != tt.wantOk {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, ok, tt.wantOk)
+		}
+
+		if ok && step != generator.Step(tt.at) {
+			t.Errorf("%s: Verify step = %d, want %d", tt.name, step, generator.Step(tt.at))
+		}
+	}
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok, _ := generator.Verify(rfcSecret, code); ok {
+			t.Errorf("Verify(%q) accepted a malformed code", code)
+		}
+	}
+
+	if _, _, err := generator.Verify("not base32!", "123456"); err != ErrInvalidSecret {
+		t.Errorf("Verify with a bad secret: got %v, want ErrInvalidSecret", err)
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+
+	key, err := decodeSecret(secret)
+
+	if err != nil || len(key) != SecretBytes {
+		t.Errorf("GenerateSecret = %q, want %d base32 encoded bytes", secret, SecretBytes)
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := New().URI("JBSWY3DPEHPK3PXP", "SVB", "ada@example.com")
+	want := "otpauth://totp/SVB:ada@example.com?algorithm=SHA1&digits=6&issuer=SVB&period=30&secret=JBSWY3DPEHPK3PXP"
+
+	if got != want {
+		t.Errorf("URI = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pipeline1987/SVB/models"
+	"github.com/pipeline1987/SVB/repositories"
+	"github.com/pipeline1987/SVB/server"
+	"github.com/segmentio/ksuid"
+)
+
+const usersUsage = "usage: svb users unlock <email> | role <email> customer|support|admin"
+
+// Users runs the users subcommand against the database at dbHost.
+func Users(ctx context.Context, dbHost string, args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "unlock":
+	case len(args) == 3 && args[0] == "role":
+		if !models.Role(args[2]).IsValid() {
+			return models.ErrInvalidRole
+		}
+	default:
+		return errors.New(usersUsage)
+	}
+
+	repo, repoErr := server.NewRepository(ctx, dbHost)
+
+	if repoErr != nil {
+		return repoErr
+	}
+
+	defer repo.Close()
+
+	if args[0] == "role" {
+		return setRole(ctx, repo, args[1], models.Role(args[2]))
+	}
+
+	return unlockUser(ctx, repo, args[1])
+}
+
+// setRole gives the user with email role. It is how the first admin is
+// made, so there is no API for it.
+func setRole(ctx context.Context, repo repositories.Repository, email string, role models.Role) error {
+	user, err := repo.ReadUserByEmail(ctx, email)
+
+	if errors.Is(err, repositories.ErrNotFound) {
+		return fmt.Errorf("no user has the email %s", email)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	event, err := newAuditEvent(models.AuditRoleChanged, user.Id)
+
+	if err != nil {
+		return err
+	}
+
+	if err = repo.SetUserRole(ctx, user.Id, role, event); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now %s\n", email, role)
+
+	return nil
+}
+
+// unlockUser forgets the failed sign ins against email, so its owner can
+// sign in again before the lockout ends, and records who was unlocked.
+func unlockUser(ctx context.Context, repo repositories.Repository, email string) error {
+	key := models.EmailLoginKey(email)
+
+	if _, err := repo.GetLoginThrottle(ctx, key); errors.Is(err, repositories.ErrNotFound) {
+		fmt.Printf("%s has no failed sign ins\n", email)
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	event, err := newAuditEvent(models.AuditLoginUnlocked, key)
+
+	if err != nil {
+		return err
+	}
+
+	if err = repo.ClearLoginThrottle(ctx, key, event); err != nil {
+		return err
+	}
+
+	fmt.Printf("unlocked %s\n", email)
+
+	return nil
+}
+
+// newAuditEvent returns the record of action on subject as done from the
+// command line, which has no actor or address.
+func newAuditEvent(action string, subject string) (*models.AuditEvent, error) {
+	id, err := ksuid.NewRandom()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuditEvent{
+		Id:        id.String(),
+		Action:    action,
+		Subject:   subject,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,93 @@
+// Package validation collects the field errors of a request so they can all
+// be reported at once.
+package validation
+
+import (
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pipeline1987/SVB/repositories"
+)
+
+// FieldError explains why one field of a request was rejected. Field is the
+// JSON name of the field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is returned by Validate methods when fields are invalid. It is a kind
+// of repositories.ErrValidation.
+type Error struct {
+	Fields []FieldError
+}
+
+func (e *Error) Error() string {
+	var messages []string
+
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+func (e *Error) Unwrap() error {
+	return repositories.ErrValidation
+}
+
+// Validator records field errors as checks run. The zero value is ready to
+// use.
+type Validator struct {
+	fields []FieldError
+}
+
+// Check records message against field unless ok holds. Only the first error
+// of every field is kept.
+func (v *Validator) Check(ok bool, field string, message string) {
+	if ok {
+		return
+	}
+
+	for _, existing := range v.fields {
+		if existing.Field == field {
+			return
+		}
+	}
+
+	v.fields = append(v.fields, FieldError{Field: field, Message: message})
+}
+
+// Required checks that value is not blank.
+func (v *Validator) Required(value string, field string) {
+	v.Check(strings.TrimSpace(value) != "", field, "is required")
+}
+
+// MaxLength checks that value is at most max characters long.
+func (v *Validator) MaxLength(value string, max int, field string) {
+	v.Check(utf8.RuneCountInString(value) <= max, field, "must be at most "+strconv.Itoa(max)+" characters long")
+}
+
+// MinLength checks that value is at least min characters long.
+func (v *Validator) MinLength(value string, min int, field string) {
+	v.Check(utf8.RuneCountInString(value) >= min, field, "must be at least "+strconv.Itoa(min)+" characters long")
+}
+
+// Email checks that value is a bare address such as ada@example.com.
+func (v *Validator) Email(value string, field string) {
+	address, err := mail.ParseAddress(value)
+
+	v.Check(err == nil && address.Address == value, field, "must be a valid email address")
+}
+
+// Err returns an *Error with every recorded field error, or nil when there
+// is none.
+func (v *Validator) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+
+	return &Error{Fields: v.fields}
+}